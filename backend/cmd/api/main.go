@@ -5,13 +5,19 @@ import (
 	"log"
 	"os"
 	"time"
+	_ "time/tzdata" // store time zone without relying on the host's zoneinfo
 
 	"github.com/fastspot/backend/configs"
 	"github.com/fastspot/backend/internal/handlers"
 	"github.com/fastspot/backend/internal/middleware"
+	"github.com/fastspot/backend/internal/models"
 	"github.com/fastspot/backend/internal/repository"
 	"github.com/fastspot/backend/internal/services/ai"
+	"github.com/fastspot/backend/internal/services/analytics"
+	"github.com/fastspot/backend/internal/services/eta"
 	"github.com/fastspot/backend/internal/services/payments"
+	"github.com/fastspot/backend/internal/services/scheduling"
+	"github.com/fastspot/backend/internal/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -48,20 +54,72 @@ func main() {
 	db := client.Database("fastspot")
 
 	// Initialize repositories
-	repos := &repository.Repositories{
-		Users:         repository.NewUserRepository(db),
-		Categories:    repository.NewCategoryRepository(db),
-		Products:      repository.NewProductRepository(db),
-		Promotions:    repository.NewPromotionRepository(db),
-		Carts:         repository.NewCartRepository(db),
-		Orders:        repository.NewOrderRepository(db),
-		MoodQuestions: repository.NewMoodQuestionRepository(db),
-		AISessions:    repository.NewAISessionRepository(db),
+	repos := repository.NewRepositories(db)
+
+	if err := repos.Users.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Failed to create user indexes:", err)
+	}
+	if kept, err := repos.Users.NormalizeEmails(ctx, utils.NormalizeEmail); err != nil {
+		log.Fatal("Failed to normalize user emails:", err)
+	} else if kept > 0 {
+		log.Printf("Kept the emails of %d accounts as entered, their normalized email belongs to another account", kept)
+	}
+	if err := repos.Orders.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Failed to create order indexes:", err)
+	}
+	if err := repos.PromoCodes.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Failed to create promo code indexes:", err)
+	}
+	if err := repos.PromoCodes.MigrateRedeemedBy(ctx); err != nil {
+		log.Fatal("Failed to migrate promo code redemptions:", err)
+	}
+	if err := repos.RefreshTokens.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Failed to create refresh token indexes:", err)
+	}
+	if err := repos.RevokedTokens.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Failed to create revoked token indexes:", err)
+	}
+	if err := repos.Idempotency.EnsureIndexes(ctx); err != nil {
+		log.Fatal("Failed to create idempotency key indexes:", err)
 	}
 
 	// Initialize services
 	geminiService := ai.NewGeminiService(config.GeminiAPIKey)
-	paymentService := payments.NewStubProvider()
+	var paymentService payments.PaymentProvider
+	switch config.PaymentsProvider {
+	case "stub":
+		paymentService = payments.NewStubProvider()
+	case "gateway":
+		paymentService = payments.NewGatewayProvider(config.PaymentsGatewayURL, config.PaymentsGatewayAPIKey)
+	default:
+		log.Fatalf("Unknown payments provider %q", config.PaymentsProvider)
+	}
+	analyticsService := analytics.NewService(repos.Orders, time.Minute)
+
+	// Opening hours from the environment apply until an admin edits them
+	weeklyHours, err := scheduling.ParseWeeklyHours(config.StoreHours)
+	if err != nil {
+		log.Fatal("Invalid store hours:", err)
+	}
+	calendar, err := scheduling.NewCalendar(repos.StoreSettings, models.StoreSettings{
+		ID:          models.StoreSettingsID,
+		Timezone:    config.StoreTimezone,
+		WeeklyHours: weeklyHours,
+		Holidays:    []models.HolidayException{},
+	}, 30*time.Second)
+	if err != nil {
+		log.Fatal("Invalid store hours:", err)
+	}
+
+	estimator := eta.NewEstimator(repos.Orders, eta.Config{
+		DefaultPrepMinutes: config.DefaultPrepMinutes,
+		KitchenCapacity:    config.KitchenCapacity,
+		MinutesPerOrder:    config.MinutesPerOrder,
+		DeliveryMinutes:    config.DeliveryMinutes,
+	})
+
+	// Sends scheduled orders to the kitchen when their time comes
+	go scheduling.NewScheduler(repos.Orders, estimator, 30*time.Second).Run(context.Background())
 
 	// Initialize Gin router
 	if config.GinMode == "release" {
@@ -73,127 +131,22 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     config.AllowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Session-ID"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", middleware.IdempotencyHeader},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok", "message": "FastSpot API is running"})
+	handlers.RegisterRoutes(router, handlers.Dependencies{
+		Config:    config,
+		Repos:     repos,
+		Payments:  paymentService,
+		Gemini:    geminiService,
+		Analytics: analyticsService,
+		Calendar:  calendar,
+		Estimator: estimator,
 	})
 
-	// API v1 routes
-	v1 := router.Group("/api/v1")
-	{
-		// Auth routes
-		authHandler := handlers.NewAuthHandler(repos, config)
-		auth := v1.Group("/auth")
-		{
-			auth.POST("/guest", authHandler.CreateGuestSession)
-			auth.POST("/login", authHandler.Login)
-			auth.POST("/register", authHandler.Register)
-			auth.GET("/me", middleware.AuthMiddleware(config.JWTSecret), authHandler.GetCurrentUser)
-			auth.POST("/logout", middleware.AuthMiddleware(config.JWTSecret), authHandler.Logout)
-		}
-
-		// Categories routes (public read, admin write)
-		categoryHandler := handlers.NewCategoryHandler(repos)
-		categories := v1.Group("/categories")
-		{
-			categories.GET("", categoryHandler.GetAll)
-			categories.GET("/:slug", categoryHandler.GetBySlug)
-		}
-		adminCategories := v1.Group("/admin/categories", middleware.AuthMiddleware(config.JWTSecret), middleware.AdminMiddleware())
-		{
-			adminCategories.POST("", categoryHandler.Create)
-			adminCategories.GET("/:id", categoryHandler.GetByID)
-			adminCategories.PUT("/:id", categoryHandler.Update)
-			adminCategories.DELETE("/:id", categoryHandler.Delete)
-		}
-
-		// Products routes
-		productHandler := handlers.NewProductHandler(repos)
-		products := v1.Group("/products")
-		{
-			products.GET("", productHandler.GetAll)
-			products.GET("/:slug", productHandler.GetBySlug)
-		}
-		adminProducts := v1.Group("/admin/products", middleware.AuthMiddleware(config.JWTSecret), middleware.AdminMiddleware())
-		{
-			adminProducts.GET("", productHandler.GetAll)
-			adminProducts.POST("", productHandler.Create)
-			adminProducts.GET("/:id", productHandler.GetByID)
-			adminProducts.PUT("/:id", productHandler.Update)
-			adminProducts.DELETE("/:id", productHandler.Delete)
-		}
-
-		// Promotions routes
-		promotionHandler := handlers.NewPromotionHandler(repos)
-		promotions := v1.Group("/promotions")
-		{
-			promotions.GET("", promotionHandler.GetAll)
-			promotions.GET("/:id", promotionHandler.GetByID)
-		}
-		adminPromotions := v1.Group("/admin/promotions", middleware.AuthMiddleware(config.JWTSecret), middleware.AdminMiddleware())
-		{
-			adminPromotions.POST("", promotionHandler.Create)
-			adminPromotions.PUT("/:id", promotionHandler.Update)
-			adminPromotions.DELETE("/:id", promotionHandler.Delete)
-		}
-
-		// Cart routes
-		cartHandler := handlers.NewCartHandler(repos)
-		cart := v1.Group("/cart", middleware.OptionalAuthMiddleware(config.JWTSecret))
-		{
-			cart.GET("", cartHandler.Get)
-			cart.POST("/items", cartHandler.AddItem)
-			cart.PUT("/items/:productId", cartHandler.UpdateItem)
-			cart.DELETE("/items/:productId", cartHandler.RemoveItem)
-			cart.DELETE("", cartHandler.Clear)
-		}
-
-		// Orders routes
-		orderHandler := handlers.NewOrderHandler(repos, paymentService)
-		orders := v1.Group("/orders", middleware.OptionalAuthMiddleware(config.JWTSecret))
-		{
-			orders.POST("", orderHandler.Create)
-			orders.GET("", orderHandler.GetAll)
-			orders.GET("/:id", orderHandler.GetByID)
-			orders.POST("/:id/cancel", orderHandler.Cancel)
-		}
-		adminOrders := v1.Group("/admin/orders", middleware.AuthMiddleware(config.JWTSecret), middleware.AdminMiddleware())
-		{
-			adminOrders.GET("", orderHandler.GetAllAdmin)
-			adminOrders.PUT("/:id/status", orderHandler.UpdateStatus)
-		}
-
-		// Mood Quiz routes
-		moodHandler := handlers.NewMoodHandler(repos, geminiService)
-		mood := v1.Group("/mood")
-		{
-			mood.GET("/questions", moodHandler.GetQuestions)
-			mood.POST("/recommend", moodHandler.GetRecommendations) // No auth required for guest users
-		}
-		// Mood Quiz Management (Questions only - AI decides recommendations)
-		adminMood := v1.Group("/admin/mood", middleware.AuthMiddleware(config.JWTSecret), middleware.AdminMiddleware())
-		{
-			adminMood.GET("/questions", moodHandler.GetAllQuestions)
-			adminMood.GET("/questions/:id", moodHandler.GetQuestionByID)
-			adminMood.POST("/questions", moodHandler.CreateQuestion)
-			adminMood.PUT("/questions/:id", moodHandler.UpdateQuestion)
-			adminMood.DELETE("/questions/:id", moodHandler.DeleteQuestion)
-		}
-
-		// Admin dashboard
-		adminHandler := handlers.NewAdminHandler(repos)
-		admin := v1.Group("/admin", middleware.AuthMiddleware(config.JWTSecret), middleware.AdminMiddleware())
-		{
-			admin.GET("/analytics", adminHandler.GetAnalytics)
-		}
-	}
-
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
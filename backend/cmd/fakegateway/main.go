@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fastspot/backend/internal/services/payments/fakegateway"
+	"github.com/joho/godotenv"
+)
+
+// The fake payment gateway for local development and integration tests.
+// Run the API with PAYMENTS_PROVIDER=gateway to use it.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	settleDelay, err := time.ParseDuration(getEnv("FAKE_GATEWAY_SETTLE_DELAY", "5s"))
+	if err != nil {
+		settleDelay = 5 * time.Second
+	}
+
+	server := fakegateway.NewServer(fakegateway.Config{
+		APIKey:        getEnv("PAYMENTS_GATEWAY_API_KEY", ""),
+		WebhookURL:    getEnv("FAKE_GATEWAY_WEBHOOK_URL", "http://localhost:3000/api/v1/payments/webhook"),
+		WebhookSecret: getEnv("PAYMENTS_WEBHOOK_SECRET", ""),
+		SettleDelay:   settleDelay,
+	})
+
+	port := getEnv("FAKE_GATEWAY_PORT", "4242")
+	log.Printf("💳 Fake payment gateway listening on port %s", port)
+	if err := http.ListenAndServe(":"+port, server.Handler()); err != nil {
+		log.Fatal("Failed to start fake gateway:", err)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
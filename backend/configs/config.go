@@ -2,6 +2,7 @@ package configs
 
 import (
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,14 +17,31 @@ type Config struct {
 	MongoURI string
 
 	// JWT
-	JWTSecret     string
-	JWTExpiration time.Duration
+	JWTSecret              string
+	JWTExpiration          time.Duration // access token lifetime
+	RefreshTokenExpiration time.Duration
+
+	// Guest sessions
+	GuestSessionExpiration time.Duration
 
 	// Gemini AI
 	GeminiAPIKey string
 
 	// Payments
-	PaymentsProvider string
+	PaymentsProvider      string // stub, gateway
+	PaymentsGatewayURL    string
+	PaymentsGatewayAPIKey string
+	PaymentsWebhookSecret string
+
+	// Store
+	StoreHours    string // e.g. "mon-fri 11:00-22:00; sat-sun 12:00-23:00"
+	StoreTimezone string // IANA time zone the hours are in
+
+	// Kitchen and delivery timings for ETAs, in minutes
+	DefaultPrepMinutes int
+	KitchenCapacity    int // orders prepared at the same time
+	MinutesPerOrder    int
+	DeliveryMinutes    int // plus the ETA offset of the delivery zone
 
 	// CORS
 	AllowedOrigins []string
@@ -33,9 +51,22 @@ type Config struct {
 func LoadConfig() *Config {
 	jwtExpStr := os.Getenv("JWT_EXPIRATION")
 	if jwtExpStr == "" {
-		jwtExpStr = "24h"
+		jwtExpStr = "15m"
+	}
+	jwtExp, err := time.ParseDuration(jwtExpStr)
+	if err != nil {
+		jwtExp = 15 * time.Minute
+	}
+
+	refreshExp, err := time.ParseDuration(getEnv("REFRESH_TOKEN_EXPIRATION", "720h"))
+	if err != nil {
+		refreshExp = 720 * time.Hour
+	}
+
+	guestExp, err := time.ParseDuration(getEnv("GUEST_SESSION_EXPIRATION", "720h"))
+	if err != nil {
+		guestExp = 720 * time.Hour
 	}
-	jwtExp, _ := time.ParseDuration(jwtExpStr)
 
 	originsStr := os.Getenv("ALLOWED_ORIGINS")
 	if originsStr == "" {
@@ -44,15 +75,34 @@ func LoadConfig() *Config {
 	origins := strings.Split(originsStr, ",")
 
 	return &Config{
-		Port:             getEnv("PORT", "3000"),
-		GinMode:          getEnv("GIN_MODE", "debug"),
-		MongoURI:         getEnv("MONGODB_URI", "mongodb://localhost:27017/fastspot"),
-		JWTSecret:        getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		JWTExpiration:    jwtExp,
-		GeminiAPIKey:     getEnv("GEMINI_API_KEY", ""),
-		PaymentsProvider: getEnv("PAYMENTS_PROVIDER", "stub"),
-		AllowedOrigins:   origins,
+		Port:                   getEnv("PORT", "3000"),
+		GinMode:                getEnv("GIN_MODE", "debug"),
+		MongoURI:               getEnv("MONGODB_URI", "mongodb://localhost:27017/fastspot"),
+		JWTSecret:              getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		JWTExpiration:          jwtExp,
+		RefreshTokenExpiration: refreshExp,
+		GuestSessionExpiration: guestExp,
+		GeminiAPIKey:           getEnv("GEMINI_API_KEY", ""),
+		PaymentsProvider:       getEnv("PAYMENTS_PROVIDER", "stub"),
+		PaymentsGatewayURL:     getEnv("PAYMENTS_GATEWAY_URL", "http://localhost:4242"),
+		PaymentsGatewayAPIKey:  getEnv("PAYMENTS_GATEWAY_API_KEY", ""),
+		PaymentsWebhookSecret:  getEnv("PAYMENTS_WEBHOOK_SECRET", ""),
+		StoreHours:             getEnv("STORE_HOURS", "mon-sun 10:00-22:00"),
+		StoreTimezone:          getEnv("STORE_TIMEZONE", "UTC"),
+		DefaultPrepMinutes:     getEnvInt("KITCHEN_DEFAULT_PREP_MINUTES", 12),
+		KitchenCapacity:        getEnvInt("KITCHEN_CAPACITY", 3),
+		MinutesPerOrder:        getEnvInt("KITCHEN_MINUTES_PER_ORDER", 10),
+		DeliveryMinutes:        getEnvInt("DELIVERY_MINUTES", 25),
+		AllowedOrigins:         origins,
+	}
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
 	}
+	return value
 }
 
 func getEnv(key, defaultValue string) string {
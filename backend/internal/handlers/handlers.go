@@ -2,20 +2,37 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fastspot/backend/configs"
+	"github.com/fastspot/backend/internal/middleware"
 	"github.com/fastspot/backend/internal/models"
 	"github.com/fastspot/backend/internal/repository"
 	"github.com/fastspot/backend/internal/services/ai"
+	"github.com/fastspot/backend/internal/services/analytics"
+	"github.com/fastspot/backend/internal/services/eta"
+	"github.com/fastspot/backend/internal/services/orderflow"
 	"github.com/fastspot/backend/internal/services/payments"
+	"github.com/fastspot/backend/internal/services/pricing"
+	"github.com/fastspot/backend/internal/services/scheduling"
+	"github.com/fastspot/backend/internal/services/zones"
 	"github.com/fastspot/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Auth Handler
@@ -28,8 +45,26 @@ func NewAuthHandler(repos *repository.Repositories, config *configs.Config) *Aut
 	return &AuthHandler{repos: repos, config: config}
 }
 
+// CreateGuestSession issues a signed guest token bound to a fresh session ID
 func (h *AuthHandler) CreateGuestSession(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Guest session handled by frontend"})
+	sessionID, err := utils.NewSessionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	token, err := utils.GenerateGuestJWT(sessionID, h.config.JWTSecret, h.config.GuestSessionExpiration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"sessionId": sessionID,
+		"token":     token,
+		"expiresAt": time.Now().Add(h.config.GuestSessionExpiration),
+	})
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -46,8 +81,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Find user by email
-	user, err := h.repos.Users.FindByEmail(ctx, req.Email)
+	// Find user by email. Accounts whose email could not be normalized
+	// because another account took the normalized one keep it as entered.
+	user, err := h.repos.Users.FindByEmail(ctx, utils.NormalizeEmail(req.Email))
+	if err == mongo.ErrNoDocuments && strings.TrimSpace(req.Email) != utils.NormalizeEmail(req.Email) {
+		user, err = h.repos.Users.FindByEmail(ctx, strings.TrimSpace(req.Email))
+	}
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
@@ -63,35 +102,375 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID.Hex(), user.Email, user.Role, h.config.JWTSecret)
+	// Generate access and refresh tokens
+	tokens, err := h.issueTokens(ctx, user, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"token":   token,
-		"user": gin.H{
-			"id":    user.ID.Hex(),
-			"email": user.Email,
-			"name":  user.Name,
-			"role":  user.Role,
-		},
-	})
+	h.adoptGuestSession(ctx, c, user)
+
+	tokens["success"] = true
+	tokens["user"] = publicUser(user)
+	c.JSON(http.StatusOK, tokens)
 }
 
+// Register creates a customer account and logs it in
 func (h *AuthHandler) Register(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Registration not available"})
+	var req models.RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := utils.NormalizeEmail(req.Email)
+
+	// Reject already registered emails (the unique index catches races)
+	if _, err := h.repos.Users.FindByEmail(ctx, email); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	user := &models.User{
+		Role:         models.RoleCustomer,
+		Name:         strings.TrimSpace(req.Name),
+		Email:        email,
+		Phone:        strings.TrimSpace(req.Phone),
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	user, err = h.repos.Users.Create(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, user, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	h.adoptGuestSession(ctx, c, user)
+
+	tokens["success"] = true
+	tokens["user"] = publicUser(user)
+	c.JSON(http.StatusCreated, tokens)
+}
+
+// Refresh rotates a refresh token and returns a new token pair. Presenting an
+// already rotated token revokes the whole family, as it signals token theft.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stored, err := h.repos.RefreshTokens.FindByHash(ctx, utils.HashToken(req.RefreshToken))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired"})
+		return
+	}
+
+	consumed := false
+	if stored.UsedAt == nil && stored.RevokedAt == nil {
+		consumed, err = h.repos.RefreshTokens.MarkUsed(ctx, stored.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+	}
+	if !consumed {
+		if err := h.revokeFamily(ctx, stored.FamilyID); err != nil {
+			log.Printf("Failed to revoke token family %s: %v", stored.FamilyID, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected"})
+		return
+	}
+
+	userOID, err := primitive.ObjectIDFromHex(stored.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	user, err := h.repos.Users.FindByID(ctx, userOID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+
+	tokens, err := h.issueTokens(ctx, user, stored.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	tokens["success"] = true
+	c.JSON(http.StatusOK, tokens)
 }
 
+// GetCurrentUser returns the authenticated user's profile
 func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userOID, err := primitive.ObjectIDFromHex(middleware.GetIdentity(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	user, err := h.repos.Users.FindByID(ctx, userOID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": user})
+}
+
+// UpdateCurrentUser updates the authenticated user's name and phone
+func (h *AuthHandler) UpdateCurrentUser(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userOID, err := primitive.ObjectIDFromHex(middleware.GetIdentity(c).UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user"})
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	fields := bson.M{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+			return
+		}
+		fields["name"] = name
+	}
+	if req.Phone != nil {
+		fields["phone"] = strings.TrimSpace(*req.Phone)
+	}
+	if len(fields) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	user, err := h.repos.Users.UpdateProfile(ctx, userOID, fields)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": user})
 }
 
+// Logout revokes the current access token and its refresh token family
 func (h *AuthHandler) Logout(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logout handled by frontend"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	claims, ok := middleware.GetClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	expiresAt := time.Now().Add(h.config.JWTExpiration)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := h.repos.RevokedTokens.RevokeTokenID(ctx, claims.ID, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	if claims.FamilyID != "" {
+		if err := h.revokeFamily(ctx, claims.FamilyID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Logged out"})
+}
+
+// issueTokens creates an access token and a refresh token for the user. An
+// empty familyID starts a new refresh token family.
+func (h *AuthHandler) issueTokens(ctx context.Context, user *models.User, familyID string) (gin.H, error) {
+	if familyID == "" {
+		var err error
+		if familyID, err = utils.NewTokenID(); err != nil {
+			return nil, err
+		}
+	}
+
+	accessToken, err := utils.GenerateJWT(user.ID.Hex(), user.Email, user.Role, familyID, h.config.JWTSecret, h.config.JWTExpiration)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.NewTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.repos.RefreshTokens.Create(ctx, &models.RefreshToken{
+		TokenHash: utils.HashToken(refreshToken),
+		FamilyID:  familyID,
+		UserID:    user.ID.Hex(),
+		ExpiresAt: time.Now().Add(h.config.RefreshTokenExpiration),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"token":        accessToken,
+		"refreshToken": refreshToken,
+		"expiresIn":    int(h.config.JWTExpiration.Seconds()),
+	}, nil
+}
+
+// revokeFamily revokes all refresh tokens of a family and every access token issued with them
+func (h *AuthHandler) revokeFamily(ctx context.Context, familyID string) error {
+	if err := h.repos.RefreshTokens.RevokeFamily(ctx, familyID); err != nil {
+		return err
+	}
+	return h.repos.RevokedTokens.RevokeFamily(ctx, familyID, time.Now().Add(h.config.JWTExpiration))
+}
+
+// adoptGuestSession moves the cart, orders and quiz sessions of the guest token
+// the request was made with into the user's account. Failures are logged only,
+// so a merge problem never blocks a login.
+func (h *AuthHandler) adoptGuestSession(ctx context.Context, c *gin.Context, user *models.User) {
+	identity := middleware.GetIdentity(c)
+	if !identity.IsGuest() {
+		return
+	}
+	sessionID := identity.SessionID
+	userID := user.ID.Hex()
+
+	if err := h.mergeGuestCart(ctx, sessionID, userID); err != nil {
+		log.Printf("Failed to merge guest cart %s into user %s: %v", sessionID, userID, err)
+	}
+	if err := h.repos.Orders.ReassignSession(ctx, sessionID, userID); err != nil {
+		log.Printf("Failed to reassign guest orders %s to user %s: %v", sessionID, userID, err)
+	}
+	if err := h.repos.AISessions.ReassignSession(ctx, sessionID, userID); err != nil {
+		log.Printf("Failed to reassign quiz sessions %s to user %s: %v", sessionID, userID, err)
+	}
+}
+
+// mergeGuestCart moves the guest cart into the user's cart, combining the
+// quantities of lines with the same configuration
+func (h *AuthHandler) mergeGuestCart(ctx context.Context, sessionID, userID string) error {
+	guestCart, err := h.repos.Carts.FindBySessionID(ctx, sessionID)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	userCart, err := h.repos.Carts.FindByUserID(ctx, userID)
+	if err == mongo.ErrNoDocuments {
+		// No cart yet, the guest cart simply becomes the user's cart
+		return h.repos.Carts.AssignToUser(ctx, guestCart.ID, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	pricing.EnsureLineIDs(guestCart)
+	pricing.EnsureLineIDs(userCart)
+
+	for _, guestItem := range guestCart.Items {
+		merged := false
+		for i, item := range userCart.Items {
+			if item.LineID == guestItem.LineID {
+				userCart.Items[i].Qty += guestItem.Qty
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			userCart.Items = append(userCart.Items, guestItem)
+		}
+	}
+
+	if userCart.PromoCode == "" {
+		userCart.PromoCode = guestCart.PromoCode
+	}
+
+	if err := priceCart(ctx, h.repos, userCart); err != nil {
+		return err
+	}
+	userCart.UpdatedAt = time.Now()
+
+	if err := h.repos.Carts.Update(ctx, userCart); err != nil {
+		return err
+	}
+	return h.repos.Carts.Delete(ctx, guestCart.ID)
+}
+
+// publicUser returns the user fields included in auth responses
+func publicUser(user *models.User) gin.H {
+	return gin.H{
+		"id":    user.ID.Hex(),
+		"email": user.Email,
+		"name":  user.Name,
+		"phone": user.Phone,
+		"role":  user.Role,
+	}
 }
 
 // Category Handler
@@ -343,6 +722,10 @@ func (h *ProductHandler) Create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
+	if product.PrepMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Prep time must not be negative"})
+		return
+	}
 
 	// Set timestamps
 	product.CreatedAt = time.Now()
@@ -382,6 +765,10 @@ func (h *ProductHandler) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
+	if updates.PrepMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Prep time must not be negative"})
+		return
+	}
 
 	// Set update timestamp
 	updates.UpdatedAt = time.Now()
@@ -485,6 +872,13 @@ func (h *PromotionHandler) Create(c *gin.Context) {
 		return
 	}
 
+	if promotion.Discount != nil {
+		if err := pricing.ValidateDiscount(promotion.Discount); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discount", "details": err.Error()})
+			return
+		}
+	}
+
 	promotion.CreatedAt = time.Now()
 	promotion.UpdatedAt = time.Now()
 
@@ -515,6 +909,13 @@ func (h *PromotionHandler) Update(c *gin.Context) {
 		return
 	}
 
+	if updates.Discount != nil {
+		if err := pricing.ValidateDiscount(updates.Discount); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discount", "details": err.Error()})
+			return
+		}
+	}
+
 	updates.UpdatedAt = time.Now()
 	updates.ID = objectID
 
@@ -556,587 +957,2764 @@ func (h *PromotionHandler) Delete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Promotion deleted successfully"})
 }
 
-// Cart Handler
-type CartHandler struct {
+// PromoCode Handler
+type PromoCodeHandler struct {
 	repos *repository.Repositories
 }
 
-func NewCartHandler(repos *repository.Repositories) *CartHandler {
-	return &CartHandler{repos: repos}
+func NewPromoCodeHandler(repos *repository.Repositories) *PromoCodeHandler {
+	return &PromoCodeHandler{repos: repos}
 }
 
-// Get returns the current user's cart
-func (h *CartHandler) Get(c *gin.Context) {
-	ctx := c.Request.Context()
+// GetAll returns all promo codes with their redemption counts (Admin)
+func (h *PromoCodeHandler) GetAll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	// Get user/session ID from context (set by auth middleware)
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
+	codes, err := h.repos.PromoCodes.FindAll(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch promo codes"})
+		return
+	}
 
-	var cart *models.Cart
-	var err error
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": codes})
+}
 
-	// Try to find cart by user ID or session ID
-	if hasUserID && userID != "" {
-		cart, err = h.repos.Carts.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		cart, err = h.repos.Carts.FindBySessionID(ctx, sessionID.(string))
-	} else {
-		// No user or session, return empty cart
-		c.JSON(200, gin.H{
-			"success": true,
-			"data": gin.H{
-				"items":    []models.CartItem{},
-				"totalUSD": 0,
-				"currency": "USD",
-			},
-		})
+// Create creates a new promo code for a promotion (Admin)
+func (h *PromoCodeHandler) Create(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var req models.PromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
 
-	// If cart not found, return empty cart
+	code := normalizePromoCode(req.Code)
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+
+	promotionID, err := primitive.ObjectIDFromHex(req.PromotionID)
 	if err != nil {
-		c.JSON(200, gin.H{
-			"success": true,
-			"data": gin.H{
-				"items":    []models.CartItem{},
-				"totalUSD": 0,
-				"currency": "USD",
-			},
-		})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion ID"})
+		return
+	}
+	if _, err := h.repos.Promotions.FindByID(ctx, promotionID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Promotion not found"})
 		return
 	}
 
+	promoCode := models.PromoCode{
+		Code:        code,
+		PromotionID: promotionID,
+		ExpiresAt:   req.ExpiresAt,
+		IsActive:    true,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if req.MaxRedemptions != nil {
+		promoCode.MaxRedemptions = *req.MaxRedemptions
+	}
+	if req.MaxPerUser != nil {
+		promoCode.MaxPerUser = *req.MaxPerUser
+	}
+	if req.IsActive != nil {
+		promoCode.IsActive = *req.IsActive
+	}
+	if promoCode.MaxRedemptions < 0 || promoCode.MaxPerUser < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits must not be negative"})
+		return
+	}
+
+	createdCode, err := h.repos.PromoCodes.Create(ctx, &promoCode)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Promo code already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promo code", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": createdCode})
+}
+
+// Update changes the limits, expiry or active flag of a promo code (Admin).
+// The code and its promotion cannot change once customers may hold it.
+func (h *PromoCodeHandler) Update(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	var req models.PromoCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	fields := bson.M{}
+	if req.MaxRedemptions != nil {
+		if *req.MaxRedemptions < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limits must not be negative"})
+			return
+		}
+		fields["maxRedemptions"] = *req.MaxRedemptions
+	}
+	if req.MaxPerUser != nil {
+		if *req.MaxPerUser < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limits must not be negative"})
+			return
+		}
+		fields["maxPerUser"] = *req.MaxPerUser
+	}
+	var unset []string
+	switch {
+	case req.ClearExpiresAt && req.ExpiresAt != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set expiresAt or clear it, not both"})
+		return
+	case req.ClearExpiresAt:
+		unset = append(unset, "expiresAt")
+	case req.ExpiresAt != nil:
+		fields["expiresAt"] = *req.ExpiresAt
+	}
+	if req.IsActive != nil {
+		fields["isActive"] = *req.IsActive
+	}
+
+	updatedCode, err := h.repos.PromoCodes.Update(ctx, objectID, fields, unset...)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update promo code", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": updatedCode})
+}
+
+// Delete deletes a promo code (Admin)
+func (h *PromoCodeHandler) Delete(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	if err := h.repos.PromoCodes.Delete(ctx, objectID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete promo code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Promo code deleted successfully"})
+}
+
+// DeliveryZone Handler
+type DeliveryZoneHandler struct {
+	repos *repository.Repositories
+}
+
+func NewDeliveryZoneHandler(repos *repository.Repositories) *DeliveryZoneHandler {
+	return &DeliveryZoneHandler{repos: repos}
+}
+
+// GetAll returns all delivery zones (Admin)
+func (h *DeliveryZoneHandler) GetAll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deliveryZones, err := h.repos.DeliveryZones.FindAll(ctx, bson.M{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery zones"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": deliveryZones})
+}
+
+// GetByID returns a delivery zone (Admin)
+func (h *DeliveryZoneHandler) GetByID(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery zone ID"})
+		return
+	}
+
+	zone, err := h.repos.DeliveryZones.FindByID(ctx, objectID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery zone"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": zone})
+}
+
+// Create creates a delivery zone (Admin)
+func (h *DeliveryZoneHandler) Create(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	zone, ok := bindDeliveryZone(c)
+	if !ok {
+		return
+	}
+	zone.CreatedAt = time.Now()
+	zone.UpdatedAt = time.Now()
+
+	createdZone, err := h.repos.DeliveryZones.Create(ctx, zone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create delivery zone", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": createdZone})
+}
+
+// Update replaces a delivery zone (Admin)
+func (h *DeliveryZoneHandler) Update(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery zone ID"})
+		return
+	}
+
+	zone, ok := bindDeliveryZone(c)
+	if !ok {
+		return
+	}
+
+	updatedZone, err := h.repos.DeliveryZones.Replace(ctx, objectID, zone)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update delivery zone", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": updatedZone})
+}
+
+// Delete deletes a delivery zone (Admin)
+func (h *DeliveryZoneHandler) Delete(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery zone ID"})
+		return
+	}
+
+	if err := h.repos.DeliveryZones.Delete(ctx, objectID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete delivery zone"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Delivery zone deleted successfully"})
+}
+
+// bindDeliveryZone reads and validates a delivery zone from the request body.
+// It responds with the error and returns false when the zone is invalid.
+func bindDeliveryZone(c *gin.Context) (*models.DeliveryZone, bool) {
+	var req models.DeliveryZoneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return nil, false
+	}
+
+	zone := &models.DeliveryZone{
+		Name:             strings.TrimSpace(req.Name),
+		Postcodes:        req.Postcodes,
+		Area:             req.Area,
+		FeeUSD:           pricing.Round(req.FeeUSD),
+		MinOrderUSD:      pricing.Round(req.MinOrderUSD),
+		ETAOffsetMinutes: req.ETAOffsetMinutes,
+		IsActive:         true,
+	}
+	if req.IsActive != nil {
+		zone.IsActive = *req.IsActive
+	}
+
+	if err := zones.Validate(zone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery zone", "details": err.Error()})
+		return nil, false
+	}
+	return zone, true
+}
+
+// Cart Handler
+type CartHandler struct {
+	repos *repository.Repositories
+}
+
+func NewCartHandler(repos *repository.Repositories) *CartHandler {
+	return &CartHandler{repos: repos}
+}
+
+// findCart returns the cart of the request identity
+func findCart(ctx context.Context, repos *repository.Repositories, identity middleware.Identity) (*models.Cart, error) {
+	var cart *models.Cart
+	var err error
+
+	switch {
+	case identity.IsUser():
+		cart, err = repos.Carts.FindByUserID(ctx, identity.UserID)
+	case identity.IsGuest():
+		cart, err = repos.Carts.FindBySessionID(ctx, identity.SessionID)
+	default:
+		return nil, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pricing.EnsureLineIDs(cart)
+	if err := ensureSlugs(ctx, repos, cart); err != nil {
+		return nil, err
+	}
+	return cart, nil
+}
+
+// ensureSlugs fills in the product slugs of cart lines stored before lines had
+// them, as promotions match products by slug
+func ensureSlugs(ctx context.Context, repos *repository.Repositories, cart *models.Cart) error {
+	var ids []primitive.ObjectID
+	for _, item := range cart.Items {
+		if item.Slug == "" {
+			ids = append(ids, item.ProductID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	products, err := repos.Products.FindAll(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	slugs := make(map[primitive.ObjectID]string, len(products))
+	for _, product := range products {
+		slugs[product.ID] = product.Slug
+	}
+	for i := range cart.Items {
+		if item := &cart.Items[i]; item.Slug == "" {
+			item.Slug = slugs[item.ProductID]
+		}
+	}
+	return nil
+}
+
+// priceCart prices the cart with the best of the active automatic promotions
+// and the promotion unlocked by the cart's promo code
+func priceCart(ctx context.Context, repos *repository.Repositories, cart *models.Cart) error {
+	promotions, err := repos.Promotions.FindAll(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	candidates := make([]*models.Promotion, 0, len(promotions)+1)
+	for _, promotion := range promotions {
+		if !promotion.RequiresCode {
+			candidates = append(candidates, promotion)
+		}
+	}
+
+	var codePromotion *models.Promotion
+	if cart.PromoCode != "" {
+		codePromotion, err = promoCodePromotion(ctx, repos, cart.PromoCode, promotions, now)
+		if err != nil {
+			return err
+		}
+		if codePromotion != nil {
+			candidates = append(candidates, codePromotion)
+		}
+	}
+
+	pricing.PriceCart(cart, candidates, now)
+	if codePromotion != nil && cart.AppliedPromotion != nil && cart.AppliedPromotion.PromotionID == codePromotion.ID {
+		cart.AppliedPromotion.Code = cart.PromoCode
+	}
+	return nil
+}
+
+// reconcileCart reloads the products of the cart lines and reconciles the
+// lines with them, see pricing.Reconcile
+func reconcileCart(ctx context.Context, repos *repository.Repositories, cart *models.Cart) ([]pricing.CartChange, map[primitive.ObjectID]*models.Product, error) {
+	ids := make([]primitive.ObjectID, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		ids = append(ids, item.ProductID)
+	}
+
+	products, err := repos.Products.FindAll(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]*models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+	return pricing.Reconcile(cart, byID), byID, nil
+}
+
+// promoCodePromotion returns the active promotion a promo code unlocks, or nil
+// when the code no longer exists, is switched off or expired. Redemption
+// limits are enforced when the code is applied and redeemed.
+func promoCodePromotion(ctx context.Context, repos *repository.Repositories, code string, promotions []*models.Promotion, now time.Time) (*models.Promotion, error) {
+	promoCode, err := repos.PromoCodes.FindByCode(ctx, code)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !promoCode.IsActive || (promoCode.ExpiresAt != nil && !now.Before(*promoCode.ExpiresAt)) {
+		return nil, nil
+	}
+
+	for _, promotion := range promotions {
+		if promotion.ID == promoCode.PromotionID {
+			return promotion, nil
+		}
+	}
+	return nil, nil
+}
+
+// normalizePromoCode makes promo codes case-insensitive
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// redeemerKey identifies whom promo code redemptions are counted for. Only
+// accounts are counted, guests get "" as a new session would reset their count.
+func redeemerKey(identity middleware.Identity) string {
+	if identity.IsUser() {
+		return identity.UserID
+	}
+	return ""
+}
+
+// promoCodeUnavailable returns why a redeemer who already redeemed the code
+// the given number of times cannot use it, or "" if they can
+func promoCodeUnavailable(promoCode *models.PromoCode, redeemed int, now time.Time) string {
+	switch {
+	case !promoCode.IsActive:
+		return "Promo code is not active"
+	case promoCode.ExpiresAt != nil && !now.Before(*promoCode.ExpiresAt):
+		return "Promo code has expired"
+	case promoCode.MaxRedemptions > 0 && promoCode.Redemptions >= promoCode.MaxRedemptions:
+		return "Promo code has been fully redeemed"
+	case promoCode.MaxPerUser > 0 && redeemed >= promoCode.MaxPerUser:
+		return "Promo code has already been used"
+	}
+	return ""
+}
+
+var (
+	errLineNotFound  = errors.New("item not found in cart")
+	errAmbiguousLine = errors.New("product has several lines in the cart, use the line ID")
+)
+
+// findCartLine returns the index of the cart line with the given line ID.
+// For backwards compatibility a product ID is accepted too, as long as the
+// product has a single line in the cart.
+func findCartLine(cart *models.Cart, id string) (int, error) {
+	for i, item := range cart.Items {
+		if item.LineID == id {
+			return i, nil
+		}
+	}
+
+	productOID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return -1, errLineNotFound
+	}
+
+	index := -1
+	for i, item := range cart.Items {
+		if item.ProductID == productOID {
+			if index >= 0 {
+				return -1, errAmbiguousLine
+			}
+			index = i
+		}
+	}
+	if index < 0 {
+		return -1, errLineNotFound
+	}
+	return index, nil
+}
+
+// respondLineError reports a failed cart line lookup
+func respondLineError(c *gin.Context, err error) {
+	if err == errAmbiguousLine {
+		utils.RespondError(c, 409, "AMBIGUOUS_CART_LINE", err.Error())
+		return
+	}
+	c.JSON(404, gin.H{"success": false, "error": "Item not found in cart"})
+}
+
+// Get returns the current user's cart
+func (h *CartHandler) Get(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	identity := middleware.GetIdentity(c)
+	if identity.IsAnonymous() {
+		// No user or session, return empty cart
+		c.JSON(200, gin.H{
+			"success": true,
+			"data": gin.H{
+				"items":    []models.CartItem{},
+				"totalUSD": 0,
+				"currency": "USD",
+			},
+		})
+		return
+	}
+
+	// If cart not found, return empty cart
+	cart, err := findCart(ctx, h.repos, identity)
+	if err != nil {
+		c.JSON(200, gin.H{
+			"success": true,
+			"data": gin.H{
+				"items":    []models.CartItem{},
+				"totalUSD": 0,
+				"currency": "USD",
+			},
+		})
+		return
+	}
+
+	// Promotions start and end independently of cart changes
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// AddItem adds an item to the cart
+func (h *CartHandler) AddItem(c *gin.Context) {
+	var req struct {
+		ProductID         string            `json:"productId" binding:"required"`
+		Qty               int               `json:"qty"`
+		ChosenIngredients []string          `json:"chosenIngredients"`
+		ChosenOptions     map[string]string `json:"chosenOptions"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"success": false, "error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	if req.Qty <= 0 {
+		req.Qty = 1
+	}
+
+	ctx := c.Request.Context()
+
+	// Get product details
+	productOID, err := primitive.ObjectIDFromHex(req.ProductID)
+	if err != nil {
+		c.JSON(400, gin.H{"success": false, "error": "Invalid product ID"})
+		return
+	}
+
+	product, err := h.repos.Products.FindByID(ctx, productOID)
+	if err != nil || !product.IsActive {
+		c.JSON(404, gin.H{"success": false, "error": "Product not found"})
+		return
+	}
+
+	// Validate the chosen options and ingredients and price them
+	config, err := pricing.Configure(product, req.ChosenOptions, req.ChosenIngredients)
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_CONFIGURATION", err.Error())
+		return
+	}
+
+	// Get or create cart
+	identity := middleware.GetIdentity(c)
+	if identity.IsAnonymous() {
+		c.JSON(401, gin.H{"success": false, "error": "Guest session required"})
+		return
+	}
+
+	cart, err := findCart(ctx, h.repos, identity)
+
+	// Create new cart if not found
+	if err != nil || cart == nil {
+		cart = &models.Cart{
+			Items:     []models.CartItem{},
+			TotalUSD:  0,
+			Currency:  "USD",
+			UserID:    identity.UserID,
+			SessionID: identity.SessionID,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+
+		if err := h.repos.Carts.Create(ctx, cart); err != nil {
+			c.JSON(500, gin.H{"success": false, "error": "Failed to create cart"})
+			return
+		}
+	}
+
+	// The same configuration of a product shares one line
+	lineID := pricing.LineID(productOID, config.ChosenOptions, config.ChosenIngredients)
+	itemIndex := -1
+	for i, item := range cart.Items {
+		if item.LineID == lineID {
+			itemIndex = i
+			break
+		}
+	}
+
+	if itemIndex >= 0 {
+		// Update existing item
+		cart.Items[itemIndex].Qty += req.Qty
+		cart.Items[itemIndex].UnitPriceUSD = config.UnitPriceUSD
+		cart.Items[itemIndex].Slug = product.Slug
+	} else {
+		// Add new item
+		newItem := models.CartItem{
+			LineID:            lineID,
+			ProductID:         productOID,
+			Slug:              product.Slug,
+			Name:              product.Name,
+			Image:             product.Image,
+			Qty:               req.Qty,
+			UnitPriceUSD:      config.UnitPriceUSD,
+			ChosenIngredients: config.ChosenIngredients,
+			ChosenOptions:     config.ChosenOptions,
+		}
+		cart.Items = append(cart.Items, newItem)
+	}
+
+	// Recalculate totals
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+
+	cart.UpdatedAt = time.Now()
+
+	// Save cart
+	if err := h.repos.Carts.Update(ctx, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// UpdateItem updates the quantity or configuration of a cart line.
+// The :lineId may also be a product ID when that product has a single line.
+func (h *CartHandler) UpdateItem(c *gin.Context) {
+	var req struct {
+		Qty               int               `json:"qty" binding:"required"`
+		ChosenIngredients []string          `json:"chosenIngredients"`
+		ChosenOptions     map[string]string `json:"chosenOptions"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil || req.Qty <= 0 {
+		c.JSON(400, gin.H{"success": false, "error": "Invalid request"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Get cart
+	cart, err := findCart(ctx, h.repos, middleware.GetIdentity(c))
+
+	if err != nil || cart == nil {
+		c.JSON(404, gin.H{"success": false, "error": "Cart not found"})
+		return
+	}
+
+	// Find and update item
+	itemIndex, err := findCartLine(cart, c.Param("lineId"))
+	if err != nil {
+		respondLineError(c, err)
+		return
+	}
+
+	item := &cart.Items[itemIndex]
+	item.Qty = req.Qty
+
+	// A changed configuration is validated and re-priced against the product
+	if req.ChosenOptions != nil || req.ChosenIngredients != nil {
+		product, err := h.repos.Products.FindByID(ctx, item.ProductID)
+		if err != nil {
+			c.JSON(404, gin.H{"success": false, "error": "Product not found"})
+			return
+		}
+
+		chosenOptions, chosenIngredients := item.ChosenOptions, item.ChosenIngredients
+		if req.ChosenOptions != nil {
+			chosenOptions = req.ChosenOptions
+		}
+		if req.ChosenIngredients != nil {
+			chosenIngredients = req.ChosenIngredients
+		}
+
+		config, err := pricing.Configure(product, chosenOptions, chosenIngredients)
+		if err != nil {
+			utils.RespondError(c, 400, "INVALID_CONFIGURATION", err.Error())
+			return
+		}
+		item.UnitPriceUSD = config.UnitPriceUSD
+		item.ChosenOptions = config.ChosenOptions
+		item.ChosenIngredients = config.ChosenIngredients
+		item.LineID = pricing.LineID(item.ProductID, config.ChosenOptions, config.ChosenIngredients)
+
+		// The new configuration may equal another line, fold them together
+		for i := range cart.Items {
+			if i != itemIndex && cart.Items[i].LineID == item.LineID {
+				cart.Items[i].Qty += item.Qty
+				cart.Items = append(cart.Items[:itemIndex], cart.Items[itemIndex+1:]...)
+				break
+			}
+		}
+	}
+
+	// Recalculate totals
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+
+	cart.UpdatedAt = time.Now()
+
+	if err := h.repos.Carts.Update(ctx, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// RemoveItem removes a line from the cart.
+// The :lineId may also be a product ID when that product has a single line.
+func (h *CartHandler) RemoveItem(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get cart
+	cart, err := findCart(ctx, h.repos, middleware.GetIdentity(c))
+
+	if err != nil || cart == nil {
+		c.JSON(404, gin.H{"success": false, "error": "Cart not found"})
+		return
+	}
+
+	// Remove item
+	itemIndex, err := findCartLine(cart, c.Param("lineId"))
+	if err != nil {
+		respondLineError(c, err)
+		return
+	}
+
+	cart.Items = append(cart.Items[:itemIndex], cart.Items[itemIndex+1:]...)
+
+	// Recalculate totals
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+
+	cart.UpdatedAt = time.Now()
+
+	if err := h.repos.Carts.Update(ctx, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// Clear removes all items from the cart
+func (h *CartHandler) Clear(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Get cart
+	cart, err := findCart(ctx, h.repos, middleware.GetIdentity(c))
+
+	if err != nil || cart == nil {
+		c.JSON(200, gin.H{"success": true, "message": "Cart already empty"})
+		return
+	}
+
+	cart.Items = []models.CartItem{}
+	pricing.PriceCart(cart, nil, time.Now())
+	cart.UpdatedAt = time.Now()
+
+	if err := h.repos.Carts.Update(ctx, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to clear cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// ApplyPromo attaches a promo code to the cart. The code is redeemed at checkout.
+func (h *CartHandler) ApplyPromo(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"success": false, "error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	identity := middleware.GetIdentity(c)
+	cart, err := findCart(ctx, h.repos, identity)
+	if err != nil || cart == nil {
+		c.JSON(404, gin.H{"success": false, "error": "Cart not found"})
+		return
+	}
+
+	promoCode, err := h.repos.PromoCodes.FindByCode(ctx, normalizePromoCode(req.Code))
+	if err == mongo.ErrNoDocuments {
+		utils.RespondError(c, 404, "PROMO_CODE_NOT_FOUND", "Promo code not found")
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to look up promo code"})
+		return
+	}
+
+	// Codes limited per customer are counted per account, guests have to log in
+	redeemer := redeemerKey(identity)
+	if promoCode.MaxPerUser > 0 && redeemer == "" {
+		utils.RespondError(c, 401, "LOGIN_REQUIRED", "Log in to use this promo code")
+		return
+	}
+	redeemed := 0
+	if redeemer != "" {
+		redeemed, err = h.repos.PromoCodes.RedemptionsBy(ctx, promoCode.Code, redeemer)
+		if err != nil {
+			c.JSON(500, gin.H{"success": false, "error": "Failed to look up promo code"})
+			return
+		}
+	}
+
+	if reason := promoCodeUnavailable(promoCode, redeemed, time.Now()); reason != "" {
+		utils.RespondError(c, 409, "PROMO_CODE_UNAVAILABLE", reason)
+		return
+	}
+
+	cart.PromoCode = promoCode.Code
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+
+	// The code's promotion has to apply and beat the automatic ones
+	if cart.AppliedPromotion == nil || cart.AppliedPromotion.Code != promoCode.Code {
+		utils.RespondError(c, 422, "PROMO_CODE_NOT_APPLICABLE", "Promo code does not improve the price of this cart")
+		return
+	}
+
+	cart.UpdatedAt = time.Now()
+
+	if err := h.repos.Carts.Update(ctx, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// RemovePromo detaches the promo code from the cart
+func (h *CartHandler) RemovePromo(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	cart, err := findCart(ctx, h.repos, middleware.GetIdentity(c))
+	if err != nil || cart == nil {
+		c.JSON(404, gin.H{"success": false, "error": "Cart not found"})
+		return
+	}
+
+	cart.PromoCode = ""
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+
+	cart.UpdatedAt = time.Now()
+
+	if err := h.repos.Carts.Update(ctx, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    cart,
+	})
+}
+
+// Payment Handler
+type PaymentHandler struct {
+	repos         *repository.Repositories
+	orders        *OrderHandler
+	webhookSecret string
+}
+
+// NewPaymentHandler creates the payment handler. orders cancels the orders
+// whose payment fails after they were placed.
+func NewPaymentHandler(repos *repository.Repositories, orders *OrderHandler, webhookSecret string) *PaymentHandler {
+	return &PaymentHandler{repos: repos, orders: orders, webhookSecret: webhookSecret}
+}
+
+// webhookTolerance is how old a webhook signature may be
+const webhookTolerance = 5 * time.Minute
+
+// Webhook receives signed payment intent events from the provider and updates
+// the payment of the order the intent belongs to
+func (h *PaymentHandler) Webhook(c *gin.Context) {
+	if h.webhookSecret == "" {
+		utils.RespondError(c, 503, "WEBHOOKS_DISABLED", "Payment webhooks are not configured")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_REQUEST", "Failed to read request body")
+		return
+	}
+	if err := payments.VerifySignature(h.webhookSecret, c.GetHeader(payments.SignatureHeader), body, time.Now(), webhookTolerance); err != nil {
+		utils.RespondError(c, 400, "INVALID_SIGNATURE", "Invalid webhook signature")
+		return
+	}
+
+	var event payments.WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.Intent.ID == "" {
+		utils.RespondError(c, 400, "INVALID_REQUEST", "Invalid webhook event")
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	order, err := h.repos.Orders.FindByPaymentTxnID(ctx, event.Intent.ID)
+	if err == mongo.ErrNoDocuments {
+		// Intents of checkouts that never produced an order, nothing to update
+		c.JSON(200, gin.H{"success": true, "message": "Ignored, no order for this payment"})
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to load order")
+		return
+	}
+
+	payment := withTenders(order.Payment, order.TotalUSD)
+	tender := findTender(&payment, event.Intent.ID)
+	if tender == nil {
+		c.JSON(200, gin.H{"success": true, "message": "Ignored, no tender for this payment"})
+		return
+	}
+
+	status := paymentStatusFromIntent(event.Intent.Status)
+	if !paymentUpdateAllowed(tender.Status, status) {
+		// A redelivered decline still cancels the order if that failed before
+		if tender.Status == models.PaymentStatusFailed {
+			if err := h.orders.cancelUnpaidOrder(ctx, order.ID); err != nil {
+				utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to cancel order")
+				return
+			}
+		}
+		c.JSON(200, gin.H{"success": true, "message": "Ignored, payment is already " + tender.Status})
+		return
+	}
+
+	tender.Status = status
+	if event.Intent.RefundID != "" {
+		tender.RefundTxnID = event.Intent.RefundID
+	}
+	summarizePayment(&payment)
+
+	// Only applied if no other update changed the payment in the meantime;
+	// the provider redelivers the event when we answer with an error
+	updated, err := h.repos.Orders.UpdatePaymentIfRevision(ctx, order.ID, order.Payment.Revision, payment)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to update payment")
+		return
+	}
+	if !updated {
+		utils.RespondError(c, 409, "CONCURRENT_UPDATE", "Payment changed concurrently, retry")
+		return
+	}
+
+	// No order stays live with a failed payment
+	if status == models.PaymentStatusFailed {
+		if err := h.orders.cancelUnpaidOrder(ctx, order.ID); err != nil {
+			utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to cancel order")
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{"success": true})
+}
+
+// Order Handler
+type OrderHandler struct {
+	repos          *repository.Repositories
+	paymentService payments.PaymentProvider
+	calendar       *scheduling.Calendar
+	estimator      *eta.Estimator
+}
+
+func NewOrderHandler(repos *repository.Repositories, paymentService payments.PaymentProvider, calendar *scheduling.Calendar, estimator *eta.Estimator) *OrderHandler {
+	return &OrderHandler{repos: repos, paymentService: paymentService, calendar: calendar, estimator: estimator}
+}
+
+// tenderRequest is one part of a split payment
+type tenderRequest struct {
+	Method    string  `json:"method" binding:"required"`
+	AmountUSD float64 `json:"amountUSD"`
+	Token     string  `json:"token"`
+}
+
+// Create creates a new order from cart
+func (h *OrderHandler) Create(c *gin.Context) {
+	var req struct {
+		PaymentMethod string          `json:"paymentMethod"` // card, applepay, googlepay, giftcard, cash; or tenders
+		PaymentToken  string          `json:"paymentToken"`  // card, wallet or gift card token for the provider
+		Tenders       []tenderRequest `json:"tenders"`       // split tender, adding up to the total
+		TipUSD        float64         `json:"tipUSD"`
+		DeliveryType  string          `json:"deliveryType" binding:"required"` // pickup, delivery
+		ScheduledFor  *time.Time      `json:"scheduledFor"`                    // requested pickup or delivery time, empty for as soon as possible
+		CustomerInfo  struct {
+			Name  string `json:"name" binding:"required"`
+			Email string `json:"email"`
+			Phone string `json:"phone" binding:"required"`
+		} `json:"customerInfo" binding:"required"`
+		DeliveryAddress *struct {
+			Street   string           `json:"street"`
+			City     string           `json:"city"`
+			ZipCode  string           `json:"zipCode"`
+			Notes    string           `json:"notes"`
+			Location *models.GeoPoint `json:"location"` // optional, for zones drawn as areas
+		} `json:"deliveryAddress"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"success": false, "error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// A single payment method pays the whole total, tenders state their amounts
+	paysTotal := len(req.Tenders) == 0
+	if paysTotal {
+		req.Tenders = append(req.Tenders, tenderRequest{Method: req.PaymentMethod, Token: req.PaymentToken})
+	}
+	cashTenders := 0
+	for _, tender := range req.Tenders {
+		if !models.IsValidPaymentMethod(tender.Method) {
+			utils.RespondError(c, 400, "INVALID_PAYMENT_METHOD", "Payment method must be card, applepay, googlepay, giftcard or cash")
+			return
+		}
+		if !paysTotal && tender.AmountUSD <= 0 {
+			utils.RespondError(c, 400, "INVALID_TENDER", "Every tender needs a positive amount")
+			return
+		}
+		if tender.Method == models.PaymentMethodCash {
+			cashTenders++
+		}
+	}
+	if cashTenders > 1 {
+		utils.RespondError(c, 400, "INVALID_TENDER", "Only one cash tender is allowed")
+		return
+	}
+	if req.TipUSD < 0 {
+		utils.RespondError(c, 400, "INVALID_TIP", "Tip must not be negative")
+		return
+	}
+
+	// Validate delivery type and address
+	if req.DeliveryType == "delivery" && req.DeliveryAddress == nil {
+		c.JSON(400, gin.H{"success": false, "error": "Delivery address is required for delivery orders"})
+		return
+	}
+
+	// Outside opening hours only scheduled orders are taken, while ordering
+	// is paused none are
+	now := time.Now()
+	hours, hoursErr := h.calendar.Hours(ctx)
+	storeStatus, statusErr := h.calendar.Status(ctx, now)
+	if hoursErr != nil || statusErr != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to check store hours")
+		return
+	}
+	if !storeStatus.AcceptingOrders && (storeStatus.Reason == scheduling.ReasonPaused || req.ScheduledFor == nil) {
+		respondStoreClosed(c, storeStatus)
+		return
+	}
+
+	// A scheduled order must be ready within opening hours and goes to the
+	// kitchen one lead time before
+	var releaseAt time.Time
+	if req.ScheduledFor != nil {
+		var scheduleErr error
+		releaseAt, scheduleErr = scheduling.Schedule(hours, req.DeliveryType, *req.ScheduledFor, now)
+		switch {
+		case errors.Is(scheduleErr, scheduling.ErrTooSoon):
+			utils.RespondError(c, 422, "SCHEDULE_TOO_SOON", fmt.Sprintf("Orders need at least %d minutes, order without a time for as soon as possible", int(scheduling.LeadTime(req.DeliveryType).Minutes())))
+			return
+		case errors.Is(scheduleErr, scheduling.ErrTooFarAhead):
+			utils.RespondError(c, 422, "SCHEDULE_TOO_FAR_AHEAD", "Orders can be scheduled at most 7 days ahead")
+			return
+		case scheduleErr != nil:
+			utils.RespondError(c, 422, "SCHEDULE_OUTSIDE_HOURS", "The store is closed at the requested time")
+			return
+		}
+	}
+
+	// Get cart
+	identity := middleware.GetIdentity(c)
+	if identity.IsAnonymous() {
+		c.JSON(400, gin.H{"success": false, "error": "No cart found"})
+		return
+	}
+
+	cart, err := findCart(ctx, h.repos, identity)
+
+	if err != nil || cart == nil || len(cart.Items) == 0 {
+		c.JSON(400, gin.H{"success": false, "error": "Cart is empty"})
+		return
+	}
+
+	// Re-validate the cart against the live catalog. Any difference is saved
+	// to the cart and reported so the customer can confirm before ordering.
+	changes, products, err := reconcileCart(ctx, h.repos, cart)
+	if err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to load products"})
+		return
+	}
+	if len(changes) > 0 {
+		if err := priceCart(ctx, h.repos, cart); err != nil {
+			c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+			return
+		}
+		cart.UpdatedAt = time.Now()
+		if err := h.repos.Carts.Update(ctx, cart); err != nil {
+			c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+			return
+		}
+
+		body := utils.ErrorBody("CART_CHANGED", "Some items in your cart changed, please review them before ordering", gin.H{"changes": changes})
+		body["data"] = gin.H{"cart": cart}
+		c.JSON(409, body)
+		return
+	}
+
+	// Generate order number
+	orderNumber := fmt.Sprintf("ORD-%d-%s", time.Now().Unix(), primitive.NewObjectID().Hex()[:6])
+
+	// Convert cart items to order items
+	if err := priceCart(ctx, h.repos, cart); err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to price cart"})
+		return
+	}
+	orderItems := pricing.OrderItems(cart)
+
+	// Delivery orders are charged the fee of the zone the address is in
+	var deliveryAddress models.DeliveryAddress
+	var zone *models.DeliveryZone
+	var zoneMatch string
+	var fees []models.FeeLine
+	if req.DeliveryType == "delivery" {
+		deliveryAddress = models.DeliveryAddress{
+			Street:   req.DeliveryAddress.Street,
+			City:     req.DeliveryAddress.City,
+			ZipCode:  req.DeliveryAddress.ZipCode,
+			Notes:    req.DeliveryAddress.Notes,
+			Location: req.DeliveryAddress.Location,
+		}
+
+		activeZones, err := h.repos.DeliveryZones.FindAll(ctx, bson.M{"isActive": true})
+		if err != nil {
+			c.JSON(500, gin.H{"success": false, "error": "Failed to load delivery zones"})
+			return
+		}
+		zone, zoneMatch = zones.Resolve(activeZones, deliveryAddress)
+		if zone == nil {
+			utils.RespondError(c, 422, "OUTSIDE_DELIVERY_AREA", "We do not deliver to this address")
+			return
+		}
+		if cart.TotalUSD < zone.MinOrderUSD {
+			utils.RespondError(c, 422, "BELOW_MINIMUM_ORDER", fmt.Sprintf("Delivery to %s needs an order of at least %.2f", zone.Name, zone.MinOrderUSD))
+			return
+		}
+		if zone.FeeUSD > 0 {
+			fees = append(fees, models.FeeLine{
+				Type:      models.FeeTypeDelivery,
+				Label:     "Delivery (" + zone.Name + ")",
+				AmountUSD: pricing.Round(zone.FeeUSD),
+			})
+		}
+	}
+
+	// Create order
+	order := &models.Order{
+		OrderNumber: orderNumber,
+		Items:       orderItems,
+		SubtotalUSD: cart.SubtotalUSD,
+		DiscountUSD: cart.DiscountUSD,
+		Fees:        fees,
+		TipUSD:      pricing.Round(req.TipUSD),
+		TotalUSD:    pricing.OrderTotal(cart, fees, req.TipUSD),
+		Currency:    "USD",
+		Status:      models.OrderStatusNew,
+		// Recorded for reporting on promotion usage
+		AppliedPromotion: cart.AppliedPromotion,
+		Delivery: models.Delivery{
+			Type: req.DeliveryType,
+			Tracking: []models.TrackingEvent{
+				{
+					Timestamp: time.Now(),
+					Status:    "Order received",
+					Note:      "Your order has been received and is being processed",
+				},
+			},
+		},
+		CustomerInfo: models.CustomerInfo{
+			Name:  req.CustomerInfo.Name,
+			Email: req.CustomerInfo.Email,
+			Phone: req.CustomerInfo.Phone,
+		},
+		UserID:    identity.UserID,
+		SessionID: identity.SessionID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// The tenders have to add up to the total, tip included
+	tokens := make([]string, len(req.Tenders))
+	var tendered float64
+	for i, tender := range req.Tenders {
+		amount := pricing.Round(tender.AmountUSD)
+		if paysTotal {
+			amount = order.TotalUSD
+		}
+		order.Payment.Tenders = append(order.Payment.Tenders, models.Tender{
+			Method:    tender.Method,
+			AmountUSD: amount,
+			Status:    models.PaymentStatusPending,
+		})
+		tokens[i] = tender.Token
+		tendered += amount
+	}
+	if pricing.Round(tendered) != order.TotalUSD {
+		utils.RespondError(c, 422, "TENDER_MISMATCH", fmt.Sprintf("Tenders add up to %.2f but the order total is %.2f", pricing.Round(tendered), order.TotalUSD))
+		return
+	}
+	summarizePayment(&order.Payment)
+
+	// Add delivery address if needed
+	if zone != nil {
+		order.Delivery.Address = deliveryAddress
+		order.Delivery.Zone = &models.ZoneRef{
+			ID:               zone.ID,
+			Name:             zone.Name,
+			ETAOffsetMinutes: zone.ETAOffsetMinutes,
+			MatchedBy:        zoneMatch,
+		}
+	}
+
+	if req.ScheduledFor != nil {
+		scheduledFor := req.ScheduledFor.UTC()
+		order.Status = models.OrderStatusScheduled
+		order.Delivery.ScheduledFor = &scheduledFor
+		order.Delivery.ReleaseAt = &releaseAt
+		order.Delivery.Tracking = append(order.Delivery.Tracking, models.TrackingEvent{
+			Timestamp: now,
+			Status:    models.OrderStatusScheduled,
+			Note:      "Scheduled for " + scheduledFor.In(hours.Location).Format("Mon Jan 2 15:04"),
+		})
+	}
+
+	// The ETA depends on the kitchen queue, the slowest item and the delivery
+	// zone; a failed estimate falls back to the default timings
+	order.Delivery.ETABreakdown = &models.ETABreakdown{PrepMinutes: h.estimator.PrepMinutes(order.Items, products)}
+	if err := h.estimator.Estimate(ctx, order, now); err != nil {
+		log.Printf("Failed to estimate ETA of order %s: %v", order.OrderNumber, err)
+		order.Delivery.ETA = now.Add(scheduling.LeadTime(req.DeliveryType))
+	}
+
+	// Checkout runs as a saga rather than a Mongo transaction, which needs a
+	// replica set: promo code redemption, payment, order insert and cart
+	// clearing each compensate the steps before them when they fail. Once a
+	// step has written anything the saga must finish even if the client goes
+	// away, so all of them run on a detached context.
+	sagaCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// Redeem the promo code before charging; the redemption is atomic so two
+	// checkouts cannot both take the last one
+	redeemer := redeemerKey(identity)
+	promoCode := ""
+	if order.AppliedPromotion != nil {
+		promoCode = order.AppliedPromotion.Code
+	}
+	if promoCode != "" {
+		if err := h.repos.PromoCodes.Redeem(sagaCtx, promoCode, redeemer, time.Now()); err != nil {
+			if err == repository.ErrPromoCodeUnavailable {
+				utils.RespondError(c, 409, "PROMO_CODE_UNAVAILABLE", "Promo code is no longer available, remove it to continue")
+				return
+			}
+			if err == repository.ErrPromoCodeLoginRequired {
+				utils.RespondError(c, 401, "LOGIN_REQUIRED", "Log in to use this promo code")
+				return
+			}
+			c.JSON(500, gin.H{"success": false, "error": "Failed to redeem promo code"})
+			return
+		}
+
+		// Kept so cancelling the order gives the redemption back
+		applied := *order.AppliedPromotion
+		applied.Redeemer = redeemer
+		order.AppliedPromotion = &applied
+	}
+	releasePromoCode := func() {
+		if promoCode == "" {
+			return
+		}
+		if err := h.repos.PromoCodes.Release(sagaCtx, promoCode, redeemer); err != nil {
+			log.Printf("Failed to release promo code %s: %v", promoCode, err)
+		}
+	}
+
+	// Charge the tenders one by one. When one is declined the ones already
+	// charged are reversed, no order is created and the cart is kept. Cash is
+	// collected on delivery or at pickup, it stays pending until then.
+	for i := range order.Payment.Tenders {
+		tender := &order.Payment.Tenders[i]
+		if tender.Method == models.PaymentMethodCash {
+			continue
+		}
+
+		intent, err := h.charge(sagaCtx, order, *tender, tokens[i])
+		if err != nil {
+			log.Printf("Payment for order %s failed: %v", order.OrderNumber, err)
+			h.reverseTendersOfUnsavedOrder(sagaCtx, order)
+			releasePromoCode()
+			utils.RespondError(c, 502, "PAYMENT_ERROR", "Payment processing failed, you have not been charged")
+			return
+		}
+
+		tender.Status = paymentStatusFromIntent(intent.Status)
+		tender.TxnID = intent.ID
+
+		if tender.Status == models.PaymentStatusFailed {
+			h.reverseTendersOfUnsavedOrder(sagaCtx, order)
+			releasePromoCode()
+			utils.RespondError(c, 402, "PAYMENT_DECLINED", intent.Message)
+			return
+		}
+	}
+	summarizePayment(&order.Payment)
+
+	// Save order. Without an order the charges are refunded.
+	if err := h.repos.Orders.Create(sagaCtx, order); err != nil {
+		log.Printf("Failed to create order %s: %v", order.OrderNumber, err)
+		releasePromoCode()
+
+		if !h.reverseTendersOfUnsavedOrder(sagaCtx, order) {
+			utils.RespondError(c, 500, "ORDER_FAILED", "Failed to create order, your payment will be refunded")
+			return
+		}
+		utils.RespondError(c, 500, "ORDER_FAILED", "Failed to create order, any payment has been refunded")
+		return
+	}
+
+	// Clear cart after successful order. The order stands either way, a
+	// failure only leaves the items in the cart.
+	cart.Items = []models.CartItem{}
+	cart.PromoCode = ""
+	pricing.PriceCart(cart, nil, time.Now())
+	cart.UpdatedAt = time.Now()
+	if err := h.repos.Carts.Update(sagaCtx, cart); err != nil {
+		log.Printf("Failed to clear cart %s after order %s: %v", cart.ID.Hex(), order.OrderNumber, err)
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data": gin.H{
+			"order": order,
+		},
+	})
+}
+func (h *OrderHandler) GetAll(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	identity := middleware.GetIdentity(c)
+
+	var orders []models.Order
+	var err error
+
+	if identity.IsUser() {
+		orders, err = h.repos.Orders.FindByUserID(ctx, identity.UserID)
+	} else if identity.IsGuest() {
+		orders, err = h.repos.Orders.FindBySessionID(ctx, identity.SessionID)
+	} else {
+		c.JSON(200, gin.H{"success": true, "data": gin.H{"orders": []models.Order{}}})
+		return
+	}
+
+	if err != nil {
+		c.JSON(500, gin.H{"success": false, "error": "Failed to fetch orders"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data": gin.H{
+			"orders": orders,
+		},
+	})
+}
+
+func (h *OrderHandler) GetByID(c *gin.Context) {
+	orderID := c.Param("id")
+
+	ctx := c.Request.Context()
+	orderOID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(400, gin.H{"success": false, "error": "Invalid order ID"})
+		return
+	}
+
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	if err != nil {
+		c.JSON(404, gin.H{"success": false, "error": "Order not found"})
+		return
+	}
+
+	// Verify ownership
+	if !middleware.GetIdentity(c).Owns(order.UserID, order.SessionID) {
+		c.JSON(403, gin.H{"success": false, "error": "Access denied"})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    order,
+	})
+}
+
+// Cancel cancels the caller's own order while it is still new or confirmed,
+// voiding or refunding its payment and giving back its promo code
+func (h *OrderHandler) Cancel(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	// The body is optional
+	_ = c.ShouldBindJSON(&req)
+
+	ctx := c.Request.Context()
+	orderOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_ORDER_ID", "Invalid order ID")
+		return
+	}
+
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	if err != nil || !middleware.GetIdentity(c).Owns(order.UserID, order.SessionID) {
+		utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+		return
+	}
+
+	note := "Order cancelled by customer"
+	if req.Reason != "" {
+		note += ": " + req.Reason
+	}
+
+	// Cancel atomically so a concurrent kitchen update cannot slip in between
+	cancellable := []string{models.OrderStatusScheduled, models.OrderStatusNew, models.OrderStatusConfirmed}
+	order, err = h.repos.Orders.TransitionStatus(ctx, orderOID, cancellable, models.OrderStatusCancelled, models.TrackingEvent{
+		Timestamp: time.Now(),
+		Status:    models.OrderStatusCancelled,
+		Note:      note,
+	})
+	if err == mongo.ErrNoDocuments {
+		utils.RespondError(c, 409, "ORDER_NOT_CANCELLABLE", "Only scheduled, new or confirmed orders can be cancelled")
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to cancel order")
+		return
+	}
+
+	if err := h.settleCancellation(ctx, order); err != nil {
+		utils.RespondError(c, 502, "PAYMENT_REVERSAL_FAILED", "Order cancelled but the payment could not be reversed, our staff will follow up")
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success": true,
+		"data":    order,
+	})
+}
+
+// CollectCash records the cash staff collected for an order with a cash
+// tender (Admin, or the assigned courier). The tendered amount must cover the
+// cash part, the change is returned.
+func (h *OrderHandler) CollectCash(c *gin.Context) {
+	var req struct {
+		TenderedUSD float64 `json:"tenderedUSD" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	orderOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_ORDER_ID", "Invalid order ID")
+		return
+	}
+
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+			return
+		}
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch order")
+		return
+	}
+
+	// Couriers collect only for the deliveries assigned to them
+	if !canActAsCourier(middleware.GetIdentity(c), order) {
+		utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+		return
+	}
+
+	payment := withTenders(order.Payment, order.TotalUSD)
+	var cash *models.Tender
+	for i := range payment.Tenders {
+		if payment.Tenders[i].Method == models.PaymentMethodCash {
+			cash = &payment.Tenders[i]
+		}
+	}
+
+	switch {
+	case cash == nil:
+		utils.RespondError(c, 409, "NOT_A_CASH_ORDER", "Only cash orders are paid in person")
+		return
+	case order.Status == models.OrderStatusCancelled:
+		utils.RespondError(c, 409, "ORDER_CANCELLED", "The order was cancelled")
+		return
+	case cash.Status != models.PaymentStatusPending:
+		utils.RespondError(c, 409, "PAYMENT_NOT_PENDING", "The cash payment is already "+cash.Status)
+		return
+	}
+
+	tendered := pricing.Round(req.TenderedUSD)
+	if tendered < cash.AmountUSD {
+		utils.RespondError(c, 422, "INSUFFICIENT_TENDER", fmt.Sprintf("Tendered %.2f does not cover the %.2f due in cash", tendered, cash.AmountUSD))
+		return
+	}
+
+	now := time.Now()
+	cash.Status = models.PaymentStatusCompleted
+	cash.TenderedUSD = tendered
+	cash.ChangeUSD = pricing.Round(tendered - cash.AmountUSD)
+	cash.CollectedBy = middleware.GetIdentity(c).UserID
+	cash.CollectedAt = &now
+	summarizePayment(&payment)
+
+	// Guards against collecting twice
+	updated, err := h.repos.Orders.UpdatePaymentIfRevision(ctx, orderOID, order.Payment.Revision, payment)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to record payment")
+		return
+	}
+	if !updated {
+		utils.RespondError(c, 409, "CONCURRENT_UPDATE", "The payment changed concurrently, reload the order")
+		return
+	}
+
+	payment.Revision = order.Payment.Revision + 1
+	order.Payment = payment
+	c.JSON(200, gin.H{"success": true, "data": order})
+}
+
+// charge creates and confirms the payment intent of one tender of a new order.
+// An intent whose confirmation errors is voided, so the customer is never
+// charged for it.
+func (h *OrderHandler) charge(ctx context.Context, order *models.Order, tender models.Tender, token string) (*payments.Intent, error) {
+	intent, err := h.paymentService.CreateIntent(ctx, payments.CreateIntentParams{
+		Amount:    tender.AmountUSD,
+		Currency:  order.Currency,
+		Method:    tender.Method,
+		Token:     token,
+		Reference: order.OrderNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed, err := h.paymentService.ConfirmIntent(ctx, intent.ID)
+	if err != nil {
+		if _, voidErr := h.paymentService.VoidIntent(ctx, intent.ID); voidErr != nil {
+			log.Printf("Failed to void payment intent %s: %v", intent.ID, voidErr)
+		}
+		return nil, err
+	}
+	return confirmed, nil
+}
+
+// reverseIntent voids an uncaptured tender or refunds a captured one. A
+// pending tender may have been captured before its webhook arrived, then
+// the void is refused and the tender is refunded instead.
+func (h *OrderHandler) reverseIntent(ctx context.Context, tender models.Tender) (*payments.Intent, error) {
+	if tender.Status == models.PaymentStatusCompleted {
+		return h.paymentService.RefundIntent(ctx, tender.TxnID, tender.AmountUSD)
+	}
+
+	intent, err := h.paymentService.VoidIntent(ctx, tender.TxnID)
+	if errors.Is(err, payments.ErrInvalidIntentState) {
+		return h.paymentService.RefundIntent(ctx, tender.TxnID, tender.AmountUSD)
+	}
+	return intent, err
+}
+
+// reverseTenders voids or refunds every tender that took or may take money.
+// Uncollected cash is simply not owed anymore. Tenders that cannot be
+// reversed are marked refund_failed and the first error is returned.
+func (h *OrderHandler) reverseTenders(ctx context.Context, payment *models.Payment) error {
+	var firstErr error
+	for i := range payment.Tenders {
+		if err := h.reverseTender(ctx, &payment.Tenders[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	summarizePayment(payment)
+	return firstErr
+}
+
+// reverseTender voids or refunds one tender, see reverseTenders
+func (h *OrderHandler) reverseTender(ctx context.Context, tender *models.Tender) error {
+	if tender.Status != models.PaymentStatusPending && tender.Status != models.PaymentStatusCompleted {
+		return nil
+	}
+
+	if tender.Method == models.PaymentMethodCash {
+		if tender.Status == models.PaymentStatusPending {
+			tender.Status = models.PaymentStatusVoided
+		}
+		return nil
+	}
+	if tender.TxnID == "" {
+		return nil
+	}
+
+	intent, err := h.reverseIntent(ctx, *tender)
+	if err != nil {
+		tender.Status = models.PaymentStatusRefundFailed
+		return err
+	}
+	tender.Status = paymentStatusFromIntent(intent.Status)
+	tender.RefundTxnID = intent.RefundID
+	return nil
+}
+
+// reverseTendersOfUnsavedOrder reverses the charges of a checkout that did not
+// produce an order and reports whether all of them were reversed
+func (h *OrderHandler) reverseTendersOfUnsavedOrder(ctx context.Context, order *models.Order) bool {
+	if err := h.reverseTenders(ctx, &order.Payment); err != nil {
+		log.Printf("Failed to reverse payments of unsaved order %s: %v", order.OrderNumber, err)
+		return false
+	}
+	return true
+}
+
+// settleCancellation undoes the checkout of an order that was just
+// cancelled: its promo code redemption is given back and its payment reversed.
+// Only a failed payment reversal is returned, it needs staff to follow up.
+func (h *OrderHandler) settleCancellation(ctx context.Context, order *models.Order) error {
+	if promotion := order.AppliedPromotion; promotion != nil && promotion.Code != "" {
+		// Orders placed before the redeemer was recorded counted it for their owner
+		redeemer := promotion.Redeemer
+		if redeemer == "" {
+			redeemer = redeemerKey(middleware.Identity{UserID: order.UserID, SessionID: order.SessionID})
+		}
+		if err := h.repos.PromoCodes.Release(ctx, promotion.Code, redeemer); err != nil {
+			log.Printf("Failed to release promo code %s of order %s: %v", promotion.Code, order.OrderNumber, err)
+		}
+	}
+	return h.reversePayment(ctx, order)
+}
+
+// cancelUnpaidOrder cancels an order whose payment failed after it was
+// placed, so it leaves the kitchen, and reverses the tenders that went
+// through. Orders past cancelling are logged for staff to follow up.
+func (h *OrderHandler) cancelUnpaidOrder(ctx context.Context, orderID primitive.ObjectID) error {
+	// Once cancelled the payment is reversed even if the provider hangs up
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		order, err := h.repos.Orders.FindByID(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if order.Status == models.OrderStatusCancelled {
+			return nil
+		}
+		if err := orderflow.CanTransition(order, models.OrderStatusCancelled); err != nil {
+			log.Printf("Payment of order %s failed after it was %s, staff must follow up", order.OrderNumber, order.Status)
+			return nil
+		}
+
+		cancelled, err := h.repos.Orders.UpdateStatus(ctx, orderID, order.Version, models.OrderStatusCancelled, models.TrackingEvent{
+			Timestamp: time.Now(),
+			Status:    models.OrderStatusCancelled,
+			Note:      "Order cancelled because the payment failed",
+		})
+		if err == repository.ErrVersionConflict && attempt < paymentUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := h.settleCancellation(ctx, cancelled); err != nil {
+			log.Printf("Failed to reverse payment of order %s cancelled for a failed payment: %v", cancelled.OrderNumber, err)
+		}
+		return nil
+	}
+}
+
+// paymentUpdateAttempts bounds how often a payment update is retried when
+// the payment keeps changing concurrently
+const paymentUpdateAttempts = 3
+
+var errPaymentChanged = errors.New("payment changed concurrently")
+
+// providerReversal is the outcome of reversing a tender at the provider
+type providerReversal struct {
+	tender models.Tender
+	err    error
+}
+
+// reversePayment reverses the tenders of an order and stores the outcome.
+// Like webhooks it only stores the payment if no other update changed it
+// since it was read. Otherwise the payment is re-read and the reversals
+// already made at the provider are applied to it rather than repeated.
+func (h *OrderHandler) reversePayment(ctx context.Context, order *models.Order) error {
+	reversals := map[string]providerReversal{} // by intent ID
+	for attempt := 1; ; attempt++ {
+		payment := withTenders(order.Payment, order.TotalUSD)
+		var firstErr error
+		for i := range payment.Tenders {
+			tender := &payment.Tenders[i]
+			reversal, done := reversals[tender.TxnID]
+			if done {
+				// The reversal is the provider's latest word on the intent,
+				// unless the intent was reversed in the meantime
+				if tender.Status != models.PaymentStatusRefunded && tender.Status != models.PaymentStatusVoided {
+					*tender = reversal.tender
+				}
+			} else {
+				before := tender.Status
+				reversal.err = h.reverseTender(ctx, tender)
+				reversal.tender = *tender
+				if tender.Method != models.PaymentMethodCash && (reversal.err != nil || tender.Status != before) {
+					reversals[tender.TxnID] = reversal
+				}
+			}
+			if reversal.err != nil && firstErr == nil {
+				firstErr = reversal.err
+			}
+		}
+		summarizePayment(&payment)
+
+		updated, err := h.repos.Orders.UpdatePaymentIfRevision(ctx, order.ID, order.Payment.Revision, payment)
+		if err != nil {
+			if firstErr != nil {
+				return firstErr
+			}
+			return err
+		}
+		if updated {
+			payment.Revision = order.Payment.Revision + 1
+			order.Payment = payment
+			return firstErr
+		}
+		if attempt == paymentUpdateAttempts {
+			return errPaymentChanged
+		}
+
+		current, err := h.repos.Orders.FindByID(ctx, order.ID)
+		if err != nil {
+			return err
+		}
+		order.Payment = current.Payment
+	}
+}
+
+// withTenders returns the payment with its tenders. Orders placed before
+// split tender get their single payment as one tender of the whole total.
+func withTenders(payment models.Payment, total float64) models.Payment {
+	if len(payment.Tenders) > 0 {
+		payment.Tenders = append([]models.Tender(nil), payment.Tenders...)
+		return payment
+	}
+	payment.Tenders = []models.Tender{{
+		Method:      payment.Method,
+		AmountUSD:   total,
+		Status:      payment.Status,
+		TxnID:       payment.TxnID,
+		RefundTxnID: payment.RefundTxnID,
+	}}
+	return payment
+}
+
+// findTender returns the tender paid with the intent, or nil
+func findTender(payment *models.Payment, txnID string) *models.Tender {
+	for i := range payment.Tenders {
+		if payment.Tenders[i].TxnID == txnID {
+			return &payment.Tenders[i]
+		}
+	}
+	return nil
+}
+
+// summarizePayment derives the order payment status from its tenders. With a
+// single tender its method and transaction IDs are copied too.
+func summarizePayment(payment *models.Payment) {
+	if len(payment.Tenders) == 0 {
+		return
+	}
+
+	if len(payment.Tenders) == 1 {
+		tender := payment.Tenders[0]
+		payment.Method = tender.Method
+		payment.TxnID = tender.TxnID
+		payment.RefundTxnID = tender.RefundTxnID
+	} else {
+		payment.Method = models.PaymentMethodSplit
+	}
+
+	// The most pressing tender status wins
+	precedence := []string{
+		models.PaymentStatusFailed,
+		models.PaymentStatusRefundFailed,
+		models.PaymentStatusPending,
+		models.PaymentStatusCompleted,
+		models.PaymentStatusRefunded,
+		models.PaymentStatusVoided,
+	}
+	for _, status := range precedence {
+		for _, tender := range payment.Tenders {
+			if tender.Status == status {
+				payment.Status = status
+				return
+			}
+		}
+	}
+}
+
+// paymentStatusFromIntent maps a payment intent status to an order payment status
+func paymentStatusFromIntent(status string) string {
+	switch status {
+	case payments.IntentSucceeded:
+		return models.PaymentStatusCompleted
+	case payments.IntentFailed:
+		return models.PaymentStatusFailed
+	case payments.IntentCanceled:
+		return models.PaymentStatusVoided
+	case payments.IntentRefunded:
+		return models.PaymentStatusRefunded
+	}
+	return models.PaymentStatusPending
+}
+
+// paymentUpdateAllowed reports whether a provider notification may move an
+// order payment from one status to another. Notifications can arrive late or
+// out of order and must not undo a void or refund.
+func paymentUpdateAllowed(from, to string) bool {
+	switch from {
+	case models.PaymentStatusPending:
+		return to != models.PaymentStatusPending
+	case models.PaymentStatusCompleted:
+		return to == models.PaymentStatusRefunded
+	case models.PaymentStatusRefundFailed:
+		return to == models.PaymentStatusRefunded || to == models.PaymentStatusVoided
+	}
+	return false
+}
+
+// GetAllAdmin lists orders with filters, search and cursor pagination (Admin).
+//
+// Query parameters: status (comma separated), deliveryType, paymentStatus,
+// from / to (RFC3339 or YYYY-MM-DD), q (order number, phone or email),
+// sort (desc | asc by creation time), limit (1-100) and cursor.
+func (h *OrderHandler) GetAllAdmin(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter := bson.M{}
+
+	if deliveryType := c.Query("deliveryType"); deliveryType != "" {
+		filter["delivery.type"] = deliveryType
+	}
+	if paymentStatus := c.Query("paymentStatus"); paymentStatus != "" {
+		filter["payment.status"] = paymentStatus
+	}
+
+	createdAt := bson.M{}
+	if from := c.Query("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			utils.RespondError(c, 400, "INVALID_DATE", "Invalid from date")
+			return
+		}
+		createdAt["$gte"] = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			utils.RespondError(c, 400, "INVALID_DATE", "Invalid to date")
+			return
+		}
+		createdAt["$lt"] = t
+	}
+	if len(createdAt) > 0 {
+		filter["createdAt"] = createdAt
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"orderNumber": pattern},
+			bson.M{"customerInfo.phone": pattern},
+			bson.M{"customerInfo.email": pattern},
+		}
+	}
+
+	// Per-status counts ignore the status filter so the kitchen board sees every queue
+	statusCounts, err := h.repos.Orders.CountByStatus(ctx, filter)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to count orders")
+		return
+	}
+
+	if status := c.Query("status"); status != "" {
+		filter["status"] = bson.M{"$in": strings.Split(status, ",")}
+	}
+
+	total, err := h.repos.Orders.Count(ctx, filter)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to count orders")
+		return
+	}
+
+	ascending := c.Query("sort") == "asc"
+
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	if cursorParam := c.Query("cursor"); cursorParam != "" {
+		after, err := decodeOrderCursor(cursorParam, ascending)
+		if err != nil {
+			utils.RespondError(c, 400, "INVALID_CURSOR", "Invalid cursor")
+			return
+		}
+		filter = bson.M{"$and": bson.A{filter, after}}
+	}
+
+	direction := -1
+	if ascending {
+		direction = 1
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+
+	orders, err := h.repos.Orders.FindAll(ctx, filter, findOptions)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch orders")
+		return
+	}
+
+	// One extra document was fetched to know whether another page exists
+	hasMore := len(orders) > limit
+	if hasMore {
+		orders = orders[:limit]
+	}
+	nextCursor := ""
+	if hasMore {
+		last := orders[len(orders)-1]
+		nextCursor = encodeOrderCursor(last.CreatedAt, last.ID)
+	}
+	if orders == nil {
+		orders = []models.Order{}
+	}
+
 	c.JSON(200, gin.H{
 		"success": true,
-		"data":    cart,
+		"data": gin.H{
+			"orders":       orders,
+			"total":        total,
+			"statusCounts": statusCounts,
+			"nextCursor":   nextCursor,
+			"hasMore":      hasMore,
+		},
 	})
 }
 
-// AddItem adds an item to the cart
-func (h *CartHandler) AddItem(c *gin.Context) {
+// encodeOrderCursor builds an opaque cursor from the sort key of the last order on a page
+func encodeOrderCursor(createdAt time.Time, id primitive.ObjectID) string {
+	raw := fmt.Sprintf("%d_%s", createdAt.UnixMilli(), id.Hex())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeOrderCursor turns a cursor into a filter selecting the orders after it
+func decodeOrderCursor(cursor string, ascending bool) (bson.M, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(string(raw), "_", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	millis, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	createdAt := time.UnixMilli(millis)
+
+	op := "$lt"
+	if ascending {
+		op = "$gt"
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"createdAt": bson.M{op: createdAt}},
+		bson.M{"createdAt": createdAt, "_id": bson.M{op: id}},
+	}}, nil
+}
+
+// parseDateParam parses an RFC3339 timestamp or a YYYY-MM-DD date. A bare date
+// used as an upper bound covers the whole day.
+func parseDateParam(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// UpdateStatus moves an order through its lifecycle (Admin)
+func (h *OrderHandler) UpdateStatus(c *gin.Context) {
 	var req struct {
-		ProductID         string            `json:"productId" binding:"required"`
-		Qty               int               `json:"qty"`
-		ChosenIngredients []string          `json:"chosenIngredients"`
-		ChosenOptions     map[string]string `json:"chosenOptions"`
+		Status  string `json:"status" binding:"required"`
+		Note    string `json:"note"`
+		Version *int   `json:"version"` // the version the admin saw
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid request", "details": err.Error()})
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
 		return
 	}
-
-	if req.Qty <= 0 {
-		req.Qty = 1
+	// Without it a stale screen would overwrite a concurrent change
+	if req.Version == nil {
+		utils.RespondError(c, 428, "VERSION_REQUIRED", "Send the version of the order the change is based on")
+		return
 	}
 
 	ctx := c.Request.Context()
-
-	// Get product details
-	productOID, err := primitive.ObjectIDFromHex(req.ProductID)
+	orderOID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid product ID"})
+		utils.RespondError(c, 400, "INVALID_ORDER_ID", "Invalid order ID")
 		return
 	}
 
-	product, err := h.repos.Products.FindByID(ctx, productOID)
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
 	if err != nil {
-		c.JSON(404, gin.H{"success": false, "error": "Product not found"})
+		if err == mongo.ErrNoDocuments {
+			utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+			return
+		}
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch order")
 		return
 	}
 
-	// Get or create cart
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
-
-	var cart *models.Cart
+	if *req.Version != order.Version {
+		respondConflict(c, order)
+		return
+	}
+	if req.Status == models.OrderStatusCompleted && cashPending(order) {
+		utils.RespondError(c, 409, "CASH_NOT_COLLECTED", "Collect the cash payment before completing the order")
+		return
+	}
 
-	if hasUserID && userID != "" {
-		cart, err = h.repos.Carts.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		cart, err = h.repos.Carts.FindBySessionID(ctx, sessionID.(string))
+	if err := orderflow.CanTransition(order, req.Status); err != nil {
+		c.JSON(422, utils.ErrorBody("INVALID_STATUS_TRANSITION", err.Error(), gin.H{"allowed": orderflow.NextStatuses(order)}))
+		return
 	}
 
-	// Create new cart if not found
-	if err != nil || cart == nil {
-		cart = &models.Cart{
-			Items:     []models.CartItem{},
-			TotalUSD:  0,
-			Currency:  "USD",
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
+	note := req.Note
+	if note == "" {
+		note = "Status changed to " + req.Status
+	}
 
-		if hasUserID && userID != "" {
-			cart.UserID = userID.(string)
-		} else if hasSessionID && sessionID != "" {
-			cart.SessionID = sessionID.(string)
+	updated, err := h.repos.Orders.UpdateStatus(ctx, orderOID, order.Version, req.Status, models.TrackingEvent{
+		Timestamp: time.Now(),
+		Status:    req.Status,
+		Note:      note,
+		ActorID:   middleware.GetIdentity(c).UserID,
+	})
+	if err == repository.ErrVersionConflict {
+		if current, findErr := h.repos.Orders.FindByID(ctx, orderOID); findErr == nil {
+			order = current
 		}
+		respondConflict(c, order)
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to update order status")
+		return
+	}
 
-		if err := h.repos.Carts.Create(ctx, cart); err != nil {
-			c.JSON(500, gin.H{"success": false, "error": "Failed to create cart"})
+	// Staff cancelling an order owe the customer the same as a customer
+	// cancelling it themselves
+	if req.Status == models.OrderStatusCancelled {
+		if err := h.settleCancellation(ctx, updated); err != nil {
+			utils.RespondError(c, 502, "PAYMENT_REVERSAL_FAILED", "Order cancelled but the payment could not be reversed, follow up with the customer")
 			return
 		}
 	}
 
-	// Check if item already exists in cart
-	itemIndex := -1
-	for i, item := range cart.Items {
-		if item.ProductID == productOID {
-			itemIndex = i
-			break
-		}
+	h.refreshETA(ctx, updated)
+	c.JSON(200, gin.H{"success": true, "data": updated})
+}
+
+// refreshETA recomputes the ETA of an order whose status changed. The status
+// change stands even if this fails, the previous ETA is kept then.
+func (h *OrderHandler) refreshETA(ctx context.Context, order *models.Order) {
+	previous := order.Delivery
+	if err := h.estimator.Estimate(ctx, order, time.Now()); err != nil {
+		log.Printf("Failed to estimate ETA of order %s: %v", order.OrderNumber, err)
+		return
 	}
+	if err := h.repos.Orders.UpdateETA(ctx, order.ID, order.Delivery.ETA, order.Delivery.ETABreakdown); err != nil {
+		log.Printf("Failed to update ETA of order %s: %v", order.OrderNumber, err)
+		order.Delivery = previous
+	}
+}
 
-	// Calculate item price
-	itemTotal := product.PriceUSD * float64(req.Qty)
+// AssignCourier assigns a courier to a delivery order that has not been
+// picked up yet (Admin). Assigning another courier replaces the previous one.
+func (h *OrderHandler) AssignCourier(c *gin.Context) {
+	var req struct {
+		CourierID string `json:"courierId" binding:"required"`
+		Version   *int   `json:"version"` // optional, the version the admin saw
+	}
 
-	if itemIndex >= 0 {
-		// Update existing item
-		cart.Items[itemIndex].Qty += req.Qty
-		cart.Items[itemIndex].TotalUSD = product.PriceUSD * float64(cart.Items[itemIndex].Qty)
-		if req.ChosenIngredients != nil {
-			cart.Items[itemIndex].ChosenIngredients = req.ChosenIngredients
-		}
-		if req.ChosenOptions != nil {
-			cart.Items[itemIndex].ChosenOptions = req.ChosenOptions
-		}
-	} else {
-		// Add new item
-		newItem := models.CartItem{
-			ProductID:         productOID,
-			Name:              product.Name,
-			Image:             product.Image,
-			Qty:               req.Qty,
-			UnitPriceUSD:      product.PriceUSD,
-			TotalUSD:          itemTotal,
-			ChosenIngredients: req.ChosenIngredients,
-			ChosenOptions:     req.ChosenOptions,
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	orderOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_ORDER_ID", "Invalid order ID")
+		return
+	}
+	courierOID, err := primitive.ObjectIDFromHex(req.CourierID)
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_COURIER_ID", "Invalid courier ID")
+		return
+	}
+
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+			return
 		}
-		cart.Items = append(cart.Items, newItem)
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch order")
+		return
 	}
 
-	// Recalculate total
-	cart.TotalUSD = 0
-	for _, item := range cart.Items {
-		cart.TotalUSD += item.TotalUSD
+	if req.Version != nil && *req.Version != order.Version {
+		respondConflict(c, order)
+		return
 	}
 
-	cart.UpdatedAt = time.Now()
+	switch {
+	case order.Delivery.Type != "delivery":
+		utils.RespondError(c, 409, "NOT_A_DELIVERY", "Only delivery orders get a courier")
+		return
+	case order.Status == models.OrderStatusDelivering:
+		utils.RespondError(c, 409, "ALREADY_PICKED_UP", "The order is already out for delivery")
+		return
+	case order.Status == models.OrderStatusCompleted || order.Status == models.OrderStatusCancelled:
+		utils.RespondError(c, 409, "ORDER_CLOSED", "The order is already "+order.Status)
+		return
+	}
 
-	// Save cart
-	if err := h.repos.Carts.Update(ctx, cart); err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+	courier, err := h.repos.Users.FindByID(ctx, courierOID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch courier")
+		return
+	}
+	if err == mongo.ErrNoDocuments || courier.Role != models.RoleCourier {
+		utils.RespondError(c, 404, "COURIER_NOT_FOUND", "Courier not found")
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data":    cart,
+	now := time.Now()
+	updated, err := h.repos.Orders.AssignCourier(ctx, orderOID, order.Version, models.CourierRef{
+		ID:         courier.ID.Hex(),
+		Name:       courier.Name,
+		Phone:      courier.Phone,
+		AssignedAt: now,
+	}, models.TrackingEvent{
+		Timestamp: now,
+		Status:    order.Status,
+		Event:     models.TrackingEventCourierAssigned,
+		Note:      courier.Name + " will deliver your order",
+		ActorID:   middleware.GetIdentity(c).UserID,
 	})
+	if err == repository.ErrVersionConflict {
+		if current, findErr := h.repos.Orders.FindByID(ctx, orderOID); findErr == nil {
+			order = current
+		}
+		respondConflict(c, order)
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to assign courier")
+		return
+	}
+
+	c.JSON(200, gin.H{"success": true, "data": updated})
 }
 
-// UpdateItem updates an item quantity in the cart
-func (h *CartHandler) UpdateItem(c *gin.Context) {
-	productID := c.Param("productId")
+// GetCourierDeliveries returns the open deliveries assigned to the calling
+// courier, the most urgent first (Courier). Admins get the open deliveries of
+// all couriers, or of the one given by the courierId query parameter.
+func (h *OrderHandler) GetCourierDeliveries(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	filter := bson.M{
+		"status": bson.M{"$nin": bson.A{models.OrderStatusCompleted, models.OrderStatusCancelled}},
+	}
+	identity := middleware.GetIdentity(c)
+	switch {
+	case !identity.IsAdmin():
+		filter["delivery.courier.id"] = identity.UserID
+	case c.Query("courierId") != "":
+		filter["delivery.courier.id"] = c.Query("courierId")
+	default:
+		filter["delivery.courier"] = bson.M{"$exists": true}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "delivery.eta", Value: 1}, {Key: "_id", Value: 1}})
+	orders, err := h.repos.Orders.FindAll(ctx, filter, opts)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch deliveries")
+		return
+	}
+	if orders == nil {
+		orders = []models.Order{}
+	}
 
+	c.JSON(200, gin.H{"success": true, "data": orders})
+}
+
+// PickUp records that the courier took the order from the kitchen, which
+// sends it out for delivery (Courier)
+func (h *OrderHandler) PickUp(c *gin.Context) {
 	var req struct {
-		Qty               int               `json:"qty" binding:"required"`
-		ChosenIngredients []string          `json:"chosenIngredients"`
-		ChosenOptions     map[string]string `json:"chosenOptions"`
+		Location *models.GeoPoint `json:"location"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid request"})
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
+		return
+	}
+	if req.Location != nil && !validLocation(*req.Location) {
+		utils.RespondError(c, 400, "INVALID_LOCATION", "Location needs a valid lat and lng")
 		return
 	}
 
-	ctx := c.Request.Context()
-	productOID, err := primitive.ObjectIDFromHex(productID)
-	if err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid product ID"})
+	h.courierTransition(c, models.OrderStatusDelivering, models.TrackingEvent{
+		Event:    models.TrackingEventPickedUp,
+		Note:     "Your order is on its way",
+		Location: req.Location,
+	}, nil)
+}
+
+// Deliver records the handover to the customer, with an optional photo as
+// proof, and completes the order (Courier). Cash due on delivery has to be
+// collected first.
+func (h *OrderHandler) Deliver(c *gin.Context) {
+	var req struct {
+		PhotoURL string           `json:"photoUrl"`
+		Location *models.GeoPoint `json:"location"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
+		return
+	}
+	if req.Location != nil && !validLocation(*req.Location) {
+		utils.RespondError(c, 400, "INVALID_LOCATION", "Location needs a valid lat and lng")
 		return
 	}
 
-	// Get cart
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
+	var fields bson.M
+	if req.PhotoURL != "" {
+		photoURL, err := url.Parse(req.PhotoURL)
+		if err != nil || (photoURL.Scheme != "http" && photoURL.Scheme != "https") || photoURL.Host == "" {
+			utils.RespondError(c, 400, "INVALID_PHOTO_URL", "Photo URL must be an http or https URL")
+			return
+		}
+		fields = bson.M{"delivery.proofPhotoUrl": req.PhotoURL}
+	}
 
-	var cart *models.Cart
+	h.courierTransition(c, models.OrderStatusCompleted, models.TrackingEvent{
+		Event:    models.TrackingEventDelivered,
+		Note:     "Your order has been delivered",
+		Location: req.Location,
+		PhotoURL: req.PhotoURL,
+	}, fields)
+}
 
-	if hasUserID && userID != "" {
-		cart, err = h.repos.Carts.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		cart, err = h.repos.Carts.FindBySessionID(ctx, sessionID.(string))
+// UpdateLocation records where the courier is while out for delivery (Courier)
+func (h *OrderHandler) UpdateLocation(c *gin.Context) {
+	var req struct {
+		Lat *float64 `json:"lat" binding:"required"`
+		Lng *float64 `json:"lng" binding:"required"`
 	}
 
-	if err != nil || cart == nil {
-		c.JSON(404, gin.H{"success": false, "error": "Cart not found"})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
+		return
+	}
+	location := models.GeoPoint{Lat: *req.Lat, Lng: *req.Lng}
+	if !validLocation(location) {
+		utils.RespondError(c, 400, "INVALID_LOCATION", "Location needs a valid lat and lng")
 		return
 	}
 
-	// Find and update item
-	found := false
-	for i, item := range cart.Items {
-		if item.ProductID == productOID {
-			cart.Items[i].Qty = req.Qty
-			cart.Items[i].TotalUSD = item.UnitPriceUSD * float64(req.Qty)
-			if req.ChosenIngredients != nil {
-				cart.Items[i].ChosenIngredients = req.ChosenIngredients
-			}
-			if req.ChosenOptions != nil {
-				cart.Items[i].ChosenOptions = req.ChosenOptions
-			}
-			found = true
-			break
-		}
+	ctx := c.Request.Context()
+	orderOID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utils.RespondError(c, 400, "INVALID_ORDER_ID", "Invalid order ID")
+		return
+	}
+
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	identity := middleware.GetIdentity(c)
+	if err == mongo.ErrNoDocuments || (err == nil && !canActAsCourier(identity, order)) {
+		utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+		return
 	}
-
-	if !found {
-		c.JSON(404, gin.H{"success": false, "error": "Item not found in cart"})
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch order")
 		return
 	}
-
-	// Recalculate total
-	cart.TotalUSD = 0
-	for _, item := range cart.Items {
-		cart.TotalUSD += item.TotalUSD
+	if order.Delivery.Courier == nil {
+		utils.RespondError(c, 409, "NOT_OUT_FOR_DELIVERY", "Only deliveries picked up by a courier can be tracked")
+		return
 	}
 
-	cart.UpdatedAt = time.Now()
-
-	if err := h.repos.Carts.Update(ctx, cart); err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
+	// Admins record the position for the assigned courier
+	now := time.Now()
+	updated, err := h.repos.Orders.RecordCourierLocation(ctx, orderOID, order.Delivery.Courier.ID, models.CourierLocation{
+		GeoPoint:  location,
+		UpdatedAt: now,
+	}, models.TrackingEvent{
+		Timestamp: now,
+		Status:    models.OrderStatusDelivering,
+		Event:     models.TrackingEventLocation,
+		Location:  &location,
+		ActorID:   identity.UserID,
+	})
+	if err == mongo.ErrNoDocuments {
+		utils.RespondError(c, 409, "NOT_OUT_FOR_DELIVERY", "Only deliveries picked up by a courier can be tracked")
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to record location")
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data":    cart,
-	})
+	c.JSON(200, gin.H{"success": true, "data": updated})
 }
 
-// RemoveItem removes an item from the cart
-func (h *CartHandler) RemoveItem(c *gin.Context) {
-	productID := c.Param("productId")
-
+// courierTransition moves a delivery of the calling courier to the given
+// status through the state machine, appending the tracking event and setting
+// the extra fields
+func (h *OrderHandler) courierTransition(c *gin.Context, to string, event models.TrackingEvent, fields bson.M) {
 	ctx := c.Request.Context()
-	productOID, err := primitive.ObjectIDFromHex(productID)
+	orderOID, err := primitive.ObjectIDFromHex(c.Param("id"))
 	if err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid product ID"})
+		utils.RespondError(c, 400, "INVALID_ORDER_ID", "Invalid order ID")
 		return
 	}
 
-	// Get cart
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
-
-	var cart *models.Cart
-
-	if hasUserID && userID != "" {
-		cart, err = h.repos.Carts.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		cart, err = h.repos.Carts.FindBySessionID(ctx, sessionID.(string))
+	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	if err == mongo.ErrNoDocuments || (err == nil && !canActAsCourier(middleware.GetIdentity(c), order)) {
+		utils.RespondError(c, 404, "ORDER_NOT_FOUND", "Order not found")
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch order")
+		return
+	}
+	if order.Delivery.Courier == nil {
+		utils.RespondError(c, 409, "NO_COURIER_ASSIGNED", "The order has no courier assigned")
+		return
+	}
+	if to == models.OrderStatusCompleted && cashPending(order) {
+		utils.RespondError(c, 409, "CASH_NOT_COLLECTED", "Collect the cash payment before completing the delivery")
+		return
 	}
 
-	if err != nil || cart == nil {
-		c.JSON(404, gin.H{"success": false, "error": "Cart not found"})
+	if err := orderflow.CanTransition(order, to); err != nil {
+		c.JSON(422, utils.ErrorBody("INVALID_STATUS_TRANSITION", err.Error(), gin.H{"allowed": orderflow.NextStatuses(order)}))
 		return
 	}
 
-	// Remove item
-	newItems := []models.CartItem{}
-	for _, item := range cart.Items {
-		if item.ProductID != productOID {
-			newItems = append(newItems, item)
+	now := time.Now()
+	event.Timestamp = now
+	event.Status = to
+	event.ActorID = middleware.GetIdentity(c).UserID
+	if event.Location != nil {
+		if fields == nil {
+			fields = bson.M{}
 		}
+		fields["delivery.courierLocation"] = models.CourierLocation{GeoPoint: *event.Location, UpdatedAt: now}
 	}
 
-	cart.Items = newItems
-
-	// Recalculate total
-	cart.TotalUSD = 0
-	for _, item := range cart.Items {
-		cart.TotalUSD += item.TotalUSD
+	updated, err := h.repos.Orders.UpdateStatusWith(ctx, orderOID, order.Version, to, event, fields)
+	if err == repository.ErrVersionConflict {
+		if current, findErr := h.repos.Orders.FindByID(ctx, orderOID); findErr == nil {
+			order = current
+		}
+		respondConflict(c, order)
+		return
+	}
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to update order status")
+		return
 	}
 
-	cart.UpdatedAt = time.Now()
+	h.refreshETA(ctx, updated)
+	c.JSON(200, gin.H{"success": true, "data": updated})
+}
 
-	if err := h.repos.Carts.Update(ctx, cart); err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Failed to update cart"})
-		return
+// canActAsCourier reports whether the caller may act for the courier of the
+// order: admins always, couriers for their own deliveries
+func canActAsCourier(identity middleware.Identity, order *models.Order) bool {
+	if identity.IsAdmin() {
+		return true
 	}
+	courier := order.Delivery.Courier
+	return identity.IsCourier() && courier != nil && courier.ID == identity.UserID
+}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data":    cart,
-	})
+// cashPending reports whether the order has a cash tender still to collect
+func cashPending(order *models.Order) bool {
+	for _, tender := range withTenders(order.Payment, order.TotalUSD).Tenders {
+		if tender.Method == models.PaymentMethodCash && tender.Status == models.PaymentStatusPending {
+			return true
+		}
+	}
+	return false
 }
 
-// Clear removes all items from the cart
-func (h *CartHandler) Clear(c *gin.Context) {
-	ctx := c.Request.Context()
+// validLocation reports whether a point has a valid latitude and longitude
+func validLocation(point models.GeoPoint) bool {
+	return point.Lat >= -90 && point.Lat <= 90 && point.Lng >= -180 && point.Lng <= 180
+}
 
-	// Get cart
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
+// respondConflict reports a concurrent modification together with the current order
+func respondConflict(c *gin.Context, current *models.Order) {
+	body := utils.ErrorBody("CONCURRENT_UPDATE", "The order was changed by someone else, reload and try again", nil)
+	body["data"] = current
+	c.JSON(409, body)
+}
 
-	var cart *models.Cart
-	var err error
+// Store Handler
+type StoreHandler struct {
+	calendar *scheduling.Calendar
+}
 
-	if hasUserID && userID != "" {
-		cart, err = h.repos.Carts.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		cart, err = h.repos.Carts.FindBySessionID(ctx, sessionID.(string))
-	}
+func NewStoreHandler(calendar *scheduling.Calendar) *StoreHandler {
+	return &StoreHandler{calendar: calendar}
+}
 
-	if err != nil || cart == nil {
-		c.JSON(200, gin.H{"success": true, "message": "Cart already empty"})
+// GetStatus returns whether the store is accepting orders right now
+func (h *StoreHandler) GetStatus(c *gin.Context) {
+	status, err := h.calendar.Status(c.Request.Context(), time.Now())
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to check store hours")
 		return
 	}
 
-	cart.Items = []models.CartItem{}
-	cart.TotalUSD = 0
-	cart.UpdatedAt = time.Now()
+	c.JSON(200, gin.H{"success": true, "data": status})
+}
 
-	if err := h.repos.Carts.Update(ctx, cart); err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Failed to clear cart"})
+// GetSettings returns the opening hours, holidays and any pause (Admin)
+func (h *StoreHandler) GetSettings(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	settings, _, err := h.calendar.Settings(ctx)
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to fetch store settings")
+		return
+	}
+	status, err := h.calendar.Status(ctx, time.Now())
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to check store hours")
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data":    cart,
-	})
+	c.JSON(200, gin.H{"success": true, "data": gin.H{"settings": settings, "status": status}})
 }
 
-// Order Handler
-type OrderHandler struct {
-	repos          *repository.Repositories
-	paymentService payments.PaymentProvider
-}
+// UpdateHours replaces the weekly opening hours and holiday exceptions (Admin)
+func (h *StoreHandler) UpdateHours(c *gin.Context) {
+	var req models.StoreSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
+		return
+	}
 
-func NewOrderHandler(repos *repository.Repositories, paymentService payments.PaymentProvider) *OrderHandler {
-	return &OrderHandler{repos: repos, paymentService: paymentService}
+	settings, err := h.calendar.UpdateHours(c.Request.Context(), req.Timezone, req.WeeklyHours, req.Holidays)
+	h.respondSettings(c, settings, err)
 }
 
-// Create creates a new order from cart
-func (h *OrderHandler) Create(c *gin.Context) {
+// Pause stops the store from taking orders, until the given time or until
+// resumed (Admin)
+func (h *StoreHandler) Pause(c *gin.Context) {
 	var req struct {
-		PaymentMethod string `json:"paymentMethod" binding:"required"` // card, applepay, googlepay, cash
-		DeliveryType  string `json:"deliveryType" binding:"required"`  // pickup, delivery
-		CustomerInfo  struct {
-			Name  string `json:"name" binding:"required"`
-			Email string `json:"email"`
-			Phone string `json:"phone" binding:"required"`
-		} `json:"customerInfo" binding:"required"`
-		DeliveryAddress *struct {
-			Street  string `json:"street"`
-			City    string `json:"city"`
-			ZipCode string `json:"zipCode"`
-			Notes   string `json:"notes"`
-		} `json:"deliveryAddress"`
+		Reason string     `json:"reason"`
+		Until  *time.Time `json:"until"` // empty to pause until resumed
 	}
-
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid request", "details": err.Error()})
+		utils.RespondError(c, 400, "INVALID_REQUEST", err.Error())
 		return
 	}
 
-	ctx := c.Request.Context()
-
-	// Validate delivery type and address
-	if req.DeliveryType == "delivery" && req.DeliveryAddress == nil {
-		c.JSON(400, gin.H{"success": false, "error": "Delivery address is required for delivery orders"})
+	now := time.Now()
+	if req.Until != nil && !req.Until.After(now) {
+		utils.RespondError(c, 400, "INVALID_PAUSE", "Pause end must be in the future")
 		return
 	}
 
-	// Get cart
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
+	settings, err := h.calendar.Pause(c.Request.Context(), models.OrderingPause{
+		Reason:   strings.TrimSpace(req.Reason),
+		Until:    req.Until,
+		PausedBy: middleware.GetIdentity(c).UserID,
+		PausedAt: now,
+	})
+	h.respondSettings(c, settings, err)
+}
 
-	var cart *models.Cart
-	var err error
+// Resume lifts an ordering pause (Admin)
+func (h *StoreHandler) Resume(c *gin.Context) {
+	settings, err := h.calendar.Resume(c.Request.Context())
+	h.respondSettings(c, settings, err)
+}
 
-	if hasUserID && userID != "" {
-		cart, err = h.repos.Carts.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		cart, err = h.repos.Carts.FindBySessionID(ctx, sessionID.(string))
-	} else {
-		c.JSON(400, gin.H{"success": false, "error": "No cart found"})
+// respondSettings answers a settings update with the new settings and status
+func (h *StoreHandler) respondSettings(c *gin.Context, settings *models.StoreSettings, err error) {
+	var invalid *scheduling.InvalidSettingsError
+	if errors.As(err, &invalid) {
+		utils.RespondError(c, 400, "INVALID_STORE_HOURS", invalid.Error())
 		return
 	}
-
-	if err != nil || cart == nil || len(cart.Items) == 0 {
-		c.JSON(400, gin.H{"success": false, "error": "Cart is empty"})
+	if err == scheduling.ErrSettingsChanged {
+		utils.RespondError(c, 409, "CONCURRENT_UPDATE", "Store settings changed concurrently, retry")
 		return
 	}
-
-	// Generate order number
-	orderNumber := fmt.Sprintf("ORD-%d-%s", time.Now().Unix(), primitive.NewObjectID().Hex()[:6])
-
-	// Convert cart items to order items
-	orderItems := make([]models.OrderItem, len(cart.Items))
-	for i, item := range cart.Items {
-		orderItems[i] = models.OrderItem(item)
-	}
-
-	// Create order
-	order := &models.Order{
-		OrderNumber: orderNumber,
-		Items:       orderItems,
-		TotalUSD:    cart.TotalUSD,
-		Currency:    "USD",
-		Status:      "new",
-		Payment: models.Payment{
-			Method: req.PaymentMethod,
-			Status: "pending",
-		},
-		Delivery: models.Delivery{
-			Type: req.DeliveryType,
-			Tracking: []models.TrackingEvent{
-				{
-					Timestamp: time.Now(),
-					Status:    "Order received",
-					Note:      "Your order has been received and is being processed",
-				},
-			},
-		},
-		CustomerInfo: models.CustomerInfo{
-			Name:  req.CustomerInfo.Name,
-			Email: req.CustomerInfo.Email,
-			Phone: req.CustomerInfo.Phone,
-		},
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	// Add user/session ID
-	if hasUserID && userID != "" {
-		order.UserID = userID.(string)
-	} else if hasSessionID && sessionID != "" {
-		order.SessionID = sessionID.(string)
-	}
-
-	// Add delivery address if needed
-	if req.DeliveryType == "delivery" && req.DeliveryAddress != nil {
-		order.Delivery.Address = models.DeliveryAddress{
-			Street:  req.DeliveryAddress.Street,
-			City:    req.DeliveryAddress.City,
-			ZipCode: req.DeliveryAddress.ZipCode,
-			Notes:   req.DeliveryAddress.Notes,
-		}
-		// Set ETA (example: 30-45 minutes)
-		order.Delivery.ETA = time.Now().Add(40 * time.Minute)
-	} else {
-		// Pickup ETA (example: 15-20 minutes)
-		order.Delivery.ETA = time.Now().Add(18 * time.Minute)
-	}
-
-	// Process payment (stub for now)
-	paymentResult, err := h.paymentService.ProcessPayment(order.TotalUSD, order.Currency)
 	if err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Payment processing failed"})
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to update store settings")
 		return
 	}
 
-	order.Payment.Status = paymentResult.Status
-	order.Payment.TxnID = paymentResult.TransactionID
-
-	// Save order
-	if err := h.repos.Orders.Create(ctx, order); err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Failed to create order"})
+	status, err := h.calendar.Status(c.Request.Context(), time.Now())
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to check store hours")
 		return
 	}
 
-	// Clear cart after successful order
-	cart.Items = []models.CartItem{}
-	cart.TotalUSD = 0
-	cart.UpdatedAt = time.Now()
-	_ = h.repos.Carts.Update(ctx, cart)
+	c.JSON(200, gin.H{"success": true, "data": gin.H{"settings": settings, "status": status}})
+}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data": gin.H{
-			"order": order,
-		},
-	})
+// respondStoreClosed refuses an order the store cannot take now, telling the
+// client why and when it opens again
+func respondStoreClosed(c *gin.Context, status scheduling.Status) {
+	c.JSON(409, utils.ErrorBody("STORE_CLOSED", status.Message, gin.H{
+		"reason":        status.Reason,
+		"nextOpeningAt": status.NextOpeningAt,
+	}))
 }
-func (h *OrderHandler) GetAll(c *gin.Context) {
-	ctx := c.Request.Context()
 
-	// Get user/session ID from context
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
+// Courier Handler
+type CourierHandler struct {
+	repos *repository.Repositories
+}
 
-	var orders []models.Order
-	var err error
+func NewCourierHandler(repos *repository.Repositories) *CourierHandler {
+	return &CourierHandler{repos: repos}
+}
 
-	if hasUserID && userID != "" {
-		orders, err = h.repos.Orders.FindByUserID(ctx, userID.(string))
-	} else if hasSessionID && sessionID != "" {
-		orders, err = h.repos.Orders.FindBySessionID(ctx, sessionID.(string))
-	} else {
-		c.JSON(200, gin.H{"success": true, "data": gin.H{"orders": []models.Order{}}})
-		return
-	}
+// GetAll returns the courier accounts (Admin)
+func (h *CourierHandler) GetAll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	users, err := h.repos.Users.FindByRole(ctx, models.RoleCourier)
 	if err != nil {
-		c.JSON(500, gin.H{"success": false, "error": "Failed to fetch orders"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch couriers"})
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data": gin.H{
-			"orders": orders,
-		},
-	})
+	couriers := make([]gin.H, 0, len(users))
+	for _, user := range users {
+		couriers = append(couriers, publicUser(user))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": couriers})
 }
 
-func (h *OrderHandler) GetByID(c *gin.Context) {
-	orderID := c.Param("id")
+// Create creates a courier account couriers log in with (Admin)
+func (h *CourierHandler) Create(c *gin.Context) {
+	var req models.RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
 
-	ctx := c.Request.Context()
-	orderOID, err := primitive.ObjectIDFromHex(orderID)
-	if err != nil {
-		c.JSON(400, gin.H{"success": false, "error": "Invalid order ID"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	email := utils.NormalizeEmail(req.Email)
+
+	if _, err := h.repos.Users.FindByEmail(ctx, email); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
 
-	order, err := h.repos.Orders.FindByID(ctx, orderOID)
+	passwordHash, err := utils.HashPassword(req.Password)
 	if err != nil {
-		c.JSON(404, gin.H{"success": false, "error": "Order not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
 		return
 	}
 
-	// Verify ownership
-	userID, hasUserID := c.Get("user_id")
-	sessionID, hasSessionID := c.Get("session_id")
-
-	isOwner := false
-	if hasUserID && userID != "" && order.UserID == userID.(string) {
-		isOwner = true
-	} else if hasSessionID && sessionID != "" && order.SessionID == sessionID.(string) {
-		isOwner = true
+	user := &models.User{
+		Role:         models.RoleCourier,
+		Name:         strings.TrimSpace(req.Name),
+		Email:        email,
+		Phone:        strings.TrimSpace(req.Phone),
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
 
-	if !isOwner {
-		c.JSON(403, gin.H{"success": false, "error": "Access denied"})
+	user, err = h.repos.Users.Create(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create courier"})
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"success": true,
-		"data":    order,
-	})
-}
-func (h *OrderHandler) Cancel(c *gin.Context) { c.JSON(200, gin.H{"success": true}) }
-func (h *OrderHandler) GetAllAdmin(c *gin.Context) {
-	c.JSON(200, gin.H{"success": true, "data": gin.H{"orders": []gin.H{}}})
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": publicUser(user)})
 }
-func (h *OrderHandler) UpdateStatus(c *gin.Context) { c.JSON(200, gin.H{"success": true}) }
 
 // Mood Handler
 type MoodHandler struct {
@@ -1286,12 +3864,9 @@ func (h *MoodHandler) GetRecommendations(c *gin.Context) {
 	}
 
 	// Add user/session ID if available
-	if userID, exists := c.Get("user_id"); exists {
-		session.UserID = userID.(string)
-	}
-	if sessionID, exists := c.Get("session_id"); exists {
-		session.SessionID = sessionID.(string)
-	}
+	identity := middleware.GetIdentity(c)
+	session.UserID = identity.UserID
+	session.SessionID = identity.SessionID
 
 	_ = h.repos.AISessions.Create(ctx, session)
 
@@ -1424,22 +3999,83 @@ func (h *MoodHandler) DeleteQuestion(c *gin.Context) {
 
 // Admin Handler
 type AdminHandler struct {
-	repos *repository.Repositories
+	repos     *repository.Repositories
+	analytics *analytics.Service
 }
 
-func NewAdminHandler(repos *repository.Repositories) *AdminHandler {
-	return &AdminHandler{repos: repos}
+func NewAdminHandler(repos *repository.Repositories, analyticsService *analytics.Service) *AdminHandler {
+	return &AdminHandler{repos: repos, analytics: analyticsService}
 }
 
+// GetAnalytics returns dashboard statistics for a period (Admin).
+//
+// Query parameters: from / to (RFC3339 or YYYY-MM-DD, default the last 30
+// days) and groupBy (day | week | month, default day).
 func (h *AdminHandler) GetAnalytics(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// Default to whole days so repeated dashboard loads hit the cache
+	to := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	if value := c.Query("to"); value != "" {
+		t, err := parseDateParam(value, true)
+		if err != nil {
+			utils.RespondError(c, 400, "INVALID_DATE", "Invalid to date")
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -30)
+	if value := c.Query("from"); value != "" {
+		t, err := parseDateParam(value, false)
+		if err != nil {
+			utils.RespondError(c, 400, "INVALID_DATE", "Invalid from date")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		utils.RespondError(c, 400, "INVALID_DATE", "from must be before to")
+		return
+	}
+
+	groupBy := c.DefaultQuery("groupBy", "day")
+	if !analytics.IsValidGroupBy(groupBy) {
+		utils.RespondError(c, 400, "INVALID_GROUP_BY", "groupBy must be day, week or month")
+		return
+	}
+
+	report, err := h.analytics.Report(ctx, analytics.Query{From: from, To: to, GroupBy: groupBy})
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to compute analytics")
+		return
+	}
+
+	productsCount, err := h.repos.Products.Count(ctx, bson.M{})
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to count products")
+		return
+	}
+	categoriesCount, err := h.repos.Categories.Count(ctx, bson.M{})
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to count categories")
+		return
+	}
+	promotionsCount, err := h.repos.Promotions.Count(ctx, bson.M{"isActive": true})
+	if err != nil {
+		utils.RespondError(c, 500, "INTERNAL_ERROR", "Failed to count promotions")
+		return
+	}
+
 	c.JSON(200, gin.H{
 		"success": true,
 		"data": gin.H{
-			"totalOrders":     0,
-			"totalRevenue":    0,
-			"productsCount":   11,
-			"categoriesCount": 4,
-			"promotionsCount": 3,
+			"totalOrders":     report.Summary.TotalOrders,
+			"ordersCount":     report.Summary.TotalOrders,
+			"totalRevenue":    report.Summary.RevenueUSD,
+			"productsCount":   productsCount,
+			"categoriesCount": categoriesCount,
+			"promotionsCount": promotionsCount,
+			"report":          report,
 		},
 	})
 }
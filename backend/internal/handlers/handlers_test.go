@@ -0,0 +1,947 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/configs"
+	"github.com/fastspot/backend/internal/models"
+	"github.com/fastspot/backend/internal/repository"
+	"github.com/fastspot/backend/internal/services/ai"
+	"github.com/fastspot/backend/internal/services/analytics"
+	"github.com/fastspot/backend/internal/services/eta"
+	"github.com/fastspot/backend/internal/services/payments"
+	"github.com/fastspot/backend/internal/services/payments/fakegateway"
+	"github.com/fastspot/backend/internal/services/scheduling"
+	"github.com/fastspot/backend/internal/testutil"
+	"github.com/fastspot/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testSecret = "test-secret"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testWebhookSecret signs the payment webhooks of the fake gateway
+const testWebhookSecret = "test-webhook-secret"
+
+// testEnv is the API wired as in cmd/api against a throwaway database and
+// the stub payment provider
+type testEnv struct {
+	t        *testing.T
+	repos    *repository.Repositories
+	payments *payments.StubProvider // nil with another provider
+	router   *gin.Engine
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	stub := payments.NewStubProvider(payments.WithIDGenerator(payments.SequentialIDs()))
+	env := newTestEnvWithProvider(t, stub)
+	env.payments = stub
+	return env
+}
+
+// newTestEnvWithProvider wires the API to the payment provider
+func newTestEnvWithProvider(t *testing.T, provider payments.PaymentProvider) *testEnv {
+	t.Helper()
+
+	db := testutil.Database(t)
+	repos := repository.NewRepositories(db)
+	ctx := context.Background()
+	if err := repos.Orders.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("order indexes: %v", err)
+	}
+	if err := repos.PromoCodes.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("promo code indexes: %v", err)
+	}
+
+	// Open around the clock, so orders are taken whenever the tests run
+	weeklyHours, err := scheduling.ParseWeeklyHours("mon-sun 00:00-24:00")
+	if err != nil {
+		t.Fatalf("store hours: %v", err)
+	}
+	calendar, err := scheduling.NewCalendar(repos.StoreSettings, models.StoreSettings{
+		ID:          models.StoreSettingsID,
+		Timezone:    "UTC",
+		WeeklyHours: weeklyHours,
+		Holidays:    []models.HolidayException{},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("calendar: %v", err)
+	}
+	estimator := eta.NewEstimator(repos.Orders, eta.Config{
+		DefaultPrepMinutes: 12,
+		KitchenCapacity:    3,
+		MinutesPerOrder:    10,
+		DeliveryMinutes:    25,
+	})
+
+	router := gin.New()
+	RegisterRoutes(router, Dependencies{
+		Config: &configs.Config{
+			JWTSecret:              testSecret,
+			JWTExpiration:          time.Hour,
+			RefreshTokenExpiration: 24 * time.Hour,
+			GuestSessionExpiration: 24 * time.Hour,
+			PaymentsWebhookSecret:  testWebhookSecret,
+		},
+		Repos:     repos,
+		Payments:  provider,
+		Gemini:    ai.NewGeminiService(""),
+		Analytics: analytics.NewService(repos.Orders, time.Minute),
+		Calendar:  calendar,
+		Estimator: estimator,
+	})
+
+	return &testEnv{t: t, repos: repos, router: router}
+}
+
+// userToken creates an account with the role and returns its access token
+func (e *testEnv) userToken(role string) (string, *models.User) {
+	e.t.Helper()
+
+	user, err := e.repos.Users.Create(context.Background(), &models.User{
+		Role:      role,
+		Name:      role,
+		Email:     primitive.NewObjectID().Hex() + "@local",
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		e.t.Fatalf("create %s: %v", role, err)
+	}
+	token, err := utils.GenerateJWT(user.ID.Hex(), user.Email, role, "family-"+user.ID.Hex(), testSecret, time.Hour)
+	if err != nil {
+		e.t.Fatalf("GenerateJWT: %v", err)
+	}
+	return token, user
+}
+
+// guestToken returns a guest token and its session ID
+func (e *testEnv) guestToken() (string, string) {
+	e.t.Helper()
+
+	sessionID, err := utils.NewSessionID()
+	if err != nil {
+		e.t.Fatalf("NewSessionID: %v", err)
+	}
+	token, err := utils.GenerateGuestJWT(sessionID, testSecret, time.Hour)
+	if err != nil {
+		e.t.Fatalf("GenerateGuestJWT: %v", err)
+	}
+	return token, sessionID
+}
+
+// product creates an active product
+func (e *testEnv) product(slug string, priceUSD float64) *models.Product {
+	e.t.Helper()
+
+	product, err := e.repos.Products.Create(context.Background(), &models.Product{
+		Name:     slug,
+		Slug:     slug,
+		PriceUSD: priceUSD,
+		IsActive: true,
+	})
+	if err != nil {
+		e.t.Fatalf("create product %s: %v", slug, err)
+	}
+	return product
+}
+
+// promoCode creates a code for a promotion taking percent off everything
+func (e *testEnv) promoCode(code string, percent float64, maxPerUser int) {
+	e.t.Helper()
+
+	ctx := context.Background()
+	promotion, err := e.repos.Promotions.Create(ctx, &models.Promotion{
+		Title:        code,
+		StartsAt:     time.Now().Add(-time.Hour),
+		EndsAt:       time.Now().Add(time.Hour),
+		IsActive:     true,
+		Discount:     &models.DiscountRule{Type: models.DiscountPercent, Percent: percent},
+		RequiresCode: true,
+	})
+	if err != nil {
+		e.t.Fatalf("create promotion: %v", err)
+	}
+	_, err = e.repos.PromoCodes.Create(ctx, &models.PromoCode{
+		Code:        code,
+		PromotionID: promotion.ID,
+		MaxPerUser:  maxPerUser,
+		IsActive:    true,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		e.t.Fatalf("create promo code: %v", err)
+	}
+}
+
+// redemptions returns how often the code was redeemed
+func (e *testEnv) redemptions(code string) int {
+	e.t.Helper()
+
+	promoCode, err := e.repos.PromoCodes.FindByCode(context.Background(), code)
+	if err != nil {
+		e.t.Fatalf("find promo code %s: %v", code, err)
+	}
+	return promoCode.Redemptions
+}
+
+// pickupOrder is a checkout request for pickup paid with the payment method
+func pickupOrder(paymentMethod string) gin.H {
+	return gin.H{
+		"paymentMethod": paymentMethod,
+		"paymentToken":  "tok_visa",
+		"deliveryType":  "pickup",
+		"customerInfo":  gin.H{"name": "Ada", "email": "ada@local", "phone": "+100"},
+	}
+}
+
+// placeOrder fills the cart of token with the product and checks out
+func (e *testEnv) placeOrder(token string, product *models.Product, qty int, promoCode string, checkout gin.H) *models.Order {
+	e.t.Helper()
+
+	expectStatus(e.t, e.do("POST", "/api/v1/cart/items", token, gin.H{"productId": product.ID.Hex(), "qty": qty}, nil), http.StatusOK)
+	if promoCode != "" {
+		expectStatus(e.t, e.do("POST", "/api/v1/cart/promo", token, gin.H{"code": promoCode}, nil), http.StatusOK)
+	}
+
+	var resp struct {
+		Data struct {
+			Order models.Order `json:"order"`
+		} `json:"data"`
+	}
+	expectStatus(e.t, e.do("POST", "/api/v1/orders", token, checkout, &resp), http.StatusOK)
+	return &resp.Data.Order
+}
+
+// statusChange is an admin status update based on the current version of the order
+func (e *testEnv) statusChange(id primitive.ObjectID, status string) gin.H {
+	return gin.H{"status": status, "version": e.order(id).Version}
+}
+
+// order reloads an order
+func (e *testEnv) order(id primitive.ObjectID) *models.Order {
+	e.t.Helper()
+
+	order, err := e.repos.Orders.FindByID(context.Background(), id)
+	if err != nil {
+		e.t.Fatalf("find order %s: %v", id.Hex(), err)
+	}
+	return order
+}
+
+// do sends a JSON request with the token and decodes the response into out
+func (e *testEnv) do(method, path, token string, body, out interface{}) *httptest.ResponseRecorder {
+	e.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			e.t.Fatalf("encode request: %v", err)
+		}
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, req)
+
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			e.t.Fatalf("%s %s: decode response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// expectStatus fails the test when the response has another status
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+}
+
+// errorResponse is the standard error envelope
+type errorResponse struct {
+	Success bool `json:"success"`
+	Error   struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func TestGuestCart(t *testing.T) {
+	env := newTestEnv(t)
+	burger := env.product("burger", 8.5)
+	guest, _ := env.guestToken()
+	otherGuest, _ := env.guestToken()
+
+	expectStatus(t, env.do("POST", "/api/v1/cart/items", guest, gin.H{"productId": burger.ID.Hex(), "qty": 2}, nil), http.StatusOK)
+
+	var resp struct {
+		Data models.Cart `json:"data"`
+	}
+	expectStatus(t, env.do("GET", "/api/v1/cart", guest, nil, &resp), http.StatusOK)
+	if len(resp.Data.Items) != 1 || resp.Data.Items[0].Qty != 2 || resp.Data.TotalUSD != 17 {
+		t.Fatalf("guest cart = %+v, want 2 burgers for 17.00", resp.Data)
+	}
+
+	// Carts are keyed by the session of the guest token
+	resp.Data = models.Cart{}
+	expectStatus(t, env.do("GET", "/api/v1/cart", otherGuest, nil, &resp), http.StatusOK)
+	if len(resp.Data.Items) != 0 {
+		t.Errorf("other guest sees %d cart items, want none", len(resp.Data.Items))
+	}
+
+	// Without a guest token there is no cart to add to
+	expectStatus(t, env.do("POST", "/api/v1/cart/items", "", gin.H{"productId": burger.ID.Hex()}, nil), http.StatusUnauthorized)
+}
+
+func TestCartBackfillsSlugs(t *testing.T) {
+	env := newTestEnv(t)
+	guest, sessionID := env.guestToken()
+	burger := env.product("burger", 10)
+
+	ctx := context.Background()
+	_, err := env.repos.Promotions.Create(ctx, &models.Promotion{
+		Title:     "Burger week",
+		StartsAt:  time.Now().Add(-time.Hour),
+		EndsAt:    time.Now().Add(time.Hour),
+		IsActive:  true,
+		Discount:  &models.DiscountRule{Type: models.DiscountPercent, Percent: 20},
+		AppliesTo: []string{"burger"},
+	})
+	if err != nil {
+		t.Fatalf("create promotion: %v", err)
+	}
+
+	// A line stored before cart lines had slugs
+	err = env.repos.Carts.Create(ctx, &models.Cart{
+		SessionID: sessionID,
+		Items:     []models.CartItem{{ProductID: burger.ID, Name: "burger", Qty: 1, UnitPriceUSD: 10}},
+		Currency:  "USD",
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("create cart: %v", err)
+	}
+
+	var resp struct {
+		Data models.Cart `json:"data"`
+	}
+	expectStatus(t, env.do("GET", "/api/v1/cart", guest, nil, &resp), http.StatusOK)
+	if len(resp.Data.Items) != 1 || resp.Data.Items[0].Slug != "burger" || resp.Data.TotalUSD != 8 {
+		t.Errorf("cart = %+v, want the burger line with its slug for 8.00", resp.Data)
+	}
+}
+
+func TestCustomerOrders(t *testing.T) {
+	env := newTestEnv(t)
+	customer, user := env.userToken(models.RoleCustomer)
+	_, other := env.userToken(models.RoleCustomer)
+
+	ctx := context.Background()
+	own := &models.Order{OrderNumber: "ORD-OWN", UserID: user.ID.Hex(), Status: models.OrderStatusNew, CreatedAt: time.Now()}
+	foreign := &models.Order{OrderNumber: "ORD-FOREIGN", UserID: other.ID.Hex(), Status: models.OrderStatusNew, CreatedAt: time.Now()}
+	for _, order := range []*models.Order{own, foreign} {
+		if err := env.repos.Orders.Create(ctx, order); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+	}
+
+	var list struct {
+		Data struct {
+			Orders []models.Order `json:"orders"`
+		} `json:"data"`
+	}
+	expectStatus(t, env.do("GET", "/api/v1/orders", customer, nil, &list), http.StatusOK)
+	if len(list.Data.Orders) != 1 || list.Data.Orders[0].OrderNumber != "ORD-OWN" {
+		t.Fatalf("customer orders = %+v, want only ORD-OWN", list.Data.Orders)
+	}
+
+	expectStatus(t, env.do("GET", "/api/v1/orders/"+own.ID.Hex(), customer, nil, nil), http.StatusOK)
+	expectStatus(t, env.do("GET", "/api/v1/orders/"+foreign.ID.Hex(), customer, nil, nil), http.StatusForbidden)
+
+	// The admin routes stay closed to customers
+	var failed errorResponse
+	expectStatus(t, env.do("GET", "/api/v1/admin/orders", customer, nil, &failed), http.StatusForbidden)
+	if failed.Error.Code != "FORBIDDEN" {
+		t.Errorf("error code = %q, want FORBIDDEN", failed.Error.Code)
+	}
+}
+
+func TestAdminOrders(t *testing.T) {
+	env := newTestEnv(t)
+	admin, _ := env.userToken(models.RoleAdmin)
+	guest, sessionID := env.guestToken()
+
+	ctx := context.Background()
+	for _, number := range []string{"ORD-1", "ORD-2"} {
+		order := &models.Order{OrderNumber: number, SessionID: sessionID, Status: models.OrderStatusNew, CreatedAt: time.Now()}
+		if err := env.repos.Orders.Create(ctx, order); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+	}
+
+	var list struct {
+		Data struct {
+			Orders []models.Order `json:"orders"`
+			Total  int64          `json:"total"`
+		} `json:"data"`
+	}
+	expectStatus(t, env.do("GET", "/api/v1/admin/orders", admin, nil, &list), http.StatusOK)
+	if list.Data.Total != 2 || len(list.Data.Orders) != 2 {
+		t.Fatalf("admin sees %d of %d orders, want 2", len(list.Data.Orders), list.Data.Total)
+	}
+
+	// Guest tokens never reach the admin routes
+	expectStatus(t, env.do("GET", "/api/v1/admin/orders", guest, nil, nil), http.StatusUnauthorized)
+}
+
+func TestPromoCodePerCustomer(t *testing.T) {
+	env := newTestEnv(t)
+	customer, user := env.userToken(models.RoleCustomer)
+	guest, _ := env.guestToken()
+	burger := env.product("burger", 10)
+	env.promoCode("ONCE", 10, 1)
+
+	// A new guest session would start a new count, so guests have to log in
+	expectStatus(t, env.do("POST", "/api/v1/cart/items", guest, gin.H{"productId": burger.ID.Hex()}, nil), http.StatusOK)
+	var failed errorResponse
+	expectStatus(t, env.do("POST", "/api/v1/cart/promo", guest, gin.H{"code": "once"}, &failed), http.StatusUnauthorized)
+	if failed.Error.Code != "LOGIN_REQUIRED" {
+		t.Errorf("error code = %q, want LOGIN_REQUIRED", failed.Error.Code)
+	}
+
+	env.placeOrder(customer, burger, 1, "ONCE", pickupOrder(models.PaymentMethodCard))
+	redeemed, err := env.repos.PromoCodes.RedemptionsBy(context.Background(), "ONCE", user.ID.Hex())
+	if err != nil || redeemed != 1 {
+		t.Fatalf("customer redeemed ONCE %d times (%v), want 1", redeemed, err)
+	}
+
+	expectStatus(t, env.do("POST", "/api/v1/cart/items", customer, gin.H{"productId": burger.ID.Hex()}, nil), http.StatusOK)
+	failed = errorResponse{}
+	expectStatus(t, env.do("POST", "/api/v1/cart/promo", customer, gin.H{"code": "ONCE"}, &failed), http.StatusConflict)
+	if failed.Error.Code != "PROMO_CODE_UNAVAILABLE" {
+		t.Errorf("error code = %q, want PROMO_CODE_UNAVAILABLE", failed.Error.Code)
+	}
+
+	// The limit also holds at checkout, e.g. for a code applied before the first order
+	if err := env.repos.PromoCodes.Redeem(context.Background(), "ONCE", user.ID.Hex(), time.Now()); err != repository.ErrPromoCodeUnavailable {
+		t.Errorf("second Redeem = %v, want ErrPromoCodeUnavailable", err)
+	}
+	if env.redemptions("ONCE") != 1 {
+		t.Errorf("ONCE redeemed %d times, want 1", env.redemptions("ONCE"))
+	}
+}
+
+func TestAdminCancelReversesCheckout(t *testing.T) {
+	env := newTestEnv(t)
+	admin, _ := env.userToken(models.RoleAdmin)
+	customer, _ := env.userToken(models.RoleCustomer)
+	burger := env.product("burger", 10)
+	env.promoCode("SAVE10", 10, 1)
+
+	order := env.placeOrder(customer, burger, 2, "SAVE10", pickupOrder(models.PaymentMethodCard))
+	if order.TotalUSD != 18 || order.Payment.Status != models.PaymentStatusCompleted {
+		t.Fatalf("order total %.2f paid %s, want 18.00 completed", order.TotalUSD, order.Payment.Status)
+	}
+	if env.redemptions("SAVE10") != 1 {
+		t.Fatalf("SAVE10 redeemed %d times, want 1", env.redemptions("SAVE10"))
+	}
+
+	// Staff changes are based on the version they saw
+	var failed errorResponse
+	expectStatus(t, env.do("PUT", "/api/v1/admin/orders/"+order.ID.Hex()+"/status", admin, gin.H{"status": models.OrderStatusConfirmed}, &failed), http.StatusPreconditionRequired)
+	if failed.Error.Code != "VERSION_REQUIRED" {
+		t.Errorf("error code = %q, want VERSION_REQUIRED", failed.Error.Code)
+	}
+
+	// The kitchen has started, so only staff can still cancel
+	for _, status := range []string{models.OrderStatusConfirmed, models.OrderStatusPreparing} {
+		expectStatus(t, env.do("PUT", "/api/v1/admin/orders/"+order.ID.Hex()+"/status", admin, env.statusChange(order.ID, status), nil), http.StatusOK)
+	}
+	expectStatus(t, env.do("PUT", "/api/v1/admin/orders/"+order.ID.Hex()+"/status", admin, env.statusChange(order.ID, models.OrderStatusCancelled), nil), http.StatusOK)
+
+	refunds := env.payments.CallsTo("RefundIntent")
+	if len(refunds) != 1 || refunds[0].IntentID != order.Payment.TxnID || refunds[0].Amount != 18 {
+		t.Fatalf("refunds = %+v, want 18.00 refunded on %s", refunds, order.Payment.TxnID)
+	}
+	if cancelled := env.order(order.ID); cancelled.Status != models.OrderStatusCancelled || cancelled.Payment.Status != models.PaymentStatusRefunded {
+		t.Errorf("order is %s with payment %s, want cancelled and refunded", cancelled.Status, cancelled.Payment.Status)
+	}
+	if env.redemptions("SAVE10") != 0 {
+		t.Errorf("SAVE10 redeemed %d times after cancelling, want 0", env.redemptions("SAVE10"))
+	}
+}
+
+func TestAdminCancelReportsFailedReversal(t *testing.T) {
+	env := newTestEnv(t)
+	admin, _ := env.userToken(models.RoleAdmin)
+	customer, _ := env.userToken(models.RoleCustomer)
+	burger := env.product("burger", 10)
+
+	order := env.placeOrder(customer, burger, 1, "", pickupOrder(models.PaymentMethodCard))
+
+	// The provider refunds the intent behind our back, our refund is refused
+	if _, err := env.payments.RefundIntent(context.Background(), order.Payment.TxnID, 10); err != nil {
+		t.Fatalf("refund behind the back: %v", err)
+	}
+
+	var failed errorResponse
+	expectStatus(t, env.do("PUT", "/api/v1/admin/orders/"+order.ID.Hex()+"/status", admin, env.statusChange(order.ID, models.OrderStatusCancelled), &failed), http.StatusBadGateway)
+	if failed.Error.Code != "PAYMENT_REVERSAL_FAILED" {
+		t.Errorf("error code = %q, want PAYMENT_REVERSAL_FAILED", failed.Error.Code)
+	}
+	if cancelled := env.order(order.ID); cancelled.Status != models.OrderStatusCancelled || cancelled.Payment.Status != models.PaymentStatusRefundFailed {
+		t.Errorf("order is %s with payment %s, want cancelled with refund_failed", cancelled.Status, cancelled.Payment.Status)
+	}
+}
+
+func TestReversePaymentRetriesConcurrentUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	customer, _ := env.userToken(models.RoleCustomer)
+	burger := env.product("burger", 10)
+
+	order := env.placeOrder(customer, burger, 1, "", pickupOrder(models.PaymentMethodCard))
+	stale := env.order(order.ID)
+	revision := stale.Payment.Revision
+
+	// Another update, e.g. a webhook, changes the payment after it was read
+	if err := env.repos.Orders.UpdatePayment(context.Background(), order.ID, stale.Payment); err != nil {
+		t.Fatalf("concurrent payment update: %v", err)
+	}
+
+	handler := NewOrderHandler(env.repos, env.payments, nil, nil)
+	if err := handler.reversePayment(context.Background(), stale); err != nil {
+		t.Fatalf("reversePayment: %v", err)
+	}
+
+	// The payment is reversed once and stored on top of the concurrent update
+	if refunds := env.payments.CallsTo("RefundIntent"); len(refunds) != 1 {
+		t.Errorf("refunds = %+v, want one", refunds)
+	}
+	reversed := env.order(order.ID)
+	if reversed.Payment.Status != models.PaymentStatusRefunded || reversed.Payment.Revision != revision+2 {
+		t.Errorf("payment %s at revision %d, want refunded at revision %d", reversed.Payment.Status, reversed.Payment.Revision, revision+2)
+	}
+}
+
+// cashDelivery creates a delivery out with the courier, 15.00 due in cash
+func (e *testEnv) cashDelivery(courier *models.User) *models.Order {
+	e.t.Helper()
+
+	order := &models.Order{
+		OrderNumber: "ORD-" + primitive.NewObjectID().Hex(),
+		Status:      models.OrderStatusDelivering,
+		TotalUSD:    15,
+		Payment: models.Payment{
+			Method: models.PaymentMethodCash,
+			Status: models.PaymentStatusPending,
+			Tenders: []models.Tender{
+				{Method: models.PaymentMethodCash, AmountUSD: 15, Status: models.PaymentStatusPending},
+			},
+		},
+		Delivery: models.Delivery{
+			Type:     "delivery",
+			Courier:  &models.CourierRef{ID: courier.ID.Hex(), Name: courier.Name, AssignedAt: time.Now()},
+			Tracking: []models.TrackingEvent{},
+		},
+		CreatedAt: time.Now(),
+	}
+	if err := e.repos.Orders.Create(context.Background(), order); err != nil {
+		e.t.Fatalf("create delivery: %v", err)
+	}
+	return order
+}
+
+func TestCourierDeliveries(t *testing.T) {
+	env := newTestEnv(t)
+	courier, courierUser := env.userToken(models.RoleCourier)
+	_, otherCourier := env.userToken(models.RoleCourier)
+	admin, _ := env.userToken(models.RoleAdmin)
+	order := env.cashDelivery(courierUser)
+	env.cashDelivery(otherCourier)
+
+	var list struct {
+		Data []models.Order `json:"data"`
+	}
+	expectStatus(t, env.do("GET", "/api/v1/courier/deliveries", courier, nil, &list), http.StatusOK)
+	if len(list.Data) != 1 || list.Data[0].ID != order.ID {
+		t.Errorf("courier sees %d deliveries, want only their own", len(list.Data))
+	}
+	expectStatus(t, env.do("GET", "/api/v1/courier/deliveries", admin, nil, &list), http.StatusOK)
+	if len(list.Data) != 2 {
+		t.Errorf("admin sees %d deliveries, want those of both couriers", len(list.Data))
+	}
+	expectStatus(t, env.do("GET", "/api/v1/courier/deliveries?courierId="+courierUser.ID.Hex(), admin, nil, &list), http.StatusOK)
+	if len(list.Data) != 1 || list.Data[0].ID != order.ID {
+		t.Errorf("admin sees %d deliveries of the courier, want 1", len(list.Data))
+	}
+
+	// Admins report the position for the assigned courier
+	var located struct {
+		Data models.Order `json:"data"`
+	}
+	expectStatus(t, env.do("POST", "/api/v1/courier/deliveries/"+order.ID.Hex()+"/location", admin, gin.H{"lat": 51.5, "lng": -0.12}, &located), http.StatusOK)
+	if location := located.Data.Delivery.CourierLocation; location == nil || location.Lat != 51.5 {
+		t.Errorf("courier location = %+v, want 51.5, -0.12", location)
+	}
+
+	// The cash is collected before the order can be completed
+	var failed errorResponse
+	expectStatus(t, env.do("POST", "/api/v1/courier/deliveries/"+order.ID.Hex()+"/delivered", courier, nil, &failed), http.StatusConflict)
+	if failed.Error.Code != "CASH_NOT_COLLECTED" {
+		t.Errorf("error code = %q, want CASH_NOT_COLLECTED", failed.Error.Code)
+	}
+	expectStatus(t, env.do("POST", "/api/v1/courier/deliveries/"+order.ID.Hex()+"/payment/collect", courier, gin.H{"tenderedUSD": 20}, nil), http.StatusOK)
+	expectStatus(t, env.do("POST", "/api/v1/courier/deliveries/"+order.ID.Hex()+"/delivered", courier, nil, nil), http.StatusOK)
+	if delivered := env.order(order.ID); delivered.Status != models.OrderStatusCompleted || delivered.Payment.Status != models.PaymentStatusCompleted {
+		t.Errorf("order %s with payment %s, want completed and paid", delivered.Status, delivered.Payment.Status)
+	}
+}
+
+func TestAdminCompleteRequiresCash(t *testing.T) {
+	env := newTestEnv(t)
+	_, courierUser := env.userToken(models.RoleCourier)
+	admin, _ := env.userToken(models.RoleAdmin)
+	order := env.cashDelivery(courierUser)
+
+	// Staff cannot complete the order around the courier either
+	var failed errorResponse
+	expectStatus(t, env.do("PUT", "/api/v1/admin/orders/"+order.ID.Hex()+"/status", admin, env.statusChange(order.ID, models.OrderStatusCompleted), &failed), http.StatusConflict)
+	if failed.Error.Code != "CASH_NOT_COLLECTED" {
+		t.Errorf("error code = %q, want CASH_NOT_COLLECTED", failed.Error.Code)
+	}
+
+	expectStatus(t, env.do("POST", "/api/v1/courier/deliveries/"+order.ID.Hex()+"/payment/collect", admin, gin.H{"tenderedUSD": 15}, nil), http.StatusOK)
+	expectStatus(t, env.do("PUT", "/api/v1/admin/orders/"+order.ID.Hex()+"/status", admin, env.statusChange(order.ID, models.OrderStatusCompleted), nil), http.StatusOK)
+	if completed := env.order(order.ID); completed.Status != models.OrderStatusCompleted || completed.Payment.Status != models.PaymentStatusCompleted {
+		t.Errorf("order %s with payment %s, want completed and paid", completed.Status, completed.Payment.Status)
+	}
+}
+
+// providerCalls lists the calls the stub recorded as "Method intent amount"
+func (e *testEnv) providerCalls() []string {
+	var calls []string
+	for _, call := range e.payments.Calls() {
+		calls = append(calls, fmt.Sprintf("%s %s %.2f", call.Method, call.IntentID, call.Amount))
+	}
+	return calls
+}
+
+// splitOrder is a pickup checkout request paying with the tenders
+func splitOrder(tenders ...gin.H) gin.H {
+	checkout := pickupOrder("")
+	delete(checkout, "paymentMethod")
+	delete(checkout, "paymentToken")
+	checkout["tenders"] = tenders
+	return checkout
+}
+
+func TestOrderCreatePayments(t *testing.T) {
+	declined := payments.Outcome{Status: payments.IntentFailed, Message: "Card declined"}
+	succeeded := payments.Outcome{Status: payments.IntentSucceeded}
+	processing := payments.Outcome{Status: payments.IntentProcessing}
+
+	tests := []struct {
+		name     string
+		script   []payments.Outcome
+		checkout gin.H
+		status   int
+		code     string
+		payment  string
+		calls    []string
+	}{
+		{
+			name:     "succeeded",
+			script:   []payments.Outcome{succeeded},
+			checkout: pickupOrder(models.PaymentMethodCard),
+			status:   http.StatusOK,
+			payment:  models.PaymentStatusCompleted,
+			calls: []string{
+				"CreateIntent pi_stub_000001 20.00",
+				"ConfirmIntent pi_stub_000001 0.00",
+			},
+		},
+		{
+			name:     "processing",
+			script:   []payments.Outcome{processing},
+			checkout: pickupOrder(models.PaymentMethodCard),
+			status:   http.StatusOK,
+			payment:  models.PaymentStatusPending,
+			calls: []string{
+				"CreateIntent pi_stub_000001 20.00",
+				"ConfirmIntent pi_stub_000001 0.00",
+			},
+		},
+		{
+			name:     "provider down",
+			script:   []payments.Outcome{{Err: errors.New("provider down")}},
+			checkout: pickupOrder(models.PaymentMethodCard),
+			status:   http.StatusBadGateway,
+			code:     "PAYMENT_ERROR",
+			// The intent is voided so the customer is never charged for it
+			calls: []string{
+				"CreateIntent pi_stub_000001 20.00",
+				"ConfirmIntent pi_stub_000001 0.00",
+				"VoidIntent pi_stub_000001 0.00",
+			},
+		},
+		{
+			name:   "second tender declined",
+			script: []payments.Outcome{succeeded, declined},
+			checkout: splitOrder(
+				gin.H{"method": models.PaymentMethodCard, "amountUSD": 12, "token": "tok_visa"},
+				gin.H{"method": models.PaymentMethodGiftCard, "amountUSD": 8, "token": "gc_1"},
+			),
+			status: http.StatusPaymentRequired,
+			code:   "PAYMENT_DECLINED",
+			// The tender charged before the decline is refunded
+			calls: []string{
+				"CreateIntent pi_stub_000001 12.00",
+				"ConfirmIntent pi_stub_000001 0.00",
+				"CreateIntent pi_stub_000002 8.00",
+				"ConfirmIntent pi_stub_000002 0.00",
+				"RefundIntent pi_stub_000001 12.00",
+			},
+		},
+		{
+			name:     "single tender short of the total",
+			checkout: splitOrder(gin.H{"method": models.PaymentMethodCard, "amountUSD": 5, "token": "tok_visa"}),
+			status:   http.StatusUnprocessableEntity,
+			code:     "TENDER_MISMATCH",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			customer, _ := env.userToken(models.RoleCustomer)
+			burger := env.product("burger", 10)
+			env.payments.Script(tt.script...)
+
+			expectStatus(t, env.do("POST", "/api/v1/cart/items", customer, gin.H{"productId": burger.ID.Hex(), "qty": 2}, nil), http.StatusOK)
+			var resp struct {
+				Data struct {
+					Order models.Order `json:"order"`
+				} `json:"data"`
+				errorResponse
+			}
+			expectStatus(t, env.do("POST", "/api/v1/orders", customer, tt.checkout, &resp), tt.status)
+
+			if tt.code != "" && resp.Error.Code != tt.code {
+				t.Errorf("error code = %q, want %q", resp.Error.Code, tt.code)
+			}
+			if tt.payment != "" && resp.Data.Order.Payment.Status != tt.payment {
+				t.Errorf("payment = %s, want %s", resp.Data.Order.Payment.Status, tt.payment)
+			}
+			if calls := env.providerCalls(); !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("provider calls = %q, want %q", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestOrderCancelPayments(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcome  payments.Outcome
+		checkout gin.H
+		payment  string
+		calls    []string
+	}{
+		{
+			name:     "captured card is refunded",
+			outcome:  payments.Outcome{Status: payments.IntentSucceeded},
+			checkout: pickupOrder(models.PaymentMethodCard),
+			payment:  models.PaymentStatusRefunded,
+			calls:    []string{"RefundIntent pi_stub_000001 20.00"},
+		},
+		{
+			name:     "processing card is voided",
+			outcome:  payments.Outcome{Status: payments.IntentProcessing},
+			checkout: pickupOrder(models.PaymentMethodCard),
+			payment:  models.PaymentStatusVoided,
+			calls:    []string{"VoidIntent pi_stub_000001 0.00"},
+		},
+		{
+			name:    "uncollected cash needs no provider call",
+			outcome: payments.Outcome{Status: payments.IntentSucceeded},
+			checkout: splitOrder(
+				gin.H{"method": models.PaymentMethodCard, "amountUSD": 15, "token": "tok_visa"},
+				gin.H{"method": models.PaymentMethodCash, "amountUSD": 5},
+			),
+			payment: models.PaymentStatusRefunded,
+			calls:   []string{"RefundIntent pi_stub_000001 15.00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			customer, _ := env.userToken(models.RoleCustomer)
+			env.payments.Script(tt.outcome)
+
+			order := env.placeOrder(customer, env.product("burger", 10), 2, "", tt.checkout)
+			checkoutCalls := len(env.payments.Calls())
+
+			var resp struct {
+				Data models.Order `json:"data"`
+			}
+			expectStatus(t, env.do("POST", "/api/v1/orders/"+order.ID.Hex()+"/cancel", customer, gin.H{"reason": "changed my mind"}, &resp), http.StatusOK)
+
+			if resp.Data.Status != models.OrderStatusCancelled || resp.Data.Payment.Status != tt.payment {
+				t.Errorf("order %s with payment %s, want cancelled with %s", resp.Data.Status, resp.Data.Payment.Status, tt.payment)
+			}
+			if calls := env.providerCalls()[checkoutCalls:]; !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("provider calls on cancel = %q, want %q", calls, tt.calls)
+			}
+		})
+	}
+}
+
+// newGatewayEnv wires the API to the fake gateway, which sends its webhooks
+// back to the API
+func newGatewayEnv(t *testing.T, settleDelay time.Duration) *testEnv {
+	t.Helper()
+
+	var env *testEnv
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		env.router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(api.Close)
+
+	gateway := httptest.NewServer(fakegateway.NewServer(fakegateway.Config{
+		APIKey:        "test-key",
+		WebhookURL:    api.URL + "/api/v1/payments/webhook",
+		WebhookSecret: testWebhookSecret,
+		SettleDelay:   settleDelay,
+	}).Handler())
+	t.Cleanup(gateway.Close)
+
+	env = newTestEnvWithProvider(t, payments.NewGatewayProvider(gateway.URL, "test-key"))
+	return env
+}
+
+// awaitPayment reloads the order until its payment has the status
+func (e *testEnv) awaitPayment(id primitive.ObjectID, status string) *models.Order {
+	e.t.Helper()
+	return e.awaitOrder(id, "payment "+status, func(order *models.Order) bool { return order.Payment.Status == status })
+}
+
+// awaitOrder reloads the order until done reports true for it
+func (e *testEnv) awaitOrder(id primitive.ObjectID, want string, done func(order *models.Order) bool) *models.Order {
+	e.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		order := e.order(id)
+		if done(order) {
+			return order
+		}
+		if time.Now().After(deadline) {
+			e.t.Fatalf("order %s is %s with payment %s, want %s", order.OrderNumber, order.Status, order.Payment.Status, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestGatewayCheckout(t *testing.T) {
+	env := newGatewayEnv(t, 200*time.Millisecond)
+	customer, _ := env.userToken(models.RoleCustomer)
+
+	// Confirmed right away, the webhooks that follow change nothing
+	order := env.placeOrder(customer, env.product("burger", 10), 1, "", pickupOrder(models.PaymentMethodCard))
+	if order.Payment.Status != models.PaymentStatusCompleted || order.Payment.TxnID == "" {
+		t.Fatalf("payment %s with intent %q, want completed", order.Payment.Status, order.Payment.TxnID)
+	}
+
+	// Amounts over 100 settle later, the signed webhook completes the payment
+	order = env.placeOrder(customer, env.product("platter", 120), 1, "", pickupOrder(models.PaymentMethodCard))
+	if order.Payment.Status != models.PaymentStatusPending {
+		t.Fatalf("payment %s, want pending until the gateway settles", order.Payment.Status)
+	}
+	settled := env.awaitPayment(order.ID, models.PaymentStatusCompleted)
+	if settled.Payment.TxnID != order.Payment.TxnID || settled.Payment.Revision <= order.Payment.Revision {
+		t.Errorf("settled payment %+v, want an update of intent %s", settled.Payment, order.Payment.TxnID)
+	}
+
+	// Amounts ending in .13 are declined: no order, the cart is kept
+	declined := env.product("declined", 10.13)
+	expectStatus(t, env.do("POST", "/api/v1/cart/items", customer, gin.H{"productId": declined.ID.Hex()}, nil), http.StatusOK)
+	var failed errorResponse
+	expectStatus(t, env.do("POST", "/api/v1/orders", customer, pickupOrder(models.PaymentMethodCard), &failed), http.StatusPaymentRequired)
+	if failed.Error.Code != "PAYMENT_DECLINED" {
+		t.Errorf("error code = %q, want PAYMENT_DECLINED", failed.Error.Code)
+	}
+	var cart struct {
+		Data models.Cart `json:"data"`
+	}
+	expectStatus(t, env.do("GET", "/api/v1/cart", customer, nil, &cart), http.StatusOK)
+	if len(cart.Data.Items) != 1 {
+		t.Errorf("cart has %d items after the decline, want it kept", len(cart.Data.Items))
+	}
+	if count, err := env.repos.Orders.Count(context.Background(), bson.M{}); err != nil || count != 2 {
+		t.Errorf("%d orders (%v), want only the 2 paid ones", count, err)
+	}
+}
+
+func TestGatewayAsyncDecline(t *testing.T) {
+	env := newGatewayEnv(t, 200*time.Millisecond)
+	customer, _ := env.userToken(models.RoleCustomer)
+
+	// The card is charged right away, the gift card settles later and is
+	// declined then
+	order := env.placeOrder(customer, env.product("feast", 140.14), 1, "", splitOrder(
+		gin.H{"method": models.PaymentMethodCard, "amountUSD": 20, "token": "tok_visa"},
+		gin.H{"method": models.PaymentMethodGiftCard, "amountUSD": 120.14, "token": "gc_1"},
+	))
+	if order.Status != models.OrderStatusNew || order.Payment.Status != models.PaymentStatusPending {
+		t.Fatalf("order %s with payment %s, want new and pending", order.Status, order.Payment.Status)
+	}
+
+	// The order leaves the kitchen and the card charge is refunded
+	want := []string{models.PaymentMethodCard + " " + models.PaymentStatusRefunded, models.PaymentMethodGiftCard + " " + models.PaymentStatusFailed}
+	env.awaitOrder(order.ID, "cancelled with tenders "+strings.Join(want, ", "), func(order *models.Order) bool {
+		var tenders []string
+		for _, tender := range order.Payment.Tenders {
+			tenders = append(tenders, tender.Method+" "+tender.Status)
+		}
+		return order.Status == models.OrderStatusCancelled && reflect.DeepEqual(tenders, want)
+	})
+}
+
+func TestWebhookRejectsWrongSignature(t *testing.T) {
+	env := newTestEnv(t)
+
+	body := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","intent":{"id":"pi_1","status":"succeeded"}}`)
+	req := httptest.NewRequest("POST", "/api/v1/payments/webhook", bytes.NewReader(body))
+	req.Header.Set(payments.SignatureHeader, payments.SignPayload("other-secret", time.Now(), body))
+	rec := httptest.NewRecorder()
+	env.router.ServeHTTP(rec, req)
+	expectStatus(t, rec, http.StatusBadRequest)
+}
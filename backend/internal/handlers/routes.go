@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/fastspot/backend/configs"
+	"github.com/fastspot/backend/internal/middleware"
+	"github.com/fastspot/backend/internal/repository"
+	"github.com/fastspot/backend/internal/services/ai"
+	"github.com/fastspot/backend/internal/services/analytics"
+	"github.com/fastspot/backend/internal/services/eta"
+	"github.com/fastspot/backend/internal/services/payments"
+	"github.com/fastspot/backend/internal/services/scheduling"
+	"github.com/gin-gonic/gin"
+)
+
+// Dependencies are the services the API routes are served with
+type Dependencies struct {
+	Config    *configs.Config
+	Repos     *repository.Repositories
+	Payments  payments.PaymentProvider
+	Gemini    *ai.GeminiService
+	Analytics *analytics.Service
+	Calendar  *scheduling.Calendar
+	Estimator *eta.Estimator
+}
+
+// RegisterRoutes registers the health check and the API routes on the
+// router. The server and the tests share it, so both serve the same routes.
+func RegisterRoutes(router *gin.Engine, deps Dependencies) {
+	config, repos, calendar, estimator := deps.Config, deps.Repos, deps.Calendar, deps.Estimator
+	paymentService, geminiService, analyticsService := deps.Payments, deps.Gemini, deps.Analytics
+
+	// Health check endpoint
+	router.GET("/health", func(c *gin.Context) {
+		response := gin.H{"status": "ok", "message": "FastSpot API is running"}
+		if status, err := calendar.Status(c.Request.Context(), time.Now()); err == nil {
+			response["store"] = status
+		}
+		c.JSON(200, response)
+	})
+
+	// Auth middleware
+	requireAuth := middleware.AuthMiddleware(config.JWTSecret, repos.RevokedTokens)
+	optionalAuth := middleware.OptionalAuthMiddleware(config.JWTSecret, repos.RevokedTokens)
+
+	// API v1 routes
+	v1 := router.Group("/api/v1")
+	{
+		// Auth routes
+		authHandler := NewAuthHandler(repos, config)
+		auth := v1.Group("/auth")
+		{
+			auth.POST("/guest", authHandler.CreateGuestSession)
+			// Optional auth picks up the guest token whose cart and orders get merged
+			auth.POST("/login", optionalAuth, authHandler.Login)
+			auth.POST("/register", optionalAuth, authHandler.Register)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.GET("/me", requireAuth, authHandler.GetCurrentUser)
+			auth.PUT("/me", requireAuth, authHandler.UpdateCurrentUser)
+			auth.POST("/logout", requireAuth, authHandler.Logout)
+		}
+
+		// Categories routes (public read, admin write)
+		categoryHandler := NewCategoryHandler(repos)
+		categories := v1.Group("/categories")
+		{
+			categories.GET("", categoryHandler.GetAll)
+			categories.GET("/:slug", categoryHandler.GetBySlug)
+		}
+		adminCategories := v1.Group("/admin/categories", requireAuth, middleware.AdminMiddleware())
+		{
+			adminCategories.POST("", categoryHandler.Create)
+			adminCategories.GET("/:id", categoryHandler.GetByID)
+			adminCategories.PUT("/:id", categoryHandler.Update)
+			adminCategories.DELETE("/:id", categoryHandler.Delete)
+		}
+
+		// Products routes
+		productHandler := NewProductHandler(repos)
+		products := v1.Group("/products")
+		{
+			products.GET("", productHandler.GetAll)
+			products.GET("/:slug", productHandler.GetBySlug)
+		}
+		adminProducts := v1.Group("/admin/products", requireAuth, middleware.AdminMiddleware())
+		{
+			adminProducts.GET("", productHandler.GetAll)
+			adminProducts.POST("", productHandler.Create)
+			adminProducts.GET("/:id", productHandler.GetByID)
+			adminProducts.PUT("/:id", productHandler.Update)
+			adminProducts.DELETE("/:id", productHandler.Delete)
+		}
+
+		// Promotions routes
+		promotionHandler := NewPromotionHandler(repos)
+		promotions := v1.Group("/promotions")
+		{
+			promotions.GET("", promotionHandler.GetAll)
+			promotions.GET("/:id", promotionHandler.GetByID)
+		}
+		adminPromotions := v1.Group("/admin/promotions", requireAuth, middleware.AdminMiddleware())
+		{
+			adminPromotions.POST("", promotionHandler.Create)
+			adminPromotions.PUT("/:id", promotionHandler.Update)
+			adminPromotions.DELETE("/:id", promotionHandler.Delete)
+		}
+		promoCodeHandler := NewPromoCodeHandler(repos)
+		adminPromoCodes := v1.Group("/admin/promo-codes", requireAuth, middleware.AdminMiddleware())
+		{
+			adminPromoCodes.GET("", promoCodeHandler.GetAll)
+			adminPromoCodes.POST("", promoCodeHandler.Create)
+			adminPromoCodes.PUT("/:id", promoCodeHandler.Update)
+			adminPromoCodes.DELETE("/:id", promoCodeHandler.Delete)
+		}
+
+		// Delivery zones
+		deliveryZoneHandler := NewDeliveryZoneHandler(repos)
+		adminDeliveryZones := v1.Group("/admin/delivery-zones", requireAuth, middleware.AdminMiddleware())
+		{
+			adminDeliveryZones.GET("", deliveryZoneHandler.GetAll)
+			adminDeliveryZones.POST("", deliveryZoneHandler.Create)
+			adminDeliveryZones.GET("/:id", deliveryZoneHandler.GetByID)
+			adminDeliveryZones.PUT("/:id", deliveryZoneHandler.Update)
+			adminDeliveryZones.DELETE("/:id", deliveryZoneHandler.Delete)
+		}
+
+		// Cart routes
+		cartHandler := NewCartHandler(repos)
+		cart := v1.Group("/cart", optionalAuth)
+		{
+			cart.GET("", cartHandler.Get)
+			cart.POST("/items", cartHandler.AddItem)
+			// :lineId also accepts a product ID for products with a single line
+			cart.PUT("/items/:lineId", cartHandler.UpdateItem)
+			cart.DELETE("/items/:lineId", cartHandler.RemoveItem)
+			cart.DELETE("", cartHandler.Clear)
+			cart.POST("/promo", cartHandler.ApplyPromo)
+			cart.DELETE("/promo", cartHandler.RemovePromo)
+		}
+
+		// Orders routes
+		orderHandler := NewOrderHandler(repos, paymentService, calendar, estimator)
+		orders := v1.Group("/orders", optionalAuth)
+		{
+			// Retries with the same Idempotency-Key replay the first order
+			orders.POST("", middleware.Idempotency(repos.Idempotency, 24*time.Hour), orderHandler.Create)
+			orders.GET("", orderHandler.GetAll)
+			orders.GET("/:id", orderHandler.GetByID)
+			orders.POST("/:id/cancel", orderHandler.Cancel)
+		}
+		// Payment provider webhooks, authenticated by their signature
+		paymentHandler := NewPaymentHandler(repos, orderHandler, config.PaymentsWebhookSecret)
+		v1.POST("/payments/webhook", paymentHandler.Webhook)
+
+		// Store hours and ordering pause
+		storeHandler := NewStoreHandler(calendar)
+		v1.GET("/store/status", storeHandler.GetStatus)
+		adminStore := v1.Group("/admin/store", requireAuth, middleware.AdminMiddleware())
+		{
+			adminStore.GET("", storeHandler.GetSettings)
+			adminStore.PUT("/hours", storeHandler.UpdateHours)
+			adminStore.POST("/pause", storeHandler.Pause)
+			adminStore.DELETE("/pause", storeHandler.Resume)
+		}
+
+		adminOrders := v1.Group("/admin/orders", requireAuth, middleware.AdminMiddleware())
+		{
+			adminOrders.GET("", orderHandler.GetAllAdmin)
+			adminOrders.PUT("/:id/status", orderHandler.UpdateStatus)
+			adminOrders.POST("/:id/payment/collect", orderHandler.CollectCash) // cash orders
+			adminOrders.PUT("/:id/courier", orderHandler.AssignCourier)
+		}
+
+		// Couriers work the deliveries assigned to them
+		courierHandler := NewCourierHandler(repos)
+		adminCouriers := v1.Group("/admin/couriers", requireAuth, middleware.AdminMiddleware())
+		{
+			adminCouriers.GET("", courierHandler.GetAll)
+			adminCouriers.POST("", courierHandler.Create)
+		}
+		courier := v1.Group("/courier", requireAuth, middleware.CourierMiddleware())
+		{
+			courier.GET("/deliveries", orderHandler.GetCourierDeliveries)
+			courier.POST("/deliveries/:id/picked-up", orderHandler.PickUp)
+			courier.POST("/deliveries/:id/location", orderHandler.UpdateLocation)
+			courier.POST("/deliveries/:id/delivered", orderHandler.Deliver)
+			courier.POST("/deliveries/:id/payment/collect", orderHandler.CollectCash) // cash on delivery
+		}
+
+		// Mood Quiz routes
+		moodHandler := NewMoodHandler(repos, geminiService)
+		mood := v1.Group("/mood", optionalAuth)
+		{
+			mood.GET("/questions", moodHandler.GetQuestions)
+			mood.POST("/recommend", moodHandler.GetRecommendations) // Guests identified by their guest token
+		}
+		// Mood Quiz Management (Questions only - AI decides recommendations)
+		adminMood := v1.Group("/admin/mood", requireAuth, middleware.AdminMiddleware())
+		{
+			adminMood.GET("/questions", moodHandler.GetAllQuestions)
+			adminMood.GET("/questions/:id", moodHandler.GetQuestionByID)
+			adminMood.POST("/questions", moodHandler.CreateQuestion)
+			adminMood.PUT("/questions/:id", moodHandler.UpdateQuestion)
+			adminMood.DELETE("/questions/:id", moodHandler.DeleteQuestion)
+		}
+
+		// Admin dashboard
+		adminHandler := NewAdminHandler(repos, analyticsService)
+		admin := v1.Group("/admin", requireAuth, middleware.AdminMiddleware())
+		{
+			admin.GET("/analytics", adminHandler.GetAnalytics)
+		}
+	}
+}
@@ -1,150 +1,184 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/fastspot/backend/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// claimsKey is the gin context key the parsed token claims are stored under
+const claimsKey = "tokenClaims"
+
 // Claims represents JWT custom claims
 type Claims struct {
-	UserID string `json:"user_id"` // Используем snake_case как в генерации токена
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID    string `json:"user_id"` // Используем snake_case как в генерации токена
+	SessionID string `json:"session_id,omitempty"`
+	FamilyID  string `json:"family_id,omitempty"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
 	jwt.RegisteredClaims
 }
 
-// AuthMiddleware validates JWT token
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// RevocationChecker reports whether an access token has been revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, tokenID, familyID string) (bool, error)
+}
+
+var errInvalidAuthFormat = errors.New("invalid authorization format")
+
+// ParseToken validates a signed token and returns its claims. Only HS256 is
+// accepted, so tokens signed with "none" or another algorithm are rejected.
+func ParseToken(tokenString, jwtSecret string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token claims")
+	}
+	return claims, nil
+}
+
+// bearerToken extracts the token from a "Bearer <token>" header
+func bearerToken(authHeader string) (string, error) {
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", errInvalidAuthFormat
+	}
+	return parts[1], nil
+}
+
+// GetClaims returns the claims of the validated access token, if any
+func GetClaims(c *gin.Context) (*Claims, bool) {
+	value, exists := c.Get(claimsKey)
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*Claims)
+	return claims, ok
+}
+
+// AuthMiddleware validates JWT token and rejects revoked tokens
+func AuthMiddleware(jwtSecret string, revocations RevocationChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error": gin.H{
-					"code":    "UNAUTHORIZED",
-					"message": "Authorization header is required",
-				},
-			})
-			c.Abort()
+			abortWithError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Authorization header is required")
 			return
 		}
 
 		// Extract token from "Bearer <token>"
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error": gin.H{
-					"code":    "UNAUTHORIZED",
-					"message": "Invalid authorization format",
-				},
-			})
-			c.Abort()
+		tokenString, err := bearerToken(authHeader)
+		if err != nil {
+			abortWithError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid authorization format")
 			return
 		}
 
-		tokenString := parts[1]
-
 		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error": gin.H{
-					"code":    "UNAUTHORIZED",
-					"message": "Invalid or expired token",
-				},
-			})
-			c.Abort()
+		claims, err := ParseToken(tokenString, jwtSecret)
+		if err != nil {
+			abortWithError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid or expired token")
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(*Claims); ok {
-			c.Set("userId", claims.UserID)
-			c.Set("role", claims.Role)
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error": gin.H{
-					"code":    "UNAUTHORIZED",
-					"message": "Invalid token claims",
-				},
-			})
-			c.Abort()
+		// Guest tokens carry no user and are rejected here
+		if claims.UserID == "" || claims.ID == "" {
+			abortWithError(c, http.StatusUnauthorized, "UNAUTHORIZED", "Invalid token claims")
+			return
+		}
+
+		revoked, err := revocations.IsRevoked(c.Request.Context(), claims.ID, claims.FamilyID)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to verify token")
 			return
 		}
+		if revoked {
+			abortWithError(c, http.StatusUnauthorized, "TOKEN_REVOKED", "Token has been revoked")
+			return
+		}
+
+		SetIdentity(c, Identity{UserID: claims.UserID, Role: claims.Role})
+		c.Set(claimsKey, claims)
+		c.Next()
 	}
 }
 
 // AdminMiddleware checks if user has admin role
 func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{
-				"success": false,
-				"error": gin.H{
-					"code":    "FORBIDDEN",
-					"message": "Admin access required",
-				},
-			})
-			c.Abort()
+		if !GetIdentity(c).IsAdmin() {
+			abortWithError(c, http.StatusForbidden, "FORBIDDEN", "Admin access required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// CourierMiddleware lets couriers through, and admins acting for them
+func CourierMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := GetIdentity(c)
+		if !identity.IsCourier() && !identity.IsAdmin() {
+			abortWithError(c, http.StatusForbidden, "FORBIDDEN", "Courier access required")
 			return
 		}
 		c.Next()
 	}
 }
 
-// OptionalAuthMiddleware validates JWT token if present, but allows guests
-func OptionalAuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// OptionalAuthMiddleware validates JWT token if present, but allows anonymous access.
+// Guests are identified only by the session ID inside a server-issued guest token.
+// Invalid or revoked tokens are treated as anonymous.
+func OptionalAuthMiddleware(jwtSecret string, revocations RevocationChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 
-		// If no auth header, allow guest access
+		// If no auth header, proceed anonymously
 		if authHeader == "" {
-			// Generate a temporary session ID for guest
-			sessionID := c.GetHeader("X-Session-ID")
-			if sessionID != "" {
-				c.Set("session_id", sessionID)
-			}
 			c.Next()
 			return
 		}
 
-		// Extract token from "Bearer <token>"
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		tokenString, err := bearerToken(authHeader)
+		if err != nil {
 			c.Next() // Allow to proceed even with invalid format
 			return
 		}
 
-		tokenString := parts[1]
-
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			// Token invalid, but allow guest access
+		claims, err := ParseToken(tokenString, jwtSecret)
+		if err != nil {
+			// Token invalid, but allow anonymous access
 			c.Next()
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(*Claims); ok {
-			c.Set("user_id", claims.UserID)
-			c.Set("role", claims.Role)
+		if claims.Role == "guest" {
+			if claims.SessionID != "" {
+				SetIdentity(c, Identity{SessionID: claims.SessionID, Role: claims.Role})
+			}
+		} else if claims.UserID != "" && claims.ID != "" {
+			revoked, err := revocations.IsRevoked(c.Request.Context(), claims.ID, claims.FamilyID)
+			if err == nil && !revoked {
+				SetIdentity(c, Identity{UserID: claims.UserID, Role: claims.Role})
+				c.Set(claimsKey, claims)
+			}
 		}
 
 		c.Next()
 	}
 }
+
+// abortWithError aborts the request with the standard error envelope
+func abortWithError(c *gin.Context, status int, code, message string) {
+	utils.RespondError(c, status, code, message)
+	c.Abort()
+}
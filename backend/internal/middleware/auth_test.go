@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// revocations is a RevocationChecker over a fixed set of token and family IDs
+type revocations map[string]bool
+
+func (r revocations) IsRevoked(ctx context.Context, tokenID, familyID string) (bool, error) {
+	return r[tokenID] || (familyID != "" && r[familyID]), nil
+}
+
+func userToken(t *testing.T, userID, role, familyID string) string {
+	t.Helper()
+	token, err := utils.GenerateJWT(userID, userID+"@local", role, familyID, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	return token
+}
+
+func guestToken(t *testing.T, sessionID string) string {
+	t.Helper()
+	token, err := utils.GenerateGuestJWT(sessionID, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateGuestJWT: %v", err)
+	}
+	return token
+}
+
+// signedWith signs customer claims with another method than HS256
+func signedWith(t *testing.T, method jwt.SigningMethod, key interface{}) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, jwt.MapClaims{
+		"user_id": "customer-1",
+		"role":    "customer",
+		"jti":     "token-1",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		t.Fatalf("sign %s token: %v", method.Alg(), err)
+	}
+	return token
+}
+
+// serve runs a request with the token through the middlewares and returns
+// the response and the identity the handler saw
+func serve(t *testing.T, token string, middlewares ...gin.HandlerFunc) (*httptest.ResponseRecorder, Identity) {
+	t.Helper()
+
+	var seen Identity
+	router := gin.New()
+	router.GET("/", append(middlewares, func(c *gin.Context) {
+		seen = GetIdentity(c)
+		c.Status(http.StatusNoContent)
+	})...)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec, seen
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Success bool `json:"success"`
+		Error   struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", rec.Body.String(), err)
+	}
+	if body.Success {
+		t.Fatalf("error response has success true: %s", rec.Body.String())
+	}
+	return body.Error.Code
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	revoked := revocations{"family-revoked": true}
+	revokedToken := userToken(t, "customer-2", "customer", "family-revoked")
+
+	tests := []struct {
+		name     string
+		token    string
+		status   int
+		code     string
+		identity Identity
+	}{
+		{"customer", userToken(t, "customer-1", "customer", "family-1"), http.StatusNoContent, "", Identity{UserID: "customer-1", Role: "customer"}},
+		{"admin", userToken(t, "admin-1", "admin", "family-2"), http.StatusNoContent, "", Identity{UserID: "admin-1", Role: "admin"}},
+		{"guest token", guestToken(t, "guest_1"), http.StatusUnauthorized, "UNAUTHORIZED", Identity{}},
+		{"revoked token", revokedToken, http.StatusUnauthorized, "TOKEN_REVOKED", Identity{}},
+		{"no token", "", http.StatusUnauthorized, "UNAUTHORIZED", Identity{}},
+		{"HS512 token", signedWith(t, jwt.SigningMethodHS512, []byte(testSecret)), http.StatusUnauthorized, "UNAUTHORIZED", Identity{}},
+		{"unsigned token", signedWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), http.StatusUnauthorized, "UNAUTHORIZED", Identity{}},
+		{"wrong secret", signedWith(t, jwt.SigningMethodHS256, []byte("other-secret")), http.StatusUnauthorized, "UNAUTHORIZED", Identity{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, identity := serve(t, tt.token, AuthMiddleware(testSecret, revoked))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.code != "" {
+				if code := errorCode(t, rec); code != tt.code {
+					t.Errorf("error code = %q, want %q", code, tt.code)
+				}
+			}
+			if identity != tt.identity {
+				t.Errorf("identity = %+v, want %+v", identity, tt.identity)
+			}
+		})
+	}
+}
+
+func TestOptionalAuthMiddleware(t *testing.T) {
+	revoked := revocations{"family-revoked": true}
+
+	tests := []struct {
+		name     string
+		token    string
+		identity Identity
+	}{
+		{"guest", guestToken(t, "guest_1"), Identity{SessionID: "guest_1", Role: "guest"}},
+		{"customer", userToken(t, "customer-1", "customer", "family-1"), Identity{UserID: "customer-1", Role: "customer"}},
+		{"admin", userToken(t, "admin-1", "admin", "family-2"), Identity{UserID: "admin-1", Role: "admin"}},
+		{"revoked token", userToken(t, "customer-2", "customer", "family-revoked"), Identity{}},
+		{"no token", "", Identity{}},
+		{"HS512 token", signedWith(t, jwt.SigningMethodHS512, []byte(testSecret)), Identity{}},
+		{"unsigned token", signedWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType), Identity{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Invalid tokens fall back to anonymous access instead of failing
+			rec, identity := serve(t, tt.token, OptionalAuthMiddleware(testSecret, revoked))
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body.String())
+			}
+			if identity != tt.identity {
+				t.Errorf("identity = %+v, want %+v", identity, tt.identity)
+			}
+		})
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"admin", userToken(t, "admin-1", "admin", "family-1"), http.StatusNoContent},
+		{"customer", userToken(t, "customer-1", "customer", "family-2"), http.StatusForbidden},
+		{"courier", userToken(t, "courier-1", "courier", "family-3"), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _ := serve(t, tt.token, AuthMiddleware(testSecret, revocations{}), AdminMiddleware())
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusForbidden && errorCode(t, rec) != "FORBIDDEN" {
+				t.Errorf("error code = %q, want FORBIDDEN", errorCode(t, rec))
+			}
+		})
+	}
+}
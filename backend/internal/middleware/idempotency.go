@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyHeader is the request header carrying the client's idempotency key
+const IdempotencyHeader = "Idempotency-Key"
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLease is how long a request keeps its key to itself. Retries
+// after that take the key over, its request is presumed to have died with
+// the instance serving it.
+const idempotencyLease = 2 * time.Minute
+
+// IdempotencyStore persists idempotency records
+type IdempotencyStore interface {
+	Begin(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	Complete(ctx context.Context, id string, status int, body []byte) error
+	Release(ctx context.Context, id string) error
+}
+
+// Idempotency makes a route safe to retry when the client sends an
+// Idempotency-Key header. The first request with a key runs the handler and
+// its successful response is stored. Retries with the same key and body get
+// the stored response replayed, while a different body or a retry racing the
+// first request is rejected with a conflict. Failed responses are not stored,
+// so the request can be retried with the same key.
+//
+// Keys are scoped to the caller's identity and the route, so it must run after
+// the auth middleware.
+func Idempotency(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(IdempotencyHeader))
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			abortWithError(c, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "Idempotency key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "INVALID_REQUEST", "Failed to read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		identity := GetIdentity(c)
+		owner := identity.UserID
+		if owner == "" {
+			owner = identity.SessionID
+		}
+		sum := sha256.Sum256(body)
+		now := time.Now()
+		record := &models.IdempotencyRecord{
+			ID:          owner + "|" + c.Request.Method + " " + c.FullPath() + "|" + key,
+			RequestHash: hex.EncodeToString(sum[:]),
+			Status:      models.IdempotencyProcessing,
+			CreatedAt:   now,
+			LockedUntil: now.Add(idempotencyLease),
+			ExpiresAt:   now.Add(ttl),
+		}
+
+		existing, err := store.Begin(c.Request.Context(), record)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to check idempotency key")
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				abortWithError(c, http.StatusConflict, "IDEMPOTENCY_KEY_REUSED", "Idempotency key was already used for a different request")
+			case existing.Status != models.IdempotencyCompleted:
+				abortWithError(c, http.StatusConflict, "REQUEST_IN_PROGRESS", "A request with this idempotency key is still being processed")
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.ResponseStatus, "application/json; charset=utf-8", existing.ResponseBody)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			// A panicking handler would leave the key processing until it
+			// expires. It is released and the panic passed on to the recovery
+			// middleware.
+			if r := recover(); r != nil {
+				finishIdempotent(store, record.ID, http.StatusInternalServerError, nil)
+				panic(r)
+			}
+		}()
+		c.Next()
+
+		finishIdempotent(store, record.ID, recorder.Status(), recorder.body.Bytes())
+	}
+}
+
+// finishIdempotent stores a successful response for replay and releases the
+// key of any other outcome
+func finishIdempotent(store IdempotencyStore, id string, status int, body []byte) {
+	// The client may be gone by now, the outcome is stored regardless
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	if status >= 200 && status < 300 {
+		err = store.Complete(ctx, id, status, body)
+	} else {
+		err = store.Release(ctx, id)
+	}
+	if err != nil {
+		log.Printf("Failed to store idempotency key outcome: %v", err)
+	}
+}
+
+// bodyRecorder copies the response body while it is written to the client
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStore is an IdempotencyStore in memory
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]*models.IdempotencyRecord
+}
+
+func (s *memoryStore) Begin(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[record.ID]; ok {
+		copied := *existing
+		return &copied, nil
+	}
+	copied := *record
+	s.records[record.ID] = &copied
+	return nil, nil
+}
+
+func (s *memoryStore) Complete(ctx context.Context, id string, status int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[id]; ok {
+		record.Status = models.IdempotencyCompleted
+		record.ResponseStatus = status
+		record.ResponseBody = append([]byte(nil), body...)
+	}
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// idempotentRouter serves POST / through the idempotency middleware, answering
+// with the statuses in turn; a status of 0 panics
+func idempotentRouter(store *memoryStore, statuses ...int) (*gin.Engine, *int) {
+	calls := 0
+	router := gin.New()
+	router.Use(gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err interface{}) {
+		abortWithError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Internal server error")
+	}))
+	router.POST("/", Idempotency(store, time.Hour), func(c *gin.Context) {
+		status := statuses[calls]
+		calls++
+		if status == 0 {
+			panic("handler failed")
+		}
+		c.JSON(status, gin.H{"success": status < 300, "call": calls})
+	})
+	return router, &calls
+}
+
+func post(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(IdempotencyHeader, key)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplaysSuccess(t *testing.T) {
+	store := &memoryStore{records: map[string]*models.IdempotencyRecord{}}
+	router, calls := idempotentRouter(store, http.StatusCreated)
+
+	first := post(router, "key-1", `{"qty":1}`)
+	retry := post(router, "key-1", `{"qty":1}`)
+	if *calls != 1 {
+		t.Fatalf("handler ran %d times, want once", *calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry = %d %s, want the replayed %d %s", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+
+	if rec := post(router, "key-1", `{"qty":2}`); rec.Code != http.StatusConflict || errorCode(t, rec) != "IDEMPOTENCY_KEY_REUSED" {
+		t.Errorf("other body = %d %s, want 409 IDEMPOTENCY_KEY_REUSED", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIdempotencyReleasesFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"error response", http.StatusBadGateway},
+		{"panic", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryStore{records: map[string]*models.IdempotencyRecord{}}
+			router, calls := idempotentRouter(store, tt.status, http.StatusCreated)
+
+			if rec := post(router, "key-1", `{}`); rec.Code < 500 {
+				t.Fatalf("first request = %d, want a server error", rec.Code)
+			}
+			if len(store.records) != 0 {
+				t.Fatalf("key kept after the failure: %+v", store.records)
+			}
+
+			// The key is free for the retry, which runs the handler again
+			if rec := post(router, "key-1", `{}`); rec.Code != http.StatusCreated || *calls != 2 {
+				t.Errorf("retry = %d after %d handler runs, want 201 after 2", rec.Code, *calls)
+			}
+		})
+	}
+}
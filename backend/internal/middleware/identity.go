@@ -0,0 +1,65 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+// identityKey is the gin context key the request identity is stored under
+const identityKey = "identity"
+
+// Identity describes who is making a request. Authenticated users have a
+// UserID, guests only a SessionID from their guest token, anonymous callers neither.
+type Identity struct {
+	UserID    string
+	SessionID string
+	Role      string
+}
+
+// IsUser reports whether the request comes from a logged-in account
+func (i Identity) IsUser() bool {
+	return i.UserID != ""
+}
+
+// IsGuest reports whether the request comes from a guest session
+func (i Identity) IsGuest() bool {
+	return i.UserID == "" && i.SessionID != ""
+}
+
+// IsAnonymous reports whether the request carries no identity at all
+func (i Identity) IsAnonymous() bool {
+	return i.UserID == "" && i.SessionID == ""
+}
+
+// IsAdmin reports whether the request comes from an admin account
+func (i Identity) IsAdmin() bool {
+	return i.IsUser() && i.Role == "admin"
+}
+
+// IsCourier reports whether the request comes from a courier account
+func (i Identity) IsCourier() bool {
+	return i.IsUser() && i.Role == "courier"
+}
+
+// Owns reports whether a resource belonging to userID / sessionID belongs to this identity
+func (i Identity) Owns(userID, sessionID string) bool {
+	if i.IsUser() {
+		return userID == i.UserID
+	}
+	if i.IsGuest() {
+		return userID == "" && sessionID == i.SessionID
+	}
+	return false
+}
+
+// SetIdentity stores the request identity in the gin context
+func SetIdentity(c *gin.Context, identity Identity) {
+	c.Set(identityKey, identity)
+}
+
+// GetIdentity returns the request identity, or an anonymous one if none was set
+func GetIdentity(c *gin.Context) Identity {
+	if value, exists := c.Get(identityKey); exists {
+		if identity, ok := value.(Identity); ok {
+			return identity
+		}
+	}
+	return Identity{}
+}
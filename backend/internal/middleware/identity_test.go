@@ -0,0 +1,65 @@
+package middleware
+
+import "testing"
+
+func TestIdentityRoles(t *testing.T) {
+	tests := []struct {
+		name                          string
+		identity                      Identity
+		user, guest, anonymous, admin bool
+	}{
+		{"anonymous", Identity{}, false, false, true, false},
+		{"guest", Identity{SessionID: "guest_1", Role: "guest"}, false, true, false, false},
+		{"customer", Identity{UserID: "customer-1", Role: "customer"}, true, false, false, false},
+		{"admin", Identity{UserID: "admin-1", Role: "admin"}, true, false, false, true},
+		// The role only counts for logged-in accounts
+		{"admin role without user", Identity{SessionID: "guest_1", Role: "admin"}, false, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.identity.IsUser(); got != tt.user {
+				t.Errorf("IsUser() = %v, want %v", got, tt.user)
+			}
+			if got := tt.identity.IsGuest(); got != tt.guest {
+				t.Errorf("IsGuest() = %v, want %v", got, tt.guest)
+			}
+			if got := tt.identity.IsAnonymous(); got != tt.anonymous {
+				t.Errorf("IsAnonymous() = %v, want %v", got, tt.anonymous)
+			}
+			if got := tt.identity.IsAdmin(); got != tt.admin {
+				t.Errorf("IsAdmin() = %v, want %v", got, tt.admin)
+			}
+		})
+	}
+}
+
+func TestIdentityOwns(t *testing.T) {
+	customer := Identity{UserID: "customer-1", Role: "customer"}
+	guest := Identity{SessionID: "guest_1", Role: "guest"}
+
+	tests := []struct {
+		name              string
+		identity          Identity
+		userID, sessionID string
+		owns              bool
+	}{
+		{"customer own order", customer, "customer-1", "", true},
+		{"customer order placed as guest before login", customer, "customer-1", "guest_1", true},
+		{"other customer", customer, "customer-2", "", false},
+		{"customer and guest order of same session", customer, "", "guest_1", false},
+		{"guest own order", guest, "", "guest_1", true},
+		{"other guest", guest, "", "guest_2", false},
+		// Once adopted by an account, the session no longer gives access
+		{"guest order adopted by account", guest, "customer-1", "guest_1", false},
+		{"anonymous", Identity{}, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.identity.Owns(tt.userID, tt.sessionID); got != tt.owns {
+				t.Errorf("Owns(%q, %q) = %v, want %v", tt.userID, tt.sessionID, got, tt.owns)
+			}
+		})
+	}
+}
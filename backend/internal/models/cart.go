@@ -8,24 +8,31 @@ import (
 
 // Cart represents a shopping cart
 type Cart struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID    string             `bson:"userId,omitempty" json:"userId,omitempty"`
-	SessionID string             `bson:"sessionId,omitempty" json:"sessionId,omitempty"`
-	Items     []CartItem         `bson:"items" json:"items"`
-	TotalUSD  float64            `bson:"totalUSD" json:"totalUSD"`
-	Currency  string             `bson:"currency" json:"currency"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           string             `bson:"userId,omitempty" json:"userId,omitempty"`
+	SessionID        string             `bson:"sessionId,omitempty" json:"sessionId,omitempty"`
+	Items            []CartItem         `bson:"items" json:"items"`
+	SubtotalUSD      float64            `bson:"subtotalUSD" json:"subtotalUSD"`
+	DiscountUSD      float64            `bson:"discountUSD" json:"discountUSD"` // line and order-level discounts combined
+	AppliedPromotion *AppliedPromotion  `bson:"appliedPromotion,omitempty" json:"appliedPromotion,omitempty"`
+	PromoCode        string             `bson:"promoCode,omitempty" json:"promoCode,omitempty"` // entered by the customer, redeemed at checkout
+	TotalUSD         float64            `bson:"totalUSD" json:"totalUSD"`
+	Currency         string             `bson:"currency" json:"currency"`
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
 // CartItem represents an item in the cart
 type CartItem struct {
+	LineID            string             `bson:"lineId" json:"lineId"` // stable ID of the product + options + ingredients configuration
 	ProductID         primitive.ObjectID `bson:"productId" json:"productId"`
+	Slug              string             `bson:"slug" json:"slug"` // product slug promotions are matched against
 	Name              string             `bson:"name" json:"name"`
 	Image             string             `bson:"image" json:"image"`
 	Qty               int                `bson:"qty" json:"qty"`
 	UnitPriceUSD      float64            `bson:"unitPriceUSD" json:"unitPriceUSD"`
-	TotalUSD          float64            `bson:"totalUSD" json:"totalUSD"`
+	TotalUSD          float64            `bson:"totalUSD" json:"totalUSD"`       // before discounts
+	DiscountUSD       float64            `bson:"discountUSD" json:"discountUSD"` // line discount of the applied promotion
 	ChosenIngredients []string           `bson:"chosenIngredients" json:"chosenIngredients"`
 	ChosenOptions     map[string]string  `bson:"chosenOptions" json:"chosenOptions"`
 }
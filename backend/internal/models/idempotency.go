@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Idempotency record states
+const (
+	IdempotencyProcessing = "processing"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key header
+// and, once it succeeded, the response to replay for retries. A record still
+// processing after LockedUntil was left behind by an instance that died
+// mid-request, a retry may take it over.
+type IdempotencyRecord struct {
+	ID             string    `bson:"_id"` // owner, route and client key
+	RequestHash    string    `bson:"requestHash"`
+	Status         string    `bson:"status"`
+	ResponseStatus int       `bson:"responseStatus,omitempty"`
+	ResponseBody   []byte    `bson:"responseBody,omitempty"`
+	CreatedAt      time.Time `bson:"createdAt"`
+	LockedUntil    time.Time `bson:"lockedUntil"`
+	ExpiresAt      time.Time `bson:"expiresAt"`
+}
@@ -6,56 +6,187 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Courier tracking events
+const (
+	TrackingEventCourierAssigned = "courier_assigned"
+	TrackingEventPickedUp        = "picked_up"
+	TrackingEventLocation        = "location"
+	TrackingEventDelivered       = "delivered"
+)
+
+// Order statuses
+const (
+	OrderStatusScheduled  = "scheduled" // waiting for its release time before going to the kitchen
+	OrderStatusNew        = "new"
+	OrderStatusConfirmed  = "confirmed"
+	OrderStatusPreparing  = "preparing"
+	OrderStatusReady      = "ready"
+	OrderStatusDelivering = "delivering"
+	OrderStatusCompleted  = "completed"
+	OrderStatusCancelled  = "cancelled"
+)
+
+// Payment statuses
+const (
+	PaymentStatusPending      = "pending"
+	PaymentStatusCompleted    = "completed"
+	PaymentStatusFailed       = "failed"
+	PaymentStatusVoided       = "voided"
+	PaymentStatusRefunded     = "refunded"
+	PaymentStatusRefundFailed = "refund_failed"
+)
+
+// Payment methods. Cash is paid on delivery or at pickup and never charged online.
+const (
+	PaymentMethodCard      = "card"
+	PaymentMethodApplePay  = "applepay"
+	PaymentMethodGooglePay = "googlepay"
+	PaymentMethodGiftCard  = "giftcard"
+	PaymentMethodCash      = "cash"
+	PaymentMethodSplit     = "split" // Payment.Method of orders paid with several tenders
+)
+
+// IsValidPaymentMethod reports whether method is a known payment method
+func IsValidPaymentMethod(method string) bool {
+	switch method {
+	case PaymentMethodCard, PaymentMethodApplePay, PaymentMethodGooglePay, PaymentMethodGiftCard, PaymentMethodCash:
+		return true
+	}
+	return false
+}
+
 // Order represents a customer order
 type Order struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID       string             `bson:"userId,omitempty" json:"userId,omitempty"`
-	SessionID    string             `bson:"sessionId,omitempty" json:"sessionId,omitempty"`
-	OrderNumber  string             `bson:"orderNumber" json:"orderNumber"`
-	Items        []OrderItem        `bson:"items" json:"items"`
-	TotalUSD     float64            `bson:"totalUSD" json:"totalUSD"`
-	Currency     string             `bson:"currency" json:"currency"`
-	Status       string             `bson:"status" json:"status"` // new, confirmed, preparing, ready, delivering, completed, cancelled
-	Payment      Payment            `bson:"payment" json:"payment"`
-	Delivery     Delivery           `bson:"delivery" json:"delivery"`
-	CustomerInfo CustomerInfo       `bson:"customerInfo" json:"customerInfo"`
-	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt    time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID           string             `bson:"userId,omitempty" json:"userId,omitempty"`
+	SessionID        string             `bson:"sessionId,omitempty" json:"sessionId,omitempty"`
+	OrderNumber      string             `bson:"orderNumber" json:"orderNumber"`
+	Items            []OrderItem        `bson:"items" json:"items"`
+	SubtotalUSD      float64            `bson:"subtotalUSD" json:"subtotalUSD"`
+	DiscountUSD      float64            `bson:"discountUSD" json:"discountUSD"` // line and order-level discounts combined
+	AppliedPromotion *AppliedPromotion  `bson:"appliedPromotion,omitempty" json:"appliedPromotion,omitempty"`
+	Fees             []FeeLine          `bson:"fees,omitempty" json:"fees,omitempty"` // e.g. the delivery fee
+	TipUSD           float64            `bson:"tipUSD" json:"tipUSD"`
+	TotalUSD         float64            `bson:"totalUSD" json:"totalUSD"` // amount due: after discounts, fees and tip included
+	Currency         string             `bson:"currency" json:"currency"`
+	Status           string             `bson:"status" json:"status"` // scheduled, new, confirmed, preparing, ready, delivering, completed, cancelled
+	Payment          Payment            `bson:"payment" json:"payment"`
+	Delivery         Delivery           `bson:"delivery" json:"delivery"`
+	CustomerInfo     CustomerInfo       `bson:"customerInfo" json:"customerInfo"`
+	Version          int                `bson:"version" json:"version"` // incremented on every status or courier change
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
 // OrderItem represents an item in an order
 type OrderItem struct {
+	LineID            string             `bson:"lineId" json:"lineId"` // stable ID of the product + options + ingredients configuration
 	ProductID         primitive.ObjectID `bson:"productId" json:"productId"`
+	Slug              string             `bson:"slug" json:"slug"` // product slug promotions are matched against
 	Name              string             `bson:"name" json:"name"`
 	Image             string             `bson:"image" json:"image"`
 	Qty               int                `bson:"qty" json:"qty"`
 	UnitPriceUSD      float64            `bson:"unitPriceUSD" json:"unitPriceUSD"`
-	TotalUSD          float64            `bson:"totalUSD" json:"totalUSD"`
+	TotalUSD          float64            `bson:"totalUSD" json:"totalUSD"`       // before discounts
+	DiscountUSD       float64            `bson:"discountUSD" json:"discountUSD"` // line discount of the applied promotion
 	ChosenIngredients []string           `bson:"chosenIngredients" json:"chosenIngredients"`
 	ChosenOptions     map[string]string  `bson:"chosenOptions" json:"chosenOptions"`
 }
 
+// Fee types
+const (
+	FeeTypeDelivery = "delivery"
+)
+
+// FeeLine is a charge on an order besides its items
+type FeeLine struct {
+	Type      string  `bson:"type" json:"type"` // delivery
+	Label     string  `bson:"label" json:"label"`
+	AmountUSD float64 `bson:"amountUSD" json:"amountUSD"`
+}
+
 // Payment represents payment information
+// Status, and with a single tender Method, TxnID and RefundTxnID, summarize the tenders
 type Payment struct {
-	Method string `bson:"method" json:"method"` // card, applepay, googlepay, cash
-	Status string `bson:"status" json:"status"` // pending, completed, failed
-	TxnID  string `bson:"txnId,omitempty" json:"txnId,omitempty"`
+	Method      string   `bson:"method" json:"method"` // card, applepay, googlepay, giftcard, cash or split
+	Status      string   `bson:"status" json:"status"` // pending, completed, failed, voided, refunded, refund_failed
+	TxnID       string   `bson:"txnId,omitempty" json:"txnId,omitempty"`
+	RefundTxnID string   `bson:"refundTxnId,omitempty" json:"refundTxnId,omitempty"`
+	Tenders     []Tender `bson:"tenders,omitempty" json:"tenders,omitempty"`
+	Revision    int      `bson:"revision" json:"-"` // incremented on every payment update
+}
+
+// Tender is one payment entry of an order, e.g. part by gift card and the rest by card
+type Tender struct {
+	Method      string  `bson:"method" json:"method"`
+	AmountUSD   float64 `bson:"amountUSD" json:"amountUSD"`
+	Status      string  `bson:"status" json:"status"`
+	TxnID       string  `bson:"txnId,omitempty" json:"txnId,omitempty"`
+	RefundTxnID string  `bson:"refundTxnId,omitempty" json:"refundTxnId,omitempty"`
+
+	// Cash tenders, recorded when staff collect the money
+	TenderedUSD float64    `bson:"tenderedUSD,omitempty" json:"tenderedUSD,omitempty"`
+	ChangeUSD   float64    `bson:"changeUSD,omitempty" json:"changeUSD,omitempty"`
+	CollectedBy string     `bson:"collectedBy,omitempty" json:"collectedBy,omitempty"`
+	CollectedAt *time.Time `bson:"collectedAt,omitempty" json:"collectedAt,omitempty"`
 }
 
 // Delivery represents delivery information
 type Delivery struct {
-	Type     string          `bson:"type" json:"type"` // pickup, delivery
-	Address  DeliveryAddress `bson:"address,omitempty" json:"address,omitempty"`
-	ETA      time.Time       `bson:"eta,omitempty" json:"eta,omitempty"`
+	Type    string          `bson:"type" json:"type"` // pickup, delivery
+	Address DeliveryAddress `bson:"address,omitempty" json:"address,omitempty"`
+	Zone    *ZoneRef        `bson:"zone,omitempty" json:"zone,omitempty"` // delivery orders
+
+	// ETA and how it was computed, refreshed on every status change
+	ETA          time.Time     `bson:"eta,omitempty" json:"eta,omitempty"`
+	ETABreakdown *ETABreakdown `bson:"etaBreakdown,omitempty" json:"etaBreakdown,omitempty"`
+
+	// Scheduled orders: the fulfillment time the customer asked for and when
+	// the order goes to the kitchen to be ready by then
+	ScheduledFor *time.Time `bson:"scheduledFor,omitempty" json:"scheduledFor,omitempty"`
+	ReleaseAt    *time.Time `bson:"releaseAt,omitempty" json:"releaseAt,omitempty"`
+
+	// Delivery orders: the courier taking the order out, where they were last
+	// seen and the photo they took on handover
+	Courier         *CourierRef      `bson:"courier,omitempty" json:"courier,omitempty"`
+	CourierLocation *CourierLocation `bson:"courierLocation,omitempty" json:"courierLocation,omitempty"`
+	ProofPhotoURL   string           `bson:"proofPhotoUrl,omitempty" json:"proofPhotoUrl,omitempty"`
+
 	Tracking []TrackingEvent `bson:"tracking" json:"tracking"`
 }
 
+// CourierRef records the courier assigned to a delivery
+type CourierRef struct {
+	ID         string    `bson:"id" json:"id"`
+	Name       string    `bson:"name" json:"name"`
+	Phone      string    `bson:"phone,omitempty" json:"phone,omitempty"`
+	AssignedAt time.Time `bson:"assignedAt" json:"assignedAt"`
+}
+
+// CourierLocation is the last position a courier reported
+type CourierLocation struct {
+	GeoPoint  `bson:",inline"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ETABreakdown explains an ETA in minutes from when it was computed
+type ETABreakdown struct {
+	OrdersAhead     int       `bson:"ordersAhead" json:"ordersAhead"`   // orders waiting or in the kitchen before this one
+	QueueMinutes    int       `bson:"queueMinutes" json:"queueMinutes"` // wait until the kitchen starts the order
+	PrepMinutes     int       `bson:"prepMinutes" json:"prepMinutes"`   // longest prep time of the items
+	DeliveryMinutes int       `bson:"deliveryMinutes" json:"deliveryMinutes"`
+	Zone            string    `bson:"zone,omitempty" json:"zone,omitempty"` // delivery zone the time was taken from
+	TotalMinutes    int       `bson:"totalMinutes" json:"totalMinutes"`     // remaining at computedAt
+	ComputedAt      time.Time `bson:"computedAt" json:"computedAt"`
+}
+
 // DeliveryAddress represents delivery address
 type DeliveryAddress struct {
-	Street  string `bson:"street" json:"street"`
-	City    string `bson:"city" json:"city"`
-	ZipCode string `bson:"zipCode" json:"zipCode"`
-	Notes   string `bson:"notes,omitempty" json:"notes,omitempty"`
+	Street   string    `bson:"street" json:"street"`
+	City     string    `bson:"city" json:"city"`
+	ZipCode  string    `bson:"zipCode" json:"zipCode"`
+	Notes    string    `bson:"notes,omitempty" json:"notes,omitempty"`
+	Location *GeoPoint `bson:"location,omitempty" json:"location,omitempty"` // optional, matched against delivery zone areas
 }
 
 // TrackingEvent represents an order tracking event
@@ -63,6 +194,12 @@ type TrackingEvent struct {
 	Timestamp time.Time `bson:"ts" json:"ts"`
 	Status    string    `bson:"status" json:"status"`
 	Note      string    `bson:"note,omitempty" json:"note,omitempty"`
+	ActorID   string    `bson:"actorId,omitempty" json:"actorId,omitempty"` // staff member or courier who made the change
+
+	// Courier updates
+	Event    string    `bson:"event,omitempty" json:"event,omitempty"` // courier_assigned, picked_up, location, delivered
+	Location *GeoPoint `bson:"location,omitempty" json:"location,omitempty"`
+	PhotoURL string    `bson:"photoUrl,omitempty" json:"photoUrl,omitempty"`
 }
 
 // CustomerInfo represents customer contact information
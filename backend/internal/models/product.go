@@ -18,6 +18,7 @@ type Product struct {
 	Ingredients []Ingredient       `bson:"ingredients" json:"ingredients"`
 	Options     []ProductOption    `bson:"options" json:"options"`
 	Tags        []string           `bson:"tags" json:"tags"`
+	PrepMinutes int                `bson:"prepMinutes" json:"prepMinutes"` // kitchen time, 0 for the default
 	CreatedAt   time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
 	UpdatedAt   time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
 }
@@ -52,4 +53,5 @@ type CreateProductRequest struct {
 	Ingredients []Ingredient    `json:"ingredients"`
 	Options     []ProductOption `json:"options"`
 	Tags        []string        `json:"tags"`
+	PrepMinutes int             `json:"prepMinutes"`
 }
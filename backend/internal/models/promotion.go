@@ -8,14 +8,78 @@ import (
 
 // Promotion represents a promotional offer
 type Promotion struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title        string             `bson:"title" json:"title"`
+	Description  string             `bson:"description" json:"description"`
+	StartsAt     time.Time          `bson:"startsAt" json:"startsAt"`
+	EndsAt       time.Time          `bson:"endsAt" json:"endsAt"`
+	BannerImage  string             `bson:"bannerImage" json:"bannerImage"`
+	IsActive     bool               `bson:"isActive" json:"isActive"`
+	AppliesTo    []string           `bson:"appliesTo" json:"appliesTo"`                   // Array of product slugs, empty or "all" for every product
+	Discount     *DiscountRule      `bson:"discount,omitempty" json:"discount,omitempty"` // nil for display-only promotions
+	RequiresCode bool               `bson:"requiresCode" json:"requiresCode"`             // only applied through a promo code
+	CreatedAt    time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt    time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+}
+
+// Discount rule types
+const (
+	DiscountPercent  = "percent"     // Percent off every eligible line
+	DiscountFixed    = "fixed"       // Fixed amount off the order
+	DiscountBuyXGetY = "buy_x_get_y" // GetQty free units for every BuyQty units of a line
+)
+
+// DiscountRule describes how a promotion changes prices
+type DiscountRule struct {
+	Type           string  `bson:"type" json:"type"`
+	Percent        float64 `bson:"percent,omitempty" json:"percent,omitempty"`
+	AmountUSD      float64 `bson:"amountUSD,omitempty" json:"amountUSD,omitempty"`
+	BuyQty         int     `bson:"buyQty,omitempty" json:"buyQty,omitempty"`
+	GetQty         int     `bson:"getQty,omitempty" json:"getQty,omitempty"`
+	MinSubtotalUSD float64 `bson:"minSubtotalUSD,omitempty" json:"minSubtotalUSD,omitempty"` // cart subtotal required before the discount applies
+}
+
+// AppliedPromotion records the promotion priced into a cart or order
+type AppliedPromotion struct {
+	PromotionID primitive.ObjectID `bson:"promotionId" json:"promotionId"`
 	Title       string             `bson:"title" json:"title"`
-	Description string             `bson:"description" json:"description"`
-	StartsAt    time.Time          `bson:"startsAt" json:"startsAt"`
-	EndsAt      time.Time          `bson:"endsAt" json:"endsAt"`
-	BannerImage string             `bson:"bannerImage" json:"bannerImage"`
-	IsActive    bool               `bson:"isActive" json:"isActive"`
-	AppliesTo   []string           `bson:"appliesTo" json:"appliesTo"` // Array of product slugs
-	CreatedAt   time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+	Type        string             `bson:"type" json:"type"`
+	DiscountUSD float64            `bson:"discountUSD" json:"discountUSD"`
+	Code        string             `bson:"code,omitempty" json:"code,omitempty"` // promo code the promotion was applied through
+	Redeemer    string             `bson:"redeemer,omitempty" json:"-"`          // whom the code redemption was counted for, on orders
+}
+
+// PromoCode is a code customers enter to get a promotion that requires one
+type PromoCode struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code           string             `bson:"code" json:"code"` // stored upper case
+	PromotionID    primitive.ObjectID `bson:"promotionId" json:"promotionId"`
+	MaxRedemptions int                `bson:"maxRedemptions" json:"maxRedemptions"` // 0 for unlimited
+	MaxPerUser     int                `bson:"maxPerUser" json:"maxPerUser"`         // 0 for unlimited
+	Redemptions    int                `bson:"redemptions" json:"redemptions"`
+	ExpiresAt      *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	IsActive       bool               `bson:"isActive" json:"isActive"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PromoRedemption counts how often one customer redeemed a promo code
+type PromoRedemption struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code      string             `bson:"code" json:"code"`
+	Redeemer  string             `bson:"redeemer" json:"redeemer"` // user ID
+	Count     int                `bson:"count" json:"count"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PromoCodeRequest is the admin payload to create or update a promo code.
+// ClearExpiresAt removes the expiry of an existing code.
+type PromoCodeRequest struct {
+	Code           string     `json:"code"`
+	PromotionID    string     `json:"promotionId"`
+	MaxRedemptions *int       `json:"maxRedemptions"`
+	MaxPerUser     *int       `json:"maxPerUser"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	ClearExpiresAt bool       `json:"clearExpiresAt"`
+	IsActive       *bool      `json:"isActive"`
 }
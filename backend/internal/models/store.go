@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// StoreSettingsID is the ID of the single store settings document
+const StoreSettingsID = "store"
+
+// StoreSettings holds when the store takes orders
+type StoreSettings struct {
+	ID          string                  `bson:"_id" json:"-"`
+	Timezone    string                  `bson:"timezone" json:"timezone"`       // IANA time zone the hours are in
+	WeeklyHours map[string][]TimeWindow `bson:"weeklyHours" json:"weeklyHours"` // keyed by mon … sun, missing days are closed
+	Holidays    []HolidayException      `bson:"holidays" json:"holidays"`
+	Pause       *OrderingPause          `bson:"pause,omitempty" json:"pause,omitempty"`
+	UpdatedAt   time.Time               `bson:"updatedAt" json:"updatedAt"`
+	Version     int                     `bson:"version" json:"-"` // incremented by every save
+}
+
+// TimeWindow is an opening window within a day, e.g. 11:00 to 22:00
+type TimeWindow struct {
+	Open  string `bson:"open" json:"open"`   // HH:MM
+	Close string `bson:"close" json:"close"` // HH:MM, 24:00 for midnight
+}
+
+// HolidayException replaces the weekly hours on one date
+type HolidayException struct {
+	Date  string       `bson:"date" json:"date"` // YYYY-MM-DD in the store time zone
+	Name  string       `bson:"name" json:"name"`
+	Hours []TimeWindow `bson:"hours" json:"hours"` // empty when closed all day
+}
+
+// OrderingPause temporarily stops the store from taking orders, e.g. when
+// the kitchen is overwhelmed
+type OrderingPause struct {
+	Reason   string     `bson:"reason,omitempty" json:"reason,omitempty"`
+	Until    *time.Time `bson:"until,omitempty" json:"until,omitempty"` // nil until resumed by hand
+	PausedBy string     `bson:"pausedBy,omitempty" json:"pausedBy,omitempty"`
+	PausedAt time.Time  `bson:"pausedAt" json:"pausedAt"`
+}
+
+// StoreSettingsRequest represents the request body for updating the store hours
+type StoreSettingsRequest struct {
+	Timezone    string                  `json:"timezone" binding:"required"`
+	WeeklyHours map[string][]TimeWindow `json:"weeklyHours" binding:"required"`
+	Holidays    []HolidayException      `json:"holidays"`
+}
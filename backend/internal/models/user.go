@@ -6,14 +6,23 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// User roles
+const (
+	RoleAdmin    = "admin"
+	RoleCourier  = "courier"
+	RoleCustomer = "customer"
+	RoleGuest    = "guest"
+)
+
 type User struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Role         string             `bson:"role" json:"role"` // "admin" | "guest"
+	Role         string             `bson:"role" json:"role"` // "admin" | "courier" | "customer"
 	Name         string             `bson:"name" json:"name"`
 	Email        string             `bson:"email" json:"email"`
 	Phone        string             `bson:"phone" json:"phone"`
 	PasswordHash string             `bson:"passwordHash" json:"-"`
 	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time          `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
 }
 
 type LoginRequest struct {
@@ -27,3 +36,9 @@ type RegisterRequest struct {
 	Phone    string `json:"phone"`
 	Password string `json:"password" binding:"required,min=6"`
 }
+
+// UpdateProfileRequest holds the profile fields a user may change themselves
+type UpdateProfileRequest struct {
+	Name  *string `json:"name" binding:"omitempty,min=1"`
+	Phone *string `json:"phone"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeliveryZone is an area the store delivers to, given as postcodes, a
+// GeoJSON polygon or both
+type DeliveryZone struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name             string             `bson:"name" json:"name"`
+	Postcodes        []string           `bson:"postcodes" json:"postcodes"`               // normalized to upper case without spaces
+	Area             *GeoPolygon        `bson:"area,omitempty" json:"area,omitempty"`     // matched against the address location
+	FeeUSD           float64            `bson:"feeUSD" json:"feeUSD"`                     // delivery fee added to the order
+	MinOrderUSD      float64            `bson:"minOrderUSD" json:"minOrderUSD"`           // minimum order value after discounts
+	ETAOffsetMinutes int                `bson:"etaOffsetMinutes" json:"etaOffsetMinutes"` // added to the base delivery time
+	IsActive         bool               `bson:"isActive" json:"isActive"`
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// GeoPolygon is a GeoJSON Polygon: rings of [longitude, latitude] positions,
+// the first ring the outer boundary and any others holes
+type GeoPolygon struct {
+	Type        string        `bson:"type" json:"type"` // Polygon
+	Coordinates [][][]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// GeoPoint is the location of an address
+type GeoPoint struct {
+	Lat float64 `bson:"lat" json:"lat"`
+	Lng float64 `bson:"lng" json:"lng"`
+}
+
+// DeliveryZoneRequest is the admin payload to create or replace a delivery zone
+type DeliveryZoneRequest struct {
+	Name             string      `json:"name" binding:"required"`
+	Postcodes        []string    `json:"postcodes"`
+	Area             *GeoPolygon `json:"area"`
+	FeeUSD           float64     `json:"feeUSD"`
+	MinOrderUSD      float64     `json:"minOrderUSD"`
+	ETAOffsetMinutes int         `json:"etaOffsetMinutes"`
+	IsActive         *bool       `json:"isActive"`
+}
+
+// ZoneRef records the delivery zone an order was resolved to
+type ZoneRef struct {
+	ID               primitive.ObjectID `bson:"id" json:"id"`
+	Name             string             `bson:"name" json:"name"`
+	ETAOffsetMinutes int                `bson:"etaOffsetMinutes" json:"etaOffsetMinutes"`
+	MatchedBy        string             `bson:"matchedBy,omitempty" json:"matchedBy,omitempty"` // postcode, or location when matched by the client-supplied location
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/fastspot/backend/internal/models"
@@ -11,16 +13,51 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrVersionConflict is returned when a document was modified concurrently
+var ErrVersionConflict = errors.New("document was modified concurrently")
+
+// ErrPromoCodeUnavailable is returned when a promo code is inactive, expired or used up
+var ErrPromoCodeUnavailable = errors.New("promo code is no longer available")
+
+// ErrPromoCodeLoginRequired is returned when a guest redeems a promo code limited per customer
+var ErrPromoCodeLoginRequired = errors.New("promo code requires an account")
+
 // Repositories holds all repository instances
 type Repositories struct {
 	Users         *UserRepository
 	Categories    *CategoryRepository
 	Products      *ProductRepository
 	Promotions    *PromotionRepository
+	PromoCodes    *PromoCodeRepository
 	Carts         *CartRepository
 	Orders        *OrderRepository
 	MoodQuestions *MoodQuestionRepository
 	AISessions    *AISessionRepository
+	RefreshTokens *RefreshTokenRepository
+	RevokedTokens *RevokedTokenRepository
+	Idempotency   *IdempotencyRepository
+	StoreSettings *StoreSettingsRepository
+	DeliveryZones *DeliveryZoneRepository
+}
+
+// NewRepositories creates the repositories of all collections in db
+func NewRepositories(db *mongo.Database) *Repositories {
+	return &Repositories{
+		Users:         NewUserRepository(db),
+		Categories:    NewCategoryRepository(db),
+		Products:      NewProductRepository(db),
+		Promotions:    NewPromotionRepository(db),
+		PromoCodes:    NewPromoCodeRepository(db),
+		Carts:         NewCartRepository(db),
+		Orders:        NewOrderRepository(db),
+		MoodQuestions: NewMoodQuestionRepository(db),
+		AISessions:    NewAISessionRepository(db),
+		RefreshTokens: NewRefreshTokenRepository(db),
+		RevokedTokens: NewRevokedTokenRepository(db),
+		Idempotency:   NewIdempotencyRepository(db),
+		StoreSettings: NewStoreSettingsRepository(db),
+		DeliveryZones: NewDeliveryZoneRepository(db),
+	}
 }
 
 // User Repository
@@ -41,6 +78,21 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models
 	return &user, nil
 }
 
+// FindByRole returns the users with a role, by name
+func (r *UserRepository) FindByRole(ctx context.Context, role string) ([]*models.User, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"role": role}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var users []*models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 func (r *UserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
 	var user models.User
 	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
@@ -50,6 +102,80 @@ func (r *UserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*
 	return &user, nil
 }
 
+// NormalizeEmails rewrites stored emails with normalize, accounts used to
+// keep them as entered. An account whose normalized email is taken by another
+// one keeps its email, how many did is returned.
+func (r *UserRepository) NormalizeEmails(ctx context.Context, normalize func(string) string) (int, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"email": bson.M{"$regex": `[A-Z]|^\s|\s$`}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	kept := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return 0, err
+		}
+		email := normalize(user.Email)
+		if email == user.Email {
+			continue
+		}
+		taken, err := r.collection.CountDocuments(ctx, bson.M{"email": email})
+		if err != nil {
+			return 0, err
+		}
+		if taken > 0 {
+			kept++
+			continue
+		}
+		_, err = r.collection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"email": email, "updatedAt": time.Now()}})
+		if mongo.IsDuplicateKeyError(err) {
+			kept++
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return kept, cursor.Err()
+}
+
+// EnsureIndexes creates the unique email index
+func (r *UserRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) (*models.User, error) {
+	result, err := r.collection.InsertOne(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return user, nil
+}
+
+// UpdateProfile sets the given profile fields and returns the updated user
+func (r *UserRepository) UpdateProfile(ctx context.Context, id primitive.ObjectID, fields bson.M) (*models.User, error) {
+	fields["updatedAt"] = time.Now()
+
+	result := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": fields}, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.User
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
 // Category Repository
 type CategoryRepository struct {
 	collection *mongo.Collection
@@ -121,6 +247,10 @@ func (r *CategoryRepository) Update(ctx context.Context, id primitive.ObjectID,
 	return &updated, nil
 }
 
+func (r *CategoryRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
 func (r *CategoryRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
@@ -198,6 +328,10 @@ func (r *ProductRepository) Update(ctx context.Context, id primitive.ObjectID, p
 	return &updated, nil
 }
 
+func (r *ProductRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
 func (r *ProductRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
@@ -271,6 +405,10 @@ func (r *PromotionRepository) Update(ctx context.Context, id primitive.ObjectID,
 	return &updated, nil
 }
 
+func (r *PromotionRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
 func (r *PromotionRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
 	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
@@ -282,6 +420,266 @@ func (r *PromotionRepository) Delete(ctx context.Context, id primitive.ObjectID)
 	return nil
 }
 
+// PromoCode Repository
+type PromoCodeRepository struct {
+	collection  *mongo.Collection
+	redemptions *mongo.Collection // how often each customer redeemed each code
+}
+
+func NewPromoCodeRepository(db *mongo.Database) *PromoCodeRepository {
+	return &PromoCodeRepository{
+		collection:  db.Collection("promo_codes"),
+		redemptions: db.Collection("promo_redemptions"),
+	}
+}
+
+// EnsureIndexes creates the unique code index and the unique index of the
+// redemption counts, one per code and redeemer
+func (r *PromoCodeRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.redemptions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}, {Key: "redeemer", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// MigrateRedeemedBy moves the redemption counts promo codes used to keep in
+// a redeemedBy map into the redemptions collection. Counts of guest sessions
+// are dropped, only customers are counted now.
+func (r *PromoCodeRepository) MigrateRedeemedBy(ctx context.Context) error {
+	cursor, err := r.collection.Find(ctx, bson.M{"redeemedBy": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var legacy struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			Code       string             `bson:"code"`
+			RedeemedBy map[string]int     `bson:"redeemedBy"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return err
+		}
+
+		for redeemer, count := range legacy.RedeemedBy {
+			if count <= 0 || strings.HasPrefix(redeemer, "guest_") {
+				continue
+			}
+			_, err := r.redemptions.UpdateOne(ctx,
+				bson.M{"code": legacy.Code, "redeemer": redeemer},
+				bson.M{
+					"$max": bson.M{"count": count},
+					"$set": bson.M{"updatedAt": time.Now()},
+				},
+				options.Update().SetUpsert(true),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := r.collection.UpdateOne(ctx, bson.M{"_id": legacy.ID}, bson.M{"$unset": bson.M{"redeemedBy": ""}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (r *PromoCodeRepository) FindAll(ctx context.Context) ([]*models.PromoCode, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var codes []*models.PromoCode
+	if err = cursor.All(ctx, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (r *PromoCodeRepository) FindByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	var promoCode models.PromoCode
+	err := r.collection.FindOne(ctx, bson.M{"code": code}).Decode(&promoCode)
+	if err != nil {
+		return nil, err
+	}
+	return &promoCode, nil
+}
+
+func (r *PromoCodeRepository) Create(ctx context.Context, promoCode *models.PromoCode) (*models.PromoCode, error) {
+	result, err := r.collection.InsertOne(ctx, promoCode)
+	if err != nil {
+		return nil, err
+	}
+	promoCode.ID = result.InsertedID.(primitive.ObjectID)
+	return promoCode, nil
+}
+
+// Update sets the fields of a promo code and removes the unset ones
+func (r *PromoCodeRepository) Update(ctx context.Context, id primitive.ObjectID, fields bson.M, unset ...string) (*models.PromoCode, error) {
+	fields["updatedAt"] = time.Now()
+	update := bson.M{"$set": fields}
+	if len(unset) > 0 {
+		removed := bson.M{}
+		for _, field := range unset {
+			removed[field] = ""
+		}
+		update["$unset"] = removed
+	}
+
+	result := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.PromoCode
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// Delete deletes a promo code with its redemption counts, so a new code with
+// the same name starts afresh
+func (r *PromoCodeRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	var deleted models.PromoCode
+	if err := r.collection.FindOneAndDelete(ctx, bson.M{"_id": id}).Decode(&deleted); err != nil {
+		return err
+	}
+
+	_, err := r.redemptions.DeleteMany(ctx, bson.M{"code": deleted.Code})
+	return err
+}
+
+// RedemptionsBy returns how often the redeemer redeemed the code
+func (r *PromoCodeRepository) RedemptionsBy(ctx context.Context, code, redeemer string) (int, error) {
+	var redemption models.PromoRedemption
+	err := r.redemptions.FindOne(ctx, bson.M{"code": code, "redeemer": redeemer}).Decode(&redemption)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return redemption.Count, nil
+}
+
+// Redeem takes one redemption of the code for the redeemer, a user ID, or ""
+// for guests who may only redeem codes without a per-customer limit.
+//
+// The redeemer's count is taken first; its unique index keeps concurrent
+// checkouts of one customer within MaxPerUser. The total is then taken only
+// if the limits are still the ones read, so concurrent checkouts or admin
+// changes make it fail rather than exceed them. Returns
+// ErrPromoCodeUnavailable when the code cannot be redeemed and
+// ErrPromoCodeLoginRequired for a guest redeeming a code limited per customer.
+func (r *PromoCodeRepository) Redeem(ctx context.Context, code, redeemer string, now time.Time) error {
+	promoCode, err := r.FindByCode(ctx, code)
+	if err == mongo.ErrNoDocuments {
+		return ErrPromoCodeUnavailable
+	}
+	if err != nil {
+		return err
+	}
+	if !promoCode.IsActive || (promoCode.ExpiresAt != nil && !now.Before(*promoCode.ExpiresAt)) {
+		return ErrPromoCodeUnavailable
+	}
+	if promoCode.MaxPerUser > 0 && redeemer == "" {
+		return ErrPromoCodeLoginRequired
+	}
+
+	if redeemer != "" {
+		if err := r.countRedemption(ctx, code, redeemer, promoCode.MaxPerUser, now); err != nil {
+			return err
+		}
+	}
+
+	filter := bson.M{
+		"_id":            promoCode.ID,
+		"isActive":       true,
+		"expiresAt":      promoCode.ExpiresAt,
+		"maxRedemptions": promoCode.MaxRedemptions,
+		"maxPerUser":     promoCode.MaxPerUser,
+	}
+	if promoCode.MaxRedemptions > 0 {
+		filter["redemptions"] = bson.M{"$lt": promoCode.MaxRedemptions}
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{
+		"$inc": bson.M{"redemptions": 1},
+		"$set": bson.M{"updatedAt": now},
+	})
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrPromoCodeUnavailable
+	}
+	if err != nil && redeemer != "" {
+		// Give back the redeemer's count taken above
+		if _, releaseErr := r.redemptions.UpdateOne(ctx,
+			bson.M{"code": code, "redeemer": redeemer, "count": bson.M{"$gt": 0}},
+			bson.M{"$inc": bson.M{"count": -1}},
+		); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
+	}
+	return err
+}
+
+// countRedemption counts one redemption for the redeemer, up to maxPerUser
+// when it is not 0
+func (r *PromoCodeRepository) countRedemption(ctx context.Context, code, redeemer string, maxPerUser int, now time.Time) error {
+	filter := bson.M{"code": code, "redeemer": redeemer}
+	if maxPerUser > 0 {
+		filter["count"] = bson.M{"$lt": maxPerUser}
+	}
+
+	_, err := r.redemptions.UpdateOne(ctx, filter,
+		bson.M{
+			"$inc": bson.M{"count": 1},
+			"$set": bson.M{"updatedAt": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// The redeemer's count exists and already reached the limit
+		return ErrPromoCodeUnavailable
+	}
+	return err
+}
+
+// Release gives back a redemption taken by Redeem, e.g. when checkout fails
+// or the order is cancelled
+func (r *PromoCodeRepository) Release(ctx context.Context, code, redeemer string) error {
+	if redeemer != "" {
+		_, err := r.redemptions.UpdateOne(ctx,
+			bson.M{"code": code, "redeemer": redeemer, "count": bson.M{"$gt": 0}},
+			bson.M{"$inc": bson.M{"count": -1}, "$set": bson.M{"updatedAt": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"code": code, "redemptions": bson.M{"$gt": 0}},
+		bson.M{
+			"$inc": bson.M{"redemptions": -1},
+			"$set": bson.M{"updatedAt": time.Now()},
+		},
+	)
+	return err
+}
+
 // Cart Repository
 type CartRepository struct {
 	collection *mongo.Collection
@@ -318,11 +716,13 @@ func (r *CartRepository) Create(ctx context.Context, cart *models.Cart) error {
 	return nil
 }
 
+// Update replaces the stored cart, so fields cleared in memory (omitempty)
+// are removed from the document too
 func (r *CartRepository) Update(ctx context.Context, cart *models.Cart) error {
-	_, err := r.collection.UpdateOne(
+	_, err := r.collection.ReplaceOne(
 		ctx,
 		bson.M{"_id": cart.ID},
-		bson.M{"$set": cart},
+		cart,
 	)
 	return err
 }
@@ -332,6 +732,19 @@ func (r *CartRepository) Delete(ctx context.Context, id primitive.ObjectID) erro
 	return err
 }
 
+// AssignToUser turns a guest cart into the user's cart
+func (r *CartRepository) AssignToUser(ctx context.Context, id primitive.ObjectID, userID string) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set":   bson.M{"userId": userID, "updatedAt": time.Now()},
+			"$unset": bson.M{"sessionId": ""},
+		},
+	)
+	return err
+}
+
 // Order Repository
 type OrderRepository struct {
 	collection *mongo.Collection
@@ -341,6 +754,21 @@ func NewOrderRepository(db *mongo.Database) *OrderRepository {
 	return &OrderRepository{collection: db.Collection("orders")}
 }
 
+// EnsureIndexes creates the indexes used by the customer and admin order listings
+func (r *OrderRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "sessionId", Value: 1}}},
+		{Keys: bson.D{{Key: "payment.txnId", Value: 1}}},
+		{Keys: bson.D{{Key: "payment.tenders.txnId", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "delivery.releaseAt", Value: 1}}},
+		{Keys: bson.D{{Key: "delivery.courier.id", Value: 1}, {Key: "status", Value: 1}}},
+	})
+	return err
+}
+
 func (r *OrderRepository) Create(ctx context.Context, order *models.Order) error {
 	result, err := r.collection.InsertOne(ctx, order)
 	if err != nil {
@@ -396,18 +824,217 @@ func (r *OrderRepository) Update(ctx context.Context, order *models.Order) error
 	return err
 }
 
-func (r *OrderRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) error {
+// UpdateStatus changes the status of an order at the expected version and
+// appends the tracking event. It returns ErrVersionConflict if the order was
+// changed in the meantime.
+func (r *OrderRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, expectedVersion int, status string, event models.TrackingEvent) (*models.Order, error) {
+	return r.UpdateStatusWith(ctx, id, expectedVersion, status, event, nil)
+}
+
+// UpdateStatusWith is UpdateStatus also setting the given fields, e.g. the
+// proof of delivery along with the completion
+func (r *OrderRepository) UpdateStatusWith(ctx context.Context, id primitive.ObjectID, expectedVersion int, status string, event models.TrackingEvent, fields bson.M) (*models.Order, error) {
+	set := bson.M{"status": status, "updatedAt": time.Now()}
+	for key, value := range fields {
+		set[key] = value
+	}
+
+	result := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "version": versionFilter(expectedVersion)},
+		bson.M{
+			"$set":  set,
+			"$inc":  bson.M{"version": 1},
+			"$push": bson.M{"delivery.tracking": event},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if result.Err() == mongo.ErrNoDocuments {
+		return nil, ErrVersionConflict
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.Order
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// versionFilter matches a document version. Orders created before versioning
+// have no version field, which counts as version 0.
+func versionFilter(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// TransitionStatus moves an order to a new status only if it is currently in
+// one of the given statuses, appending the tracking event. It returns
+// mongo.ErrNoDocuments when the order is missing or in another status.
+func (r *OrderRepository) TransitionStatus(ctx context.Context, id primitive.ObjectID, from []string, to string, event models.TrackingEvent) (*models.Order, error) {
+	result := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{
+			"$set":  bson.M{"status": to, "updatedAt": time.Now()},
+			"$inc":  bson.M{"version": 1},
+			"$push": bson.M{"delivery.tracking": event},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.Order
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// AssignCourier assigns a courier to an order at the expected version and
+// appends the tracking event. It returns ErrVersionConflict if the order was
+// changed in the meantime.
+func (r *OrderRepository) AssignCourier(ctx context.Context, id primitive.ObjectID, expectedVersion int, courier models.CourierRef, event models.TrackingEvent) (*models.Order, error) {
+	result := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "version": versionFilter(expectedVersion)},
+		bson.M{
+			"$set":  bson.M{"delivery.courier": courier, "updatedAt": time.Now()},
+			"$inc":  bson.M{"version": 1},
+			"$push": bson.M{"delivery.tracking": event},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if result.Err() == mongo.ErrNoDocuments {
+		return nil, ErrVersionConflict
+	}
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.Order
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// RecordCourierLocation stores the position of the courier of an order out
+// for delivery and appends the tracking event. It returns
+// mongo.ErrNoDocuments when the order is not out with that courier.
+func (r *OrderRepository) RecordCourierLocation(ctx context.Context, id primitive.ObjectID, courierID string, location models.CourierLocation, event models.TrackingEvent) (*models.Order, error) {
+	result := r.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "delivery.courier.id": courierID, "status": models.OrderStatusDelivering},
+		bson.M{
+			"$set":  bson.M{"delivery.courierLocation": location, "updatedAt": time.Now()},
+			"$push": bson.M{"delivery.tracking": event},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.Order
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// UpdateETA stores a recomputed ETA and its breakdown
+func (r *OrderRepository) UpdateETA(ctx context.Context, id primitive.ObjectID, eta time.Time, breakdown *models.ETABreakdown) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"delivery.eta": eta, "delivery.etaBreakdown": breakdown}},
+	)
+	return err
+}
+
+// FindDueScheduled returns scheduled orders whose release time has come,
+// the longest overdue first
+func (r *OrderRepository) FindDueScheduled(ctx context.Context, now time.Time, limit int64) ([]models.Order, error) {
+	opts := options.Find().SetSort(bson.M{"delivery.releaseAt": 1}).SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"status":             models.OrderStatusScheduled,
+		"delivery.releaseAt": bson.M{"$lte": now},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []models.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// FindByPaymentTxnID finds the order paid with a provider transaction
+func (r *OrderRepository) FindByPaymentTxnID(ctx context.Context, txnID string) (*models.Order, error) {
+	var order models.Order
+	filter := bson.M{"$or": bson.A{
+		bson.M{"payment.txnId": txnID},
+		bson.M{"payment.tenders.txnId": txnID},
+	}}
+	err := r.collection.FindOne(ctx, filter).Decode(&order)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// UpdatePaymentIfRevision replaces the payment only while its revision is
+// still the given one and reports whether it did
+func (r *OrderRepository) UpdatePaymentIfRevision(ctx context.Context, id primitive.ObjectID, revision int, payment models.Payment) (bool, error) {
+	payment.Revision = revision + 1
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "payment.revision": versionFilter(revision)},
+		bson.M{"$set": bson.M{"payment": payment, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// UpdatePayment replaces the payment information of an order
+func (r *OrderRepository) UpdatePayment(ctx context.Context, id primitive.ObjectID, payment models.Payment) error {
+	payment.Revision++
 	_, err := r.collection.UpdateOne(
 		ctx,
 		bson.M{"_id": id},
-		bson.M{"$set": bson.M{"status": status, "updatedAt": time.Now()}},
+		bson.M{"$set": bson.M{"payment": payment, "updatedAt": time.Now()}},
 	)
 	return err
 }
 
-func (r *OrderRepository) FindAll(ctx context.Context, filter bson.M) ([]models.Order, error) {
+// ReassignSession moves all orders of a guest session to a user
+func (r *OrderRepository) ReassignSession(ctx context.Context, sessionID, userID string) error {
+	_, err := r.collection.UpdateMany(
+		ctx,
+		bson.M{"sessionId": sessionID},
+		bson.M{
+			"$set":   bson.M{"userId": userID, "updatedAt": time.Now()},
+			"$unset": bson.M{"sessionId": ""},
+		},
+	)
+	return err
+}
+
+func (r *OrderRepository) FindAll(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]models.Order, error) {
 	var orders []models.Order
-	cursor, err := r.collection.Find(ctx, filter)
+	cursor, err := r.collection.Find(ctx, filter, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -419,6 +1046,47 @@ func (r *OrderRepository) FindAll(ctx context.Context, filter bson.M) ([]models.
 	return orders, nil
 }
 
+func (r *OrderRepository) Count(ctx context.Context, filter bson.M) (int64, error) {
+	return r.collection.CountDocuments(ctx, filter)
+}
+
+// Aggregate runs an aggregation pipeline and decodes all results
+func (r *OrderRepository) Aggregate(ctx context.Context, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	return cursor.All(ctx, results)
+}
+
+// CountByStatus returns the number of matching orders per status
+func (r *OrderRepository) CountByStatus(ctx context.Context, filter bson.M) (map[string]int64, error) {
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
 // MoodQuestion Repository
 type MoodQuestionRepository struct {
 	collection *mongo.Collection
@@ -508,3 +1176,341 @@ func (r *AISessionRepository) FindByUserID(ctx context.Context, userID string) (
 	}
 	return sessions, nil
 }
+
+// ReassignSession moves all quiz sessions of a guest session to a user
+func (r *AISessionRepository) ReassignSession(ctx context.Context, sessionID, userID string) error {
+	_, err := r.collection.UpdateMany(
+		ctx,
+		bson.M{"sessionId": sessionID},
+		bson.M{
+			"$set":   bson.M{"userId": userID},
+			"$unset": bson.M{"sessionId": ""},
+		},
+	)
+	return err
+}
+
+// RefreshToken Repository
+type RefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRefreshTokenRepository(db *mongo.Database) *RefreshTokenRepository {
+	return &RefreshTokenRepository{collection: db.Collection("refresh_tokens")}
+}
+
+// EnsureIndexes creates the token hash, family and expiry (TTL) indexes
+func (r *RefreshTokenRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	result, err := r.collection.InsertOne(ctx, token)
+	if err != nil {
+		return err
+	}
+	token.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.collection.FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkUsed atomically consumes a token. It returns false if the token was
+// already used or revoked, which means it is being replayed.
+func (r *RefreshTokenRepository) MarkUsed(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "usedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// RevokeFamily revokes every token of a family
+func (r *RefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := r.collection.UpdateMany(
+		ctx,
+		bson.M{"familyId": familyID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
+
+// RevokedToken Repository
+type RevokedTokenRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRevokedTokenRepository(db *mongo.Database) *RevokedTokenRepository {
+	return &RevokedTokenRepository{collection: db.Collection("revoked_tokens")}
+}
+
+// EnsureIndexes creates the expiry (TTL) index, entries are dropped once the tokens they cover expire
+func (r *RevokedTokenRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// RevokeTokenID revokes a single access token until it expires
+func (r *RevokedTokenRepository) RevokeTokenID(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	return r.revoke(ctx, "jti:"+tokenID, expiresAt)
+}
+
+// RevokeFamily revokes every access token issued for a refresh token family
+func (r *RevokedTokenRepository) RevokeFamily(ctx context.Context, familyID string, expiresAt time.Time) error {
+	return r.revoke(ctx, "family:"+familyID, expiresAt)
+}
+
+func (r *RevokedTokenRepository) revoke(ctx context.Context, key string, expiresAt time.Time) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$set":         bson.M{"expiresAt": expiresAt},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// IsRevoked reports whether the access token or its family has been revoked
+func (r *RevokedTokenRepository) IsRevoked(ctx context.Context, tokenID, familyID string) (bool, error) {
+	keys := []string{"jti:" + tokenID}
+	if familyID != "" {
+		keys = append(keys, "family:"+familyID)
+	}
+
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Idempotency Repository
+type IdempotencyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewIdempotencyRepository(db *mongo.Database) *IdempotencyRepository {
+	return &IdempotencyRepository{collection: db.Collection("idempotency_keys")}
+}
+
+// EnsureIndexes creates the expiry (TTL) index, keys are forgotten once they expire
+func (r *IdempotencyRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// beginAttempts bounds how often Begin retries when the key keeps being
+// released or taken over between its steps
+const beginAttempts = 3
+
+// Begin stores a new record for a key. When the key is already taken the
+// existing record is returned instead and nothing is stored, unless it is the
+// same request still processing past its lease: that record is taken over.
+func (r *IdempotencyRepository) Begin(ctx context.Context, record *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	for attempt := 1; ; attempt++ {
+		_, err := r.collection.InsertOne(ctx, record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		var existing models.IdempotencyRecord
+		err = r.collection.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&existing)
+		if err == mongo.ErrNoDocuments && attempt < beginAttempts {
+			// Released since the insert, the key is free again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		abandoned := existing.Status == models.IdempotencyProcessing &&
+			existing.RequestHash == record.RequestHash &&
+			existing.LockedUntil.Before(record.CreatedAt)
+		if !abandoned {
+			return &existing, nil
+		}
+
+		// Only one retry takes the record over. Records stored before leases
+		// were introduced have none.
+		var lockedUntil interface{} = existing.LockedUntil
+		if existing.LockedUntil.IsZero() {
+			lockedUntil = bson.M{"$exists": false}
+		}
+		result, err := r.collection.ReplaceOne(ctx, bson.M{
+			"_id":         record.ID,
+			"status":      models.IdempotencyProcessing,
+			"lockedUntil": lockedUntil,
+		}, record)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 1 {
+			return nil, nil
+		}
+		if attempt >= beginAttempts {
+			return &existing, nil
+		}
+	}
+}
+
+// Complete stores the response to replay for the key
+func (r *IdempotencyRepository) Complete(ctx context.Context, id string, status int, body []byte) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":         models.IdempotencyCompleted,
+		"responseStatus": status,
+		"responseBody":   body,
+	}})
+	return err
+}
+
+// Release forgets the key so the request can be retried with it
+func (r *IdempotencyRepository) Release(ctx context.Context, id string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// StoreSettings Repository
+type StoreSettingsRepository struct {
+	collection *mongo.Collection
+}
+
+func NewStoreSettingsRepository(db *mongo.Database) *StoreSettingsRepository {
+	return &StoreSettingsRepository{collection: db.Collection("store_settings")}
+}
+
+// Get returns the store settings, mongo.ErrNoDocuments if none were saved yet
+func (r *StoreSettingsRepository) Get(ctx context.Context) (*models.StoreSettings, error) {
+	var settings models.StoreSettings
+	err := r.collection.FindOne(ctx, bson.M{"_id": models.StoreSettingsID}).Decode(&settings)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveIfVersion replaces the stored settings only if they are still at the
+// given version, 0 when none were saved yet, and reports whether it did
+func (r *StoreSettingsRepository) SaveIfVersion(ctx context.Context, settings *models.StoreSettings, version int) (bool, error) {
+	settings.ID = models.StoreSettingsID
+	settings.Version = version + 1
+	_, err := r.collection.ReplaceOne(
+		ctx,
+		bson.M{"_id": models.StoreSettingsID, "version": versionFilter(version)},
+		settings,
+		options.Replace().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// The settings exist at another version, the upsert collided with them
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeliveryZone Repository
+type DeliveryZoneRepository struct {
+	collection *mongo.Collection
+}
+
+func NewDeliveryZoneRepository(db *mongo.Database) *DeliveryZoneRepository {
+	return &DeliveryZoneRepository{collection: db.Collection("delivery_zones")}
+}
+
+func (r *DeliveryZoneRepository) FindAll(ctx context.Context, filter bson.M) ([]*models.DeliveryZone, error) {
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var zones []*models.DeliveryZone
+	if err = cursor.All(ctx, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+func (r *DeliveryZoneRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.DeliveryZone, error) {
+	var zone models.DeliveryZone
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&zone)
+	if err != nil {
+		return nil, err
+	}
+	return &zone, nil
+}
+
+func (r *DeliveryZoneRepository) Create(ctx context.Context, zone *models.DeliveryZone) (*models.DeliveryZone, error) {
+	result, err := r.collection.InsertOne(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	zone.ID = result.InsertedID.(primitive.ObjectID)
+	return zone, nil
+}
+
+// Replace overwrites a zone, keeping its creation time
+func (r *DeliveryZoneRepository) Replace(ctx context.Context, id primitive.ObjectID, zone *models.DeliveryZone) (*models.DeliveryZone, error) {
+	zone.UpdatedAt = time.Now()
+	fields := bson.M{
+		"name":             zone.Name,
+		"postcodes":        zone.Postcodes,
+		"area":             zone.Area,
+		"feeUSD":           zone.FeeUSD,
+		"minOrderUSD":      zone.MinOrderUSD,
+		"etaOffsetMinutes": zone.ETAOffsetMinutes,
+		"isActive":         zone.IsActive,
+		"updatedAt":        zone.UpdatedAt,
+	}
+
+	result := r.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": fields}, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var updated models.DeliveryZone
+	if err := result.Decode(&updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (r *DeliveryZoneRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"github.com/fastspot/backend/internal/testutil"
+)
+
+func TestIdempotencyBegin(t *testing.T) {
+	repo := NewIdempotencyRepository(testutil.Database(t))
+	ctx := context.Background()
+	now := time.Now()
+	record := func(hash string, at time.Time) *models.IdempotencyRecord {
+		return &models.IdempotencyRecord{
+			ID:          "customer-1|POST /api/v1/orders|key-1",
+			RequestHash: hash,
+			Status:      models.IdempotencyProcessing,
+			CreatedAt:   at,
+			LockedUntil: at.Add(time.Minute),
+			ExpiresAt:   at.Add(24 * time.Hour),
+		}
+	}
+
+	if existing, err := repo.Begin(ctx, record("hash-1", now)); err != nil || existing != nil {
+		t.Fatalf("first Begin = %+v, %v, want the key taken", existing, err)
+	}
+
+	// Within the lease the request is still in progress
+	existing, err := repo.Begin(ctx, record("hash-1", now.Add(30*time.Second)))
+	if err != nil || existing == nil || existing.Status != models.IdempotencyProcessing {
+		t.Fatalf("Begin within the lease = %+v, %v, want the processing record", existing, err)
+	}
+
+	// Another request never takes the key over, even past the lease
+	if existing, err := repo.Begin(ctx, record("hash-2", now.Add(2*time.Minute))); err != nil || existing == nil || existing.RequestHash != "hash-1" {
+		t.Fatalf("Begin with another body = %+v, %v, want the first record", existing, err)
+	}
+
+	// A retry past the lease takes the abandoned record over, once
+	if existing, err := repo.Begin(ctx, record("hash-1", now.Add(2*time.Minute))); err != nil || existing != nil {
+		t.Fatalf("Begin past the lease = %+v, %v, want the key taken over", existing, err)
+	}
+	if existing, err := repo.Begin(ctx, record("hash-1", now.Add(2*time.Minute))); err != nil || existing == nil {
+		t.Fatalf("second Begin past the lease = %+v, %v, want the new lease kept", existing, err)
+	}
+}
+
+func TestUserNormalizeEmails(t *testing.T) {
+	repo := NewUserRepository(testutil.Database(t))
+	ctx := context.Background()
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("EnsureIndexes: %v", err)
+	}
+	for _, email := range []string{"Ann@Example.com", "bob@example.com", "Bob@Example.com", "carol@example.com"} {
+		if _, err := repo.Create(ctx, &models.User{Email: email, CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("create %s: %v", email, err)
+		}
+	}
+
+	normalize := func(email string) string { return strings.ToLower(strings.TrimSpace(email)) }
+	kept, err := repo.NormalizeEmails(ctx, normalize)
+	if err != nil || kept != 1 {
+		t.Fatalf("NormalizeEmails = %d, %v, want 1 account kept", kept, err)
+	}
+
+	// The colliding account keeps the email it was registered with
+	for _, email := range []string{"ann@example.com", "bob@example.com", "Bob@Example.com", "carol@example.com"} {
+		if _, err := repo.FindByEmail(ctx, email); err != nil {
+			t.Errorf("FindByEmail(%q): %v", email, err)
+		}
+	}
+}
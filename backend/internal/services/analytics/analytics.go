@@ -0,0 +1,391 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Aggregator runs an aggregation pipeline over the orders collection
+type Aggregator interface {
+	Aggregate(ctx context.Context, pipeline mongo.Pipeline, results interface{}) error
+}
+
+// Query selects the period a report covers
+type Query struct {
+	From    time.Time
+	To      time.Time
+	GroupBy string // day, week, month
+}
+
+// Report holds the dashboard analytics for a period
+type Report struct {
+	From                  time.Time       `json:"from"`
+	To                    time.Time       `json:"to"`
+	GroupBy               string          `json:"groupBy"`
+	Summary               Summary         `json:"summary"`
+	Series                []SeriesPoint   `json:"series"`
+	TopProductsByQuantity []ProductStat   `json:"topProductsByQuantity"`
+	TopProductsByRevenue  []ProductStat   `json:"topProductsByRevenue"`
+	CategoryRevenue       []CategoryStat  `json:"categoryRevenue"`
+	Fulfillment           FulfillmentStat `json:"fulfillment"`
+	Promotions            []PromotionStat `json:"promotions"`
+	GeneratedAt           time.Time       `json:"generatedAt"`
+}
+
+// Summary holds the headline numbers of a period
+type Summary struct {
+	TotalOrders      int64   `bson:"totalOrders" json:"totalOrders"`
+	RevenueOrders    int64   `bson:"revenueOrders" json:"revenueOrders"`
+	CancelledOrders  int64   `bson:"cancelledOrders" json:"cancelledOrders"`
+	RevenueUSD       float64 `bson:"revenue" json:"revenueUSD"` // tips excluded
+	DiscountUSD      float64 `bson:"discount" json:"discountUSD"`
+	TipsUSD          float64 `bson:"tips" json:"tipsUSD"`
+	OnlineRevenueUSD float64 `bson:"onlineRevenue" json:"onlineRevenueUSD"` // card, wallet and gift card tenders, tips included
+	CashCollectedUSD float64 `bson:"cashCollected" json:"cashCollectedUSD"` // tips included
+	CashPendingUSD   float64 `bson:"cashPending" json:"cashPendingUSD"`     // cash not collected yet on open orders
+	AverageOrderUSD  float64 `bson:"-" json:"averageOrderUSD"`
+	CancellationRate float64 `bson:"-" json:"cancellationRate"`
+}
+
+// SeriesPoint holds revenue and order count of one day, week or month
+type SeriesPoint struct {
+	Period           time.Time `bson:"_id" json:"period"`
+	RevenueUSD       float64   `bson:"revenue" json:"revenueUSD"`
+	TipsUSD          float64   `bson:"tips" json:"tipsUSD"`
+	OnlineRevenueUSD float64   `bson:"onlineRevenue" json:"onlineRevenueUSD"`
+	CashCollectedUSD float64   `bson:"cashCollected" json:"cashCollectedUSD"`
+	Orders           int64     `bson:"orders" json:"orders"`
+}
+
+// ProductStat holds the sales of one product
+type ProductStat struct {
+	ProductID  primitive.ObjectID `bson:"_id" json:"productId"`
+	Name       string             `bson:"name" json:"name"`
+	Quantity   int64              `bson:"qty" json:"quantity"`
+	RevenueUSD float64            `bson:"revenue" json:"revenueUSD"`
+}
+
+// CategoryStat holds the revenue of one category
+type CategoryStat struct {
+	CategoryID primitive.ObjectID `bson:"_id" json:"categoryId"`
+	Name       string             `bson:"name" json:"name"`
+	RevenueUSD float64            `bson:"revenue" json:"revenueUSD"`
+	Share      float64            `bson:"-" json:"share"`
+}
+
+// FulfillmentStat holds the pickup vs delivery split
+type FulfillmentStat struct {
+	Pickup        int64   `json:"pickup"`
+	Delivery      int64   `json:"delivery"`
+	DeliveryRatio float64 `json:"deliveryRatio"`
+}
+
+// PromotionStat holds the usage of one promotion
+type PromotionStat struct {
+	PromotionID primitive.ObjectID `bson:"_id" json:"promotionId"`
+	Title       string             `bson:"title" json:"title"`
+	Orders      int64              `bson:"orders" json:"orders"`
+	DiscountUSD float64            `bson:"discount" json:"discountUSD"`
+}
+
+const topProductsLimit = 10
+
+// Service builds analytics reports and caches them briefly so the dashboard
+// does not run the aggregations on every refresh
+type Service struct {
+	orders Aggregator
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	report    *Report
+	expiresAt time.Time
+}
+
+// NewService creates a new analytics service
+func NewService(orders Aggregator, ttl time.Duration) *Service {
+	return &Service{
+		orders: orders,
+		ttl:    ttl,
+		cache:  make(map[string]cacheEntry),
+	}
+}
+
+// IsValidGroupBy reports whether groupBy is a supported series granularity
+func IsValidGroupBy(groupBy string) bool {
+	return groupBy == "day" || groupBy == "week" || groupBy == "month"
+}
+
+// Report returns the analytics report for the query, from cache when fresh
+func (s *Service) Report(ctx context.Context, q Query) (*Report, error) {
+	key := fmt.Sprintf("%d|%d|%s", q.From.Unix(), q.To.Unix(), q.GroupBy)
+
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.report, nil
+	}
+
+	report, err := s.build(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	for k, e := range s.cache {
+		if time.Now().After(e.expiresAt) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = cacheEntry{report: report, expiresAt: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+
+	return report, nil
+}
+
+// isRevenue matches orders that count towards revenue: not cancelled and not
+// failed or reversed on the payment side
+var isRevenue = bson.M{"$and": bson.A{
+	bson.M{"$ne": bson.A{"$status", models.OrderStatusCancelled}},
+	bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$payment.status", bson.A{
+		models.PaymentStatusFailed,
+		models.PaymentStatusVoided,
+		models.PaymentStatusRefunded,
+	}}}}},
+}}
+
+// tips is the tip of an order, orders placed before tipping have none
+var tips = bson.M{"$ifNull": bson.A{"$tipUSD", 0}}
+
+// netRevenue is the order total without the tip
+var netRevenue = bson.M{"$subtract": bson.A{"$totalUSD", tips}}
+
+// tenderTotal sums the tenders of an order matching cond, which refers to the
+// tender as $$t. Orders placed before split tender count as one tender of the
+// whole total.
+func tenderTotal(cond bson.M) bson.M {
+	tenders := bson.M{"$ifNull": bson.A{"$payment.tenders", bson.A{bson.M{
+		"method":    "$payment.method",
+		"amountUSD": "$totalUSD",
+		"status":    "$payment.status",
+	}}}}
+	return bson.M{"$sum": bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{"input": tenders, "as": "t", "cond": cond}},
+		"as":    "t",
+		"in":    "$$t.amountUSD",
+	}}}
+}
+
+// isCashTender matches tenders paid in cash
+var isCashTender = bson.M{"$eq": bson.A{"$$t.method", models.PaymentMethodCash}}
+
+// cashCollected is the cash staff collected for an order
+var cashCollected = tenderTotal(bson.M{"$and": bson.A{
+	isCashTender,
+	bson.M{"$eq": bson.A{"$$t.status", models.PaymentStatusCompleted}},
+}})
+
+// cashPending is the cash still to be collected for an order
+var cashPending = tenderTotal(bson.M{"$and": bson.A{
+	isCashTender,
+	bson.M{"$eq": bson.A{"$$t.status", models.PaymentStatusPending}},
+}})
+
+// onlineRevenue is the part of a revenue order paid online
+var onlineRevenue = bson.M{"$cond": bson.A{isRevenue, tenderTotal(bson.M{"$not": bson.A{isCashTender}}), 0}}
+
+func (s *Service) build(ctx context.Context, q Query) (*Report, error) {
+	revenueOnly := bson.D{{Key: "$match", Value: bson.M{"$expr": isRevenue}}}
+
+	productStats := func(sortField string) bson.A {
+		return bson.A{
+			revenueOnly,
+			bson.M{"$unwind": "$items"},
+			bson.M{"$group": bson.M{
+				"_id":     "$items.productId",
+				"name":    bson.M{"$first": "$items.name"},
+				"qty":     bson.M{"$sum": "$items.qty"},
+				"revenue": bson.M{"$sum": "$items.totalUSD"},
+			}},
+			bson.M{"$sort": bson.D{{Key: sortField, Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": topProductsLimit},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": q.From, "$lt": q.To}}}},
+		{{Key: "$facet", Value: bson.M{
+			"summary": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":             nil,
+					"totalOrders":     bson.M{"$sum": 1},
+					"cancelledOrders": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", models.OrderStatusCancelled}}, 1, 0}}},
+					"revenueOrders":   bson.M{"$sum": bson.M{"$cond": bson.A{isRevenue, 1, 0}}},
+					"revenue":         bson.M{"$sum": bson.M{"$cond": bson.A{isRevenue, netRevenue, 0}}},
+					"discount":        bson.M{"$sum": bson.M{"$cond": bson.A{isRevenue, bson.M{"$ifNull": bson.A{"$discountUSD", 0}}, 0}}},
+					"tips":            bson.M{"$sum": bson.M{"$cond": bson.A{isRevenue, tips, 0}}},
+					"onlineRevenue":   bson.M{"$sum": onlineRevenue},
+					"cashCollected":   bson.M{"$sum": cashCollected},
+					"cashPending": bson.M{"$sum": bson.M{"$cond": bson.A{
+						bson.M{"$ne": bson.A{"$status", models.OrderStatusCancelled}}, cashPending, 0,
+					}}},
+				}},
+			},
+			"series": bson.A{
+				revenueOnly,
+				bson.M{"$group": bson.M{
+					"_id":           bson.M{"$dateTrunc": bson.M{"date": "$createdAt", "unit": q.GroupBy, "startOfWeek": "monday"}},
+					"revenue":       bson.M{"$sum": netRevenue},
+					"tips":          bson.M{"$sum": tips},
+					"onlineRevenue": bson.M{"$sum": onlineRevenue},
+					"cashCollected": bson.M{"$sum": cashCollected},
+					"orders":        bson.M{"$sum": 1},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"topByQuantity": productStats("qty"),
+			"topByRevenue":  productStats("revenue"),
+			"categories": bson.A{
+				revenueOnly,
+				bson.M{"$unwind": "$items"},
+				bson.M{"$lookup": bson.M{"from": "products", "localField": "items.productId", "foreignField": "_id", "as": "product"}},
+				bson.M{"$unwind": "$product"},
+				bson.M{"$group": bson.M{"_id": "$product.categoryId", "revenue": bson.M{"$sum": "$items.totalUSD"}}},
+				bson.M{"$lookup": bson.M{"from": "categories", "localField": "_id", "foreignField": "_id", "as": "category"}},
+				bson.M{"$project": bson.M{"revenue": 1, "name": bson.M{"$ifNull": bson.A{bson.M{"$first": "$category.name"}, "Unknown"}}}},
+				bson.M{"$sort": bson.M{"revenue": -1}},
+			},
+			"fulfillment": bson.A{
+				bson.M{"$match": bson.M{"status": bson.M{"$ne": models.OrderStatusCancelled}}},
+				bson.M{"$group": bson.M{"_id": "$delivery.type", "count": bson.M{"$sum": 1}}},
+			},
+			"promotions": bson.A{
+				revenueOnly,
+				bson.M{"$match": bson.M{"appliedPromotion": bson.M{"$ne": nil}}},
+				bson.M{"$group": bson.M{
+					"_id":      "$appliedPromotion.promotionId",
+					"title":    bson.M{"$last": "$appliedPromotion.title"},
+					"orders":   bson.M{"$sum": 1},
+					"discount": bson.M{"$sum": "$appliedPromotion.discountUSD"},
+				}},
+				bson.M{"$sort": bson.D{{Key: "discount", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+		}}},
+	}
+
+	var results []struct {
+		Summary       []Summary      `bson:"summary"`
+		Series        []SeriesPoint  `bson:"series"`
+		TopByQuantity []ProductStat  `bson:"topByQuantity"`
+		TopByRevenue  []ProductStat  `bson:"topByRevenue"`
+		Categories    []CategoryStat `bson:"categories"`
+		Fulfillment   []struct {
+			Type  string `bson:"_id"`
+			Count int64  `bson:"count"`
+		} `bson:"fulfillment"`
+		Promotions []PromotionStat `bson:"promotions"`
+	}
+	if err := s.orders.Aggregate(ctx, pipeline, &results); err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		From:                  q.From,
+		To:                    q.To,
+		GroupBy:               q.GroupBy,
+		Series:                []SeriesPoint{},
+		TopProductsByQuantity: []ProductStat{},
+		TopProductsByRevenue:  []ProductStat{},
+		CategoryRevenue:       []CategoryStat{},
+		Promotions:            []PromotionStat{},
+		GeneratedAt:           time.Now(),
+	}
+	if len(results) == 0 {
+		return report, nil
+	}
+	result := results[0]
+
+	if len(result.Summary) > 0 {
+		report.Summary = result.Summary[0]
+	}
+	summary := &report.Summary
+	summary.RevenueUSD = roundMoney(summary.RevenueUSD)
+	summary.DiscountUSD = roundMoney(summary.DiscountUSD)
+	summary.TipsUSD = roundMoney(summary.TipsUSD)
+	summary.OnlineRevenueUSD = roundMoney(summary.OnlineRevenueUSD)
+	summary.CashCollectedUSD = roundMoney(summary.CashCollectedUSD)
+	summary.CashPendingUSD = roundMoney(summary.CashPendingUSD)
+	if summary.RevenueOrders > 0 {
+		summary.AverageOrderUSD = roundMoney(summary.RevenueUSD / float64(summary.RevenueOrders))
+	}
+	if summary.TotalOrders > 0 {
+		summary.CancellationRate = roundRatio(float64(summary.CancelledOrders) / float64(summary.TotalOrders))
+	}
+
+	for _, point := range result.Series {
+		point.RevenueUSD = roundMoney(point.RevenueUSD)
+		point.TipsUSD = roundMoney(point.TipsUSD)
+		point.OnlineRevenueUSD = roundMoney(point.OnlineRevenueUSD)
+		point.CashCollectedUSD = roundMoney(point.CashCollectedUSD)
+		report.Series = append(report.Series, point)
+	}
+	for _, stat := range result.TopByQuantity {
+		stat.RevenueUSD = roundMoney(stat.RevenueUSD)
+		report.TopProductsByQuantity = append(report.TopProductsByQuantity, stat)
+	}
+	for _, stat := range result.TopByRevenue {
+		stat.RevenueUSD = roundMoney(stat.RevenueUSD)
+		report.TopProductsByRevenue = append(report.TopProductsByRevenue, stat)
+	}
+
+	var categoryTotal float64
+	for _, stat := range result.Categories {
+		categoryTotal += stat.RevenueUSD
+	}
+	for _, stat := range result.Categories {
+		if categoryTotal > 0 {
+			stat.Share = roundRatio(stat.RevenueUSD / categoryTotal)
+		}
+		stat.RevenueUSD = roundMoney(stat.RevenueUSD)
+		report.CategoryRevenue = append(report.CategoryRevenue, stat)
+	}
+
+	for _, row := range result.Fulfillment {
+		switch row.Type {
+		case "pickup":
+			report.Fulfillment.Pickup = row.Count
+		case "delivery":
+			report.Fulfillment.Delivery = row.Count
+		}
+	}
+	if fulfilled := report.Fulfillment.Pickup + report.Fulfillment.Delivery; fulfilled > 0 {
+		report.Fulfillment.DeliveryRatio = roundRatio(float64(report.Fulfillment.Delivery) / float64(fulfilled))
+	}
+
+	for _, stat := range result.Promotions {
+		stat.DiscountUSD = roundMoney(stat.DiscountUSD)
+		report.Promotions = append(report.Promotions, stat)
+	}
+
+	return report, nil
+}
+
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func roundRatio(ratio float64) float64 {
+	return math.Round(ratio*10000) / 10000
+}
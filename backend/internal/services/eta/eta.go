@@ -0,0 +1,129 @@
+package eta
+
+import (
+	"context"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Config holds the kitchen and delivery timings ETAs are computed from
+type Config struct {
+	DefaultPrepMinutes int // products without their own prep time
+	KitchenCapacity    int // orders the kitchen works on at the same time
+	MinutesPerOrder    int // kitchen time each order in the queue takes
+	DeliveryMinutes    int // base delivery time, plus the offset of the zone
+}
+
+// OrderCounter counts orders matching a filter
+type OrderCounter interface {
+	Count(ctx context.Context, filter bson.M) (int64, error)
+}
+
+// Estimator computes order ETAs from the kitchen load, the prep time of the
+// items and the delivery time to the customer's zone
+type Estimator struct {
+	orders OrderCounter
+	config Config
+}
+
+// NewEstimator creates an estimator
+func NewEstimator(orders OrderCounter, config Config) *Estimator {
+	if config.KitchenCapacity < 1 {
+		config.KitchenCapacity = 1
+	}
+	return &Estimator{orders: orders, config: config}
+}
+
+// kitchenStatuses are the statuses of orders the kitchen still has to finish
+var kitchenStatuses = []string{models.OrderStatusNew, models.OrderStatusConfirmed, models.OrderStatusPreparing}
+
+// PrepMinutes returns how long the kitchen needs for the items: the longest
+// prep time among them, as items are prepared in parallel
+func (e *Estimator) PrepMinutes(items []models.OrderItem, products map[primitive.ObjectID]*models.Product) int {
+	prep := 0
+	for _, item := range items {
+		minutes := e.config.DefaultPrepMinutes
+		if product, ok := products[item.ProductID]; ok && product.PrepMinutes > 0 {
+			minutes = product.PrepMinutes
+		}
+		if minutes > prep {
+			prep = minutes
+		}
+	}
+	return prep
+}
+
+// Estimate sets the ETA of the order and its breakdown for the order's
+// current status. The prep time is taken from the previous breakdown; new
+// orders get it from PrepMinutes first. Finished orders keep their ETA.
+func (e *Estimator) Estimate(ctx context.Context, order *models.Order, now time.Time) error {
+	breakdown := models.ETABreakdown{
+		PrepMinutes: e.config.DefaultPrepMinutes,
+		ComputedAt:  now,
+	}
+	if order.Delivery.ETABreakdown != nil {
+		breakdown.PrepMinutes = order.Delivery.ETABreakdown.PrepMinutes
+	}
+	if order.Delivery.Type == "delivery" {
+		breakdown.DeliveryMinutes = e.config.DeliveryMinutes
+		if zone := order.Delivery.Zone; zone != nil {
+			breakdown.DeliveryMinutes += zone.ETAOffsetMinutes
+			breakdown.Zone = zone.Name
+		}
+	}
+
+	switch order.Status {
+	case models.OrderStatusNew, models.OrderStatusConfirmed:
+		ahead, err := e.ordersAhead(ctx, order, now)
+		if err != nil {
+			return err
+		}
+		breakdown.OrdersAhead = ahead
+		// A free slot starts the order right away, otherwise it waits for
+		// the orders ahead to clear in rounds of the kitchen capacity
+		breakdown.QueueMinutes = ahead / e.config.KitchenCapacity * e.config.MinutesPerOrder
+	case models.OrderStatusScheduled, models.OrderStatusPreparing:
+		// Scheduled orders are released to an empty slot one lead time ahead
+	case models.OrderStatusReady, models.OrderStatusDelivering:
+		breakdown.PrepMinutes = 0
+	default:
+		return nil
+	}
+
+	breakdown.TotalMinutes = breakdown.QueueMinutes + breakdown.PrepMinutes + breakdown.DeliveryMinutes
+	eta := now.Add(time.Duration(breakdown.TotalMinutes) * time.Minute)
+	// A scheduled order is not handed over before the time asked for
+	if scheduledFor := order.Delivery.ScheduledFor; scheduledFor != nil && eta.Before(*scheduledFor) {
+		eta = *scheduledFor
+	}
+
+	order.Delivery.ETA = eta
+	order.Delivery.ETABreakdown = &breakdown
+	return nil
+}
+
+// ordersAhead counts the unfinished kitchen orders queued before the order.
+// Orders join the queue when placed, scheduled orders when released to the
+// kitchen, however long before that they were placed.
+func (e *Estimator) ordersAhead(ctx context.Context, order *models.Order, now time.Time) (int, error) {
+	queuedAt := order.CreatedAt
+	if releaseAt := order.Delivery.ReleaseAt; releaseAt != nil {
+		queuedAt = *releaseAt
+	}
+	if queuedAt.IsZero() {
+		queuedAt = now
+	}
+
+	count, err := e.orders.Count(ctx, bson.M{
+		"status": bson.M{"$in": kitchenStatuses},
+		"$or": bson.A{
+			bson.M{"delivery.releaseAt": bson.M{"$lt": queuedAt}},
+			bson.M{"delivery.releaseAt": nil, "createdAt": bson.M{"$lt": queuedAt}},
+		},
+		"_id": bson.M{"$ne": order.ID},
+	})
+	return int(count), err
+}
@@ -0,0 +1,92 @@
+package orderflow
+
+import (
+	"fmt"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+// transitions lists the statuses each status may move to.
+// new → confirmed → preparing → ready → delivering → completed, with
+// cancellation possible until the order is completed. Scheduled orders
+// start as scheduled and move to new when they are released to the kitchen.
+var transitions = map[string][]string{
+	models.OrderStatusScheduled:  {models.OrderStatusNew, models.OrderStatusCancelled},
+	models.OrderStatusNew:        {models.OrderStatusConfirmed, models.OrderStatusCancelled},
+	models.OrderStatusConfirmed:  {models.OrderStatusPreparing, models.OrderStatusCancelled},
+	models.OrderStatusPreparing:  {models.OrderStatusReady, models.OrderStatusCancelled},
+	models.OrderStatusReady:      {models.OrderStatusDelivering, models.OrderStatusCompleted, models.OrderStatusCancelled},
+	models.OrderStatusDelivering: {models.OrderStatusCompleted, models.OrderStatusCancelled},
+	models.OrderStatusCompleted:  {},
+	models.OrderStatusCancelled:  {},
+}
+
+// TransitionError describes a rejected status change
+type TransitionError struct {
+	From   string
+	To     string
+	Reason string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move order from %q to %q: %s", e.From, e.To, e.Reason)
+}
+
+// IsKnownStatus reports whether status is part of the order lifecycle
+func IsKnownStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// NextStatuses returns the statuses an order can move to from its current status
+func NextStatuses(order *models.Order) []string {
+	var next []string
+	for _, status := range transitions[order.Status] {
+		if allowedForDeliveryType(order.Delivery.Type, order.Status, status) {
+			next = append(next, status)
+		}
+	}
+	return next
+}
+
+// CanTransition checks whether the order may move to the given status
+func CanTransition(order *models.Order, to string) error {
+	if !IsKnownStatus(to) {
+		return &TransitionError{From: order.Status, To: to, Reason: "unknown status"}
+	}
+	if !IsKnownStatus(order.Status) {
+		return &TransitionError{From: order.Status, To: to, Reason: "order is in an unknown status"}
+	}
+
+	allowed := false
+	for _, status := range transitions[order.Status] {
+		if status == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		if len(transitions[order.Status]) == 0 {
+			return &TransitionError{From: order.Status, To: to, Reason: "order is already " + order.Status}
+		}
+		return &TransitionError{From: order.Status, To: to, Reason: "transition not allowed"}
+	}
+
+	if !allowedForDeliveryType(order.Delivery.Type, order.Status, to) {
+		return &TransitionError{From: order.Status, To: to, Reason: order.Delivery.Type + " orders cannot take this step"}
+	}
+	return nil
+}
+
+// allowedForDeliveryType applies the delivery type specific rules: pickup
+// orders never go out for delivery, delivery orders are completed only by
+// the courier after delivering.
+func allowedForDeliveryType(deliveryType, from, to string) bool {
+	switch deliveryType {
+	case "pickup":
+		return to != models.OrderStatusDelivering
+	case "delivery":
+		return !(from == models.OrderStatusReady && to == models.OrderStatusCompleted)
+	}
+	return true
+}
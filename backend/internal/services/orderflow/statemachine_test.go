@@ -0,0 +1,75 @@
+package orderflow
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+func order(deliveryType, status string) *models.Order {
+	return &models.Order{Status: status, Delivery: models.Delivery{Type: deliveryType}}
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		name         string
+		deliveryType string
+		from, to     string
+		wantReason   string // empty when the transition is allowed
+	}{
+		{"scheduled released", "pickup", models.OrderStatusScheduled, models.OrderStatusNew, ""},
+		{"confirm", "delivery", models.OrderStatusNew, models.OrderStatusConfirmed, ""},
+		{"start preparing", "delivery", models.OrderStatusConfirmed, models.OrderStatusPreparing, ""},
+		{"delivery goes out", "delivery", models.OrderStatusReady, models.OrderStatusDelivering, ""},
+		{"courier completes", "delivery", models.OrderStatusDelivering, models.OrderStatusCompleted, ""},
+		{"pickup collected", "pickup", models.OrderStatusReady, models.OrderStatusCompleted, ""},
+		{"cancel while preparing", "pickup", models.OrderStatusPreparing, models.OrderStatusCancelled, ""},
+		{"pickup never goes out", "pickup", models.OrderStatusReady, models.OrderStatusDelivering, "pickup orders cannot take this step"},
+		{"delivery skips the courier", "delivery", models.OrderStatusReady, models.OrderStatusCompleted, "delivery orders cannot take this step"},
+		{"skip a step", "pickup", models.OrderStatusNew, models.OrderStatusReady, "transition not allowed"},
+		{"move backwards", "pickup", models.OrderStatusReady, models.OrderStatusPreparing, "transition not allowed"},
+		{"completed reopened", "pickup", models.OrderStatusCompleted, models.OrderStatusPreparing, "order is already completed"},
+		{"cancelled twice", "delivery", models.OrderStatusCancelled, models.OrderStatusCancelled, "order is already cancelled"},
+		{"unknown target", "pickup", models.OrderStatusNew, "shipped", "unknown status"},
+		{"unknown current", "pickup", "lost", models.OrderStatusNew, "order is in an unknown status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CanTransition(order(tt.deliveryType, tt.from), tt.to)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("CanTransition = %v, want allowed", err)
+				}
+				return
+			}
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) {
+				t.Fatalf("CanTransition = %v, want a TransitionError", err)
+			}
+			if transitionErr.From != tt.from || transitionErr.To != tt.to || transitionErr.Reason != tt.wantReason {
+				t.Errorf("error = %+v, want %s → %s: %s", transitionErr, tt.from, tt.to, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestNextStatuses(t *testing.T) {
+	tests := []struct {
+		deliveryType, status string
+		want                 []string
+	}{
+		{"pickup", models.OrderStatusReady, []string{models.OrderStatusCompleted, models.OrderStatusCancelled}},
+		{"delivery", models.OrderStatusReady, []string{models.OrderStatusDelivering, models.OrderStatusCancelled}},
+		{"delivery", models.OrderStatusDelivering, []string{models.OrderStatusCompleted, models.OrderStatusCancelled}},
+		{"pickup", models.OrderStatusCompleted, nil},
+		{"pickup", "lost", nil},
+	}
+	for _, tt := range tests {
+		if got := NextStatuses(order(tt.deliveryType, tt.status)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NextStatuses(%s %s) = %v, want %v", tt.deliveryType, tt.status, got, tt.want)
+		}
+	}
+}
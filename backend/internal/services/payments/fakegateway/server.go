@@ -0,0 +1,209 @@
+// Package fakegateway is a local stand-in for a payment gateway. It serves
+// the HTTP API payments.GatewayProvider talks to, backed by the stub
+// provider, and delivers signed webhooks for every intent change so the
+// complete asynchronous payment flow can run without a real provider.
+package fakegateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fastspot/backend/internal/services/payments"
+)
+
+// Config configures the fake gateway
+type Config struct {
+	APIKey        string        // required as Bearer token on API calls, empty disables the check
+	WebhookURL    string        // receives the signed events, empty disables webhooks
+	WebhookSecret string        // signs the events
+	SettleDelay   time.Duration // how long processing intents take to succeed
+}
+
+// Server is the fake gateway
+type Server struct {
+	config   Config
+	provider *payments.StubProvider
+	client   *http.Client
+	eventSeq atomic.Int64
+}
+
+// NewServer creates a fake gateway
+func NewServer(config Config) *Server {
+	return &Server{
+		config:   config,
+		provider: payments.NewStubProvider(),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Handler returns the HTTP API of the gateway
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/intents", s.createIntent)
+	mux.HandleFunc("GET /v1/intents/{id}", s.getIntent)
+	mux.HandleFunc("POST /v1/intents/{id}/confirm", s.confirmIntent)
+	mux.HandleFunc("POST /v1/intents/{id}/capture", s.intentAction(s.provider.CaptureIntent))
+	mux.HandleFunc("POST /v1/intents/{id}/void", s.intentAction(s.provider.VoidIntent))
+	mux.HandleFunc("POST /v1/intents/{id}/refund", s.refundIntent)
+	return s.authenticate(mux)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.config.APIKey {
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) createIntent(w http.ResponseWriter, r *http.Request) {
+	var params payments.CreateIntentParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	intent, err := s.provider.CreateIntent(r.Context(), params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.respond(w, http.StatusCreated, intent)
+}
+
+func (s *Server) getIntent(w http.ResponseWriter, r *http.Request) {
+	intent, err := s.provider.GetIntent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, intent)
+}
+
+// confirmIntent confirms the intent and, when the outcome is processing,
+// settles it after the configured delay as payments.AmountSettles decides
+func (s *Server) confirmIntent(w http.ResponseWriter, r *http.Request) {
+	intent, err := s.provider.ConfirmIntent(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+
+	if intent.Status == payments.IntentProcessing {
+		id, succeeds := intent.ID, payments.AmountSettles(*intent)
+		time.AfterFunc(s.config.SettleDelay, func() {
+			settled, err := s.provider.SettleIntent(context.Background(), id, succeeds)
+			if err != nil {
+				// Voided in the meantime
+				return
+			}
+			s.notify(settled)
+		})
+	}
+	s.respond(w, http.StatusOK, intent)
+}
+
+func (s *Server) refundIntent(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Amount float64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	intent, err := s.provider.RefundIntent(r.Context(), r.PathValue("id"), body.Amount)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	s.respond(w, http.StatusOK, intent)
+}
+
+func (s *Server) intentAction(action func(context.Context, string) (*payments.Intent, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		intent, err := action(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		s.respond(w, http.StatusOK, intent)
+	}
+}
+
+// respond writes the intent and sends the webhook for its new status
+func (s *Server) respond(w http.ResponseWriter, status int, intent *payments.Intent) {
+	writeJSON(w, status, intent)
+	go s.notify(intent)
+}
+
+// notify delivers a signed webhook event for the intent, retrying a few times
+func (s *Server) notify(intent *payments.Intent) {
+	if s.config.WebhookURL == "" {
+		return
+	}
+
+	event := payments.WebhookEvent{
+		ID:        fmt.Sprintf("evt_fake_%d", s.eventSeq.Add(1)),
+		Type:      "payment_intent." + intent.Status,
+		CreatedAt: time.Now(),
+		Intent:    *intent,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("fakegateway: encoding event: %v", err)
+		return
+	}
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, s.config.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("fakegateway: building webhook request: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(payments.SignatureHeader, payments.SignPayload(s.config.WebhookSecret, time.Now(), body))
+
+		resp, err := s.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		log.Printf("fakegateway: delivering %s for %s (attempt %d): %v", event.Type, intent.ID, attempt, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func writeProviderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, payments.ErrIntentNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, payments.ErrInvalidIntentState):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": strings.TrimSpace(message)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,106 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GatewayProvider talks to a payment gateway over its HTTP API, such as the
+// local fake gateway in cmd/fakegateway
+type GatewayProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// NewGatewayProvider creates a client for the gateway at baseURL
+func NewGatewayProvider(baseURL, apiKey string) *GatewayProvider {
+	return &GatewayProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *GatewayProvider) CreateIntent(ctx context.Context, params CreateIntentParams) (*Intent, error) {
+	return p.do(ctx, http.MethodPost, "/v1/intents", params)
+}
+
+func (p *GatewayProvider) ConfirmIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.do(ctx, http.MethodPost, "/v1/intents/"+url.PathEscape(intentID)+"/confirm", nil)
+}
+
+func (p *GatewayProvider) CaptureIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.do(ctx, http.MethodPost, "/v1/intents/"+url.PathEscape(intentID)+"/capture", nil)
+}
+
+func (p *GatewayProvider) VoidIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.do(ctx, http.MethodPost, "/v1/intents/"+url.PathEscape(intentID)+"/void", nil)
+}
+
+func (p *GatewayProvider) RefundIntent(ctx context.Context, intentID string, amount float64) (*Intent, error) {
+	body := struct {
+		Amount float64 `json:"amount"`
+	}{Amount: amount}
+	return p.do(ctx, http.MethodPost, "/v1/intents/"+url.PathEscape(intentID)+"/refund", body)
+}
+
+func (p *GatewayProvider) GetIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.do(ctx, http.MethodGet, "/v1/intents/"+url.PathEscape(intentID), nil)
+}
+
+// do sends a request and decodes the intent in the response. 404 and 409
+// responses map to ErrIntentNotFound and ErrInvalidIntentState.
+func (p *GatewayProvider) do(ctx context.Context, method, path string, payload interface{}) (*Intent, error) {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("payment gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return nil, ErrIntentNotFound
+		case http.StatusConflict:
+			return nil, fmt.Errorf("%w%s", ErrInvalidIntentState, strings.TrimPrefix(apiErr.Error, ErrInvalidIntentState.Error()))
+		}
+		return nil, fmt.Errorf("payment gateway: %s %s returned %d: %s", method, path, resp.StatusCode, apiErr.Error)
+	}
+
+	var intent Intent
+	if err := json.NewDecoder(resp.Body).Decode(&intent); err != nil {
+		return nil, fmt.Errorf("payment gateway: decoding response: %w", err)
+	}
+	return &intent, nil
+}
@@ -0,0 +1,66 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Payment intent statuses
+const (
+	IntentRequiresConfirmation = "requires_confirmation"
+	IntentProcessing           = "processing" // confirmed, the outcome arrives later by webhook
+	IntentRequiresCapture      = "requires_capture"
+	IntentSucceeded            = "succeeded"
+	IntentFailed               = "failed"
+	IntentCanceled             = "canceled"
+	IntentRefunded             = "refunded"
+)
+
+var (
+	// ErrIntentNotFound is returned for an unknown payment intent ID
+	ErrIntentNotFound = errors.New("payment intent not found")
+	// ErrInvalidIntentState is returned when an action is not allowed in the intent's status
+	ErrInvalidIntentState = errors.New("payment intent is in the wrong state")
+)
+
+// PaymentProvider defines the interface for payment providers. A payment is
+// an intent that is created, confirmed (authorized, and captured unless
+// capture is manual), and later captured, voided or refunded.
+type PaymentProvider interface {
+	CreateIntent(ctx context.Context, params CreateIntentParams) (*Intent, error)
+	ConfirmIntent(ctx context.Context, intentID string) (*Intent, error)
+	CaptureIntent(ctx context.Context, intentID string) (*Intent, error)
+	// VoidIntent cancels an intent that has not been captured yet
+	VoidIntent(ctx context.Context, intentID string) (*Intent, error)
+	// RefundIntent refunds a captured intent
+	RefundIntent(ctx context.Context, intentID string, amount float64) (*Intent, error)
+	GetIntent(ctx context.Context, intentID string) (*Intent, error)
+}
+
+// CreateIntentParams describes the payment to collect
+type CreateIntentParams struct {
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Method        string  `json:"method"`          // card, applepay, googlepay
+	Token         string  `json:"token,omitempty"` // card or wallet token from the client
+	Reference     string  `json:"reference"`       // our order number
+	ManualCapture bool    `json:"manualCapture"`
+}
+
+// Intent is the provider's record of one payment
+type Intent struct {
+	ID             string    `json:"id"`
+	Amount         float64   `json:"amount"`
+	Currency       string    `json:"currency"`
+	Method         string    `json:"method"`
+	Token          string    `json:"token,omitempty"`
+	Reference      string    `json:"reference"`
+	ManualCapture  bool      `json:"manualCapture"`
+	Status         string    `json:"status"`
+	Message        string    `json:"message,omitempty"`
+	AmountRefunded float64   `json:"amountRefunded,omitempty"`
+	RefundID       string    `json:"refundId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
@@ -1,59 +1,345 @@
 package payments
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 )
 
-// PaymentProvider defines the interface for payment providers
-type PaymentProvider interface {
-	ProcessPayment(amount float64, method string) (*PaymentResult, error)
+// Outcome is how confirming an intent ends: IntentSucceeded, IntentProcessing
+// or IntentFailed with a message, or an error as if the provider were down
+type Outcome struct {
+	Status  string
+	Message string
+	Err     error
 }
 
-// PaymentResult represents the result of a payment transaction
-type PaymentResult struct {
-	TransactionID string
-	Status        string // "success" | "pending" | "failed"
-	Message       string
-	ProcessedAt   time.Time
+// OutcomeRule decides the confirmation outcome of intents without a scripted outcome
+type OutcomeRule func(intent Intent) Outcome
+
+// AmountRules is the default rule, steerable from the checkout UI: amounts
+// ending in .13 are declined and amounts over 100 are processed
+// asynchronously, see AmountSettles for how those end. Anything else succeeds.
+func AmountRules(intent Intent) Outcome {
+	if int(intent.Amount*100)%100 == 13 {
+		return Outcome{Status: IntentFailed, Message: "Payment declined"}
+	}
+	if intent.Amount > 100 {
+		return Outcome{Status: IntentProcessing, Message: "Payment is being processed"}
+	}
+	return Outcome{Status: IntentSucceeded, Message: "Payment processed successfully"}
+}
+
+// AmountSettles reports whether a processing intent settles successfully
+// under AmountRules: amounts ending in .14 are declined once they settle
+func AmountSettles(intent Intent) bool {
+	return int(intent.Amount*100+0.5)%100 != 14
+}
+
+// AlwaysSucceed is a rule under which every payment succeeds
+func AlwaysSucceed(Intent) Outcome {
+	return Outcome{Status: IntentSucceeded, Message: "Payment processed successfully"}
+}
+
+// Call is one recorded call to the stub
+type Call struct {
+	Method   string // CreateIntent, ConfirmIntent, ...
+	IntentID string
+	Amount   float64 // create and refund amounts
+	Params   *CreateIntentParams
+	Err      error
+	At       time.Time
 }
 
-// StubProvider implements a stub payment provider for testing
-type StubProvider struct{}
+// StubProvider is an in-memory payment provider for development and tests.
+// It is deterministic: confirmations follow scripted outcomes, then outcomes
+// scripted per token, then the outcome rule (AmountRules by default). The
+// clock and ID generator can be injected, and every call is recorded.
+type StubProvider struct {
+	mu      sync.Mutex
+	intents map[string]*Intent
+	calls   []Call
+	script  []Outcome
+	tokens  map[string]Outcome
+	rule    OutcomeRule
+	now     func() time.Time
+	newID   func(prefix string) string
+}
+
+// StubOption configures a StubProvider
+type StubOption func(*StubProvider)
+
+// WithClock makes the stub read the time from now
+func WithClock(now func() time.Time) StubOption {
+	return func(p *StubProvider) { p.now = now }
+}
+
+// WithIDGenerator makes the stub take intent and refund IDs from newID
+// instead of RandomIDs. The prefix is "pi" for intents and "re" for refunds.
+func WithIDGenerator(newID func(prefix string) string) StubOption {
+	return func(p *StubProvider) { p.newID = newID }
+}
+
+// WithOutcomeRule replaces AmountRules as the rule for unscripted confirmations
+func WithOutcomeRule(rule OutcomeRule) StubOption {
+	return func(p *StubProvider) { p.rule = rule }
+}
 
 // NewStubProvider creates a new stub payment provider
-func NewStubProvider() *StubProvider {
-	return &StubProvider{}
+func NewStubProvider(opts ...StubOption) *StubProvider {
+	p := &StubProvider{
+		intents: make(map[string]*Intent),
+		tokens:  make(map[string]Outcome),
+		rule:    AmountRules,
+		now:     time.Now,
+	}
+	p.newID = RandomIDs
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// RandomIDs is the default ID generator, its IDs stay unique across restarts
+func RandomIDs(prefix string) string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + "_stub_" + hex.EncodeToString(b)
+}
+
+// SequentialIDs returns a generator of predictable IDs like pi_stub_000001,
+// counted per prefix, for use with WithIDGenerator in tests
+func SequentialIDs() func(prefix string) string {
+	counters := map[string]int{}
+	return func(prefix string) string {
+		counters[prefix]++
+		return fmt.Sprintf("%s_stub_%06d", prefix, counters[prefix])
+	}
+}
+
+// Script queues outcomes for the next confirmations, one per call
+func (p *StubProvider) Script(outcomes ...Outcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.script = append(p.script, outcomes...)
+}
+
+// ScriptToken makes every confirmation of an intent created with the token end with outcome
+func (p *StubProvider) ScriptToken(token string, outcome Outcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens[token] = outcome
+}
+
+// Calls returns the calls made so far
+func (p *StubProvider) Calls() []Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Call(nil), p.calls...)
+}
+
+// CallsTo returns the calls made so far to one method
+func (p *StubProvider) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range p.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets all intents, calls and scripted outcomes
+func (p *StubProvider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.intents = make(map[string]*Intent)
+	p.calls = nil
+	p.script = nil
+	p.tokens = make(map[string]Outcome)
+}
+
+// CreateIntent registers a new intent awaiting confirmation
+func (p *StubProvider) CreateIntent(ctx context.Context, params CreateIntentParams) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	call := Call{Method: "CreateIntent", Amount: params.Amount, Params: &params}
+	if params.Amount <= 0 {
+		return nil, p.record(call, fmt.Errorf("amount must be positive"))
+	}
+
+	now := p.now()
+	intent := &Intent{
+		ID:            p.newID("pi"),
+		Amount:        params.Amount,
+		Currency:      params.Currency,
+		Method:        params.Method,
+		Token:         params.Token,
+		Reference:     params.Reference,
+		ManualCapture: params.ManualCapture,
+		Status:        IntentRequiresConfirmation,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	p.intents[intent.ID] = intent
+
+	call.IntentID = intent.ID
+	p.record(call, nil)
+	return copyIntent(intent), nil
+}
+
+// ConfirmIntent authorizes the intent with the next outcome
+func (p *StubProvider) ConfirmIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.transition("ConfirmIntent", intentID, 0, []string{IntentRequiresConfirmation}, func(intent *Intent) error {
+		outcome := p.nextOutcome(intent)
+		if outcome.Err != nil {
+			return outcome.Err
+		}
+
+		intent.Status = outcome.Status
+		intent.Message = outcome.Message
+		if intent.Status == IntentSucceeded && intent.ManualCapture {
+			intent.Status = IntentRequiresCapture
+			intent.Message = "Payment authorized"
+		}
+		return nil
+	})
 }
 
-// ProcessPayment simulates payment processing
-func (p *StubProvider) ProcessPayment(amount float64, method string) (*PaymentResult, error) {
-	// Simulate processing delay
-	time.Sleep(100 * time.Millisecond)
+// CaptureIntent captures an authorized intent
+func (p *StubProvider) CaptureIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.transition("CaptureIntent", intentID, 0, []string{IntentRequiresCapture}, func(intent *Intent) error {
+		intent.Status = IntentSucceeded
+		intent.Message = "Payment captured"
+		return nil
+	})
+}
 
-	// Generate fake transaction ID
-	transactionID := fmt.Sprintf("STUB-%d", time.Now().Unix())
+// VoidIntent cancels an intent that has not been captured
+func (p *StubProvider) VoidIntent(ctx context.Context, intentID string) (*Intent, error) {
+	return p.transition("VoidIntent", intentID, 0, []string{IntentRequiresConfirmation, IntentProcessing, IntentRequiresCapture}, func(intent *Intent) error {
+		intent.Status = IntentCanceled
+		intent.Message = "Payment voided"
+		return nil
+	})
+}
 
-	// Simulate different outcomes based on amount
-	status := "success"
-	message := "Payment processed successfully"
+// RefundIntent refunds a captured intent
+func (p *StubProvider) RefundIntent(ctx context.Context, intentID string, amount float64) (*Intent, error) {
+	return p.transition("RefundIntent", intentID, amount, []string{IntentSucceeded}, func(intent *Intent) error {
+		if amount <= 0 || amount > intent.Amount {
+			return fmt.Errorf("refund of %.2f does not fit the payment of %.2f", amount, intent.Amount)
+		}
+		intent.Status = IntentRefunded
+		intent.AmountRefunded = amount
+		intent.RefundID = p.newID("re")
+		intent.Message = fmt.Sprintf("Refunded %.2f", amount)
+		return nil
+	})
+}
 
-	// Simulate pending for large amounts
-	if amount > 100 {
-		status = "pending"
-		message = "Payment is being processed"
+// GetIntent returns the current state of an intent
+func (p *StubProvider) GetIntent(ctx context.Context, intentID string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	call := Call{Method: "GetIntent", IntentID: intentID}
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, p.record(call, ErrIntentNotFound)
 	}
+	p.record(call, nil)
+	return copyIntent(intent), nil
+}
+
+// SettleIntent completes a processing intent, as the payment network would
+// some time after confirmation
+func (p *StubProvider) SettleIntent(ctx context.Context, intentID string, succeeded bool) (*Intent, error) {
+	return p.transition("SettleIntent", intentID, 0, []string{IntentProcessing}, func(intent *Intent) error {
+		if succeeded {
+			intent.Status = IntentSucceeded
+			intent.Message = "Payment processed successfully"
+		} else {
+			intent.Status = IntentFailed
+			intent.Message = "Payment declined"
+		}
+		return nil
+	})
+}
 
-	// Simulate failure for amounts ending in .13 (unlucky number:D)
-	if int(amount*100)%100 == 13 {
-		status = "failed"
-		message = "Payment declined"
+// nextOutcome takes the next scripted outcome, else the token's, else the rule's.
+// Must be called with the lock held.
+func (p *StubProvider) nextOutcome(intent *Intent) Outcome {
+	if len(p.script) > 0 {
+		outcome := p.script[0]
+		p.script = p.script[1:]
+		return outcome
 	}
+	if outcome, ok := p.tokens[intent.Token]; ok && intent.Token != "" {
+		return outcome
+	}
+	return p.rule(*intent)
+}
+
+// transition applies change to the intent if its status is one of from.
+// The intent is left untouched when change fails.
+func (p *StubProvider) transition(method, intentID string, amount float64, from []string, change func(*Intent) error) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	call := Call{Method: method, IntentID: intentID, Amount: amount}
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, p.record(call, ErrIntentNotFound)
+	}
+
+	allowed := false
+	for _, status := range from {
+		if intent.Status == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, p.record(call, fmt.Errorf("%w: cannot %s a %s intent", ErrInvalidIntentState, actionName(method), intent.Status))
+	}
+
+	changed := *intent
+	if err := change(&changed); err != nil {
+		return nil, p.record(call, err)
+	}
+	changed.UpdatedAt = p.now()
+	*intent = changed
+
+	p.record(call, nil)
+	return copyIntent(intent), nil
+}
+
+// record appends the call and returns err. Must be called with the lock held.
+func (p *StubProvider) record(call Call, err error) error {
+	call.Err = err
+	call.At = p.now()
+	p.calls = append(p.calls, call)
+	return err
+}
+
+// actionName turns "ConfirmIntent" into "confirm" for error messages
+func actionName(method string) string {
+	name := method
+	if len(name) > len("Intent") {
+		name = name[:len(name)-len("Intent")]
+	}
+	return strings.ToLower(name)
+}
 
-	return &PaymentResult{
-		TransactionID: transactionID,
-		Status:        status,
-		Message:       message,
-		ProcessedAt:   time.Now(),
-	}, nil
+func copyIntent(intent *Intent) *Intent {
+	c := *intent
+	return &c
 }
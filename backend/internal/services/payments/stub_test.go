@@ -0,0 +1,106 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// confirm creates an intent and confirms it, returning the confirmed status
+// or the error
+func confirm(t *testing.T, p *StubProvider, amount float64, token string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	intent, err := p.CreateIntent(ctx, CreateIntentParams{Amount: amount, Currency: "USD", Method: "card", Token: token})
+	if err != nil {
+		t.Fatalf("CreateIntent: %v", err)
+	}
+	confirmed, err := p.ConfirmIntent(ctx, intent.ID)
+	if err != nil {
+		return err.Error()
+	}
+	return confirmed.Status
+}
+
+func TestStubOutcomeOrder(t *testing.T) {
+	p := NewStubProvider()
+	p.ScriptToken("tok_declined", Outcome{Status: IntentFailed})
+	p.Script(Outcome{Status: IntentProcessing}, Outcome{Err: errors.New("provider down")})
+
+	// Scripted outcomes come first, in order, whatever the token or amount
+	tests := []struct {
+		name   string
+		amount float64
+		token  string
+		want   string
+	}{
+		{"first scripted", 10, "tok_declined", IntentProcessing},
+		{"second scripted", 10, "tok_visa", "provider down"},
+		{"token", 10, "tok_declined", IntentFailed},
+		{"rule succeeds", 10, "tok_visa", IntentSucceeded},
+		{"rule declines .13", 10.13, "tok_visa", IntentFailed},
+		{"rule processes over 100", 120, "tok_visa", IntentProcessing},
+	}
+	for _, tt := range tests {
+		if got := confirm(t, p, tt.amount, tt.token); got != tt.want {
+			t.Errorf("%s: confirmed %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStubSequentialIDsAndCalls(t *testing.T) {
+	at := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	p := NewStubProvider(WithIDGenerator(SequentialIDs()), WithClock(func() time.Time { return at }), WithOutcomeRule(AlwaysSucceed))
+	ctx := context.Background()
+
+	first, _ := p.CreateIntent(ctx, CreateIntentParams{Amount: 12, Method: "card"})
+	second, _ := p.CreateIntent(ctx, CreateIntentParams{Amount: 8, Method: "giftcard"})
+	if first.ID != "pi_stub_000001" || second.ID != "pi_stub_000002" {
+		t.Fatalf("intent IDs = %s, %s, want pi_stub_000001, pi_stub_000002", first.ID, second.ID)
+	}
+	if _, err := p.ConfirmIntent(ctx, first.ID); err != nil {
+		t.Fatalf("ConfirmIntent: %v", err)
+	}
+	refunded, err := p.RefundIntent(ctx, first.ID, 12)
+	if err != nil || refunded.RefundID != "re_stub_000001" {
+		t.Fatalf("RefundIntent = %+v, %v, want refund re_stub_000001", refunded, err)
+	}
+	if _, err := p.CaptureIntent(ctx, second.ID); !errors.Is(err, ErrInvalidIntentState) {
+		t.Errorf("capturing an unconfirmed intent = %v, want ErrInvalidIntentState", err)
+	}
+
+	// Every call is recorded with its outcome, failed ones included
+	type recorded struct {
+		Method, IntentID string
+		Amount           float64
+		Failed           bool
+	}
+	var calls []recorded
+	for _, call := range p.Calls() {
+		if !call.At.Equal(at) {
+			t.Errorf("%s recorded at %v, want the injected clock", call.Method, call.At)
+		}
+		calls = append(calls, recorded{call.Method, call.IntentID, call.Amount, call.Err != nil})
+	}
+	want := []recorded{
+		{"CreateIntent", "pi_stub_000001", 12, false},
+		{"CreateIntent", "pi_stub_000002", 8, false},
+		{"ConfirmIntent", "pi_stub_000001", 0, false},
+		{"RefundIntent", "pi_stub_000001", 12, false},
+		{"CaptureIntent", "pi_stub_000002", 0, true},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %+v, want %+v", calls, want)
+	}
+	if refunds := p.CallsTo("RefundIntent"); len(refunds) != 1 {
+		t.Errorf("CallsTo(RefundIntent) = %d calls, want 1", len(refunds))
+	}
+
+	p.Reset()
+	if len(p.Calls()) != 0 {
+		t.Errorf("%d calls after Reset, want none", len(p.Calls()))
+	}
+}
@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the webhook signature, "t=<unix time>,v1=<hex HMAC-SHA256>"
+const SignatureHeader = "X-Payments-Signature"
+
+// ErrInvalidSignature is returned for webhooks that are unsigned, wrongly signed or too old
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// WebhookEvent notifies about a change of a payment intent
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"` // payment_intent.<status>
+	CreatedAt time.Time `json:"createdAt"`
+	Intent    Intent    `json:"intent"`
+}
+
+// SignPayload returns the signature header value for a webhook body. The
+// timestamp is signed along with the body so old requests cannot be replayed.
+func SignPayload(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, computeSignature(secret, ts, body))
+}
+
+// VerifySignature checks a signature header created by SignPayload and
+// rejects signatures older than tolerance
+func VerifySignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signature = value
+		}
+	}
+	if ts == "" || signature == "" {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := computeSignature(secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func computeSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,71 @@
+package payments
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"id":"evt_1","type":"payment_intent.succeeded"}`)
+	signedAt := time.Date(2026, 3, 16, 12, 0, 0, 0, time.UTC)
+	header := SignPayload(secret, signedAt, body)
+	ts := strconv.FormatInt(signedAt.Unix(), 10)
+	signature := strings.TrimPrefix(header, "t="+ts+",v1=")
+	replayedAt := strconv.FormatInt(signedAt.Add(time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr bool
+	}{
+		{"valid", secret, header, body, signedAt, false},
+		{"within the tolerance", secret, header, body, signedAt.Add(5 * time.Minute), false},
+		{"clock skew within the tolerance", secret, header, body, signedAt.Add(-5 * time.Minute), false},
+		{"parts reordered", secret, "v1=" + signature + ", t=" + ts, body, signedAt, false},
+		{"older than the tolerance", secret, header, body, signedAt.Add(5*time.Minute + time.Second), true},
+		{"from the future", secret, header, body, signedAt.Add(-6 * time.Minute), true},
+		{"tampered body", secret, header, []byte(`{"id":"evt_1","type":"payment_intent.failed"}`), signedAt, true},
+		{"wrong secret", "whsec_other", header, body, signedAt, true},
+		{"replayed with a new timestamp", secret, "t=" + replayedAt + ",v1=" + signature, body, signedAt.Add(time.Minute), true},
+		{"missing signature", secret, "t=" + ts, body, signedAt, true},
+		{"missing timestamp", secret, "v1=" + signature, body, signedAt, true},
+		{"malformed timestamp", secret, "t=noon,v1=" + signature, body, signedAt, true},
+		{"empty header", secret, "", body, signedAt, true},
+	}
+
+	for _, tt := range tests {
+		err := VerifySignature(tt.secret, tt.header, tt.body, tt.now, 5*time.Minute)
+		switch {
+		case !tt.wantErr && err != nil:
+			t.Errorf("%s: VerifySignature = %v, want nil", tt.name, err)
+		case tt.wantErr && !errors.Is(err, ErrInvalidSignature):
+			t.Errorf("%s: VerifySignature = %v, want ErrInvalidSignature", tt.name, err)
+		}
+	}
+}
+
+func TestAmountSettles(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   bool
+	}{
+		{120, true},
+		{120.14, false},
+		{0.14, false},
+		{120.13, true},
+		{120.141, false},
+		{121.4, true},
+	}
+	for _, tt := range tests {
+		if got := AmountSettles(Intent{Amount: tt.amount}); got != tt.want {
+			t.Errorf("AmountSettles(%v) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package pricing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ValidationError describes a chosen option or ingredient the product does not offer
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// Configuration is a validated, normalized product configuration with its unit price.
+// Values of "multiple" options are sorted and comma separated, ingredients are sorted.
+type Configuration struct {
+	ChosenOptions     map[string]string
+	ChosenIngredients []string
+	UnitPriceUSD      float64
+}
+
+// Configure validates the chosen options and ingredients against the product
+// and computes the unit price including option surcharges.
+//
+// Options of type "multiple" take several comma separated choice values.
+func Configure(product *models.Product, chosenOptions map[string]string, chosenIngredients []string) (*Configuration, error) {
+	config := &Configuration{
+		ChosenOptions:     map[string]string{},
+		ChosenIngredients: []string{},
+		UnitPriceUSD:      product.PriceUSD,
+	}
+
+	options := make(map[string]models.ProductOption, len(product.Options))
+	for _, option := range product.Options {
+		options[option.Key] = option
+	}
+
+	for key, value := range chosenOptions {
+		option, ok := options[key]
+		if !ok {
+			return nil, &ValidationError{Field: "chosenOptions." + key, Message: "unknown option"}
+		}
+
+		values := []string{value}
+		if option.Type == "multiple" {
+			values = splitValues(value)
+		} else if strings.Contains(value, ",") {
+			return nil, &ValidationError{Field: "chosenOptions." + key, Message: "only one choice allowed"}
+		}
+		if len(values) == 0 || values[0] == "" {
+			if option.Type == "multiple" {
+				// Nothing picked from an optional multiple choice option
+				continue
+			}
+			return nil, &ValidationError{Field: "chosenOptions." + key, Message: "a choice is required"}
+		}
+
+		seen := map[string]bool{}
+		for _, v := range values {
+			if seen[v] {
+				return nil, &ValidationError{Field: "chosenOptions." + key, Message: fmt.Sprintf("choice %q picked twice", v)}
+			}
+			seen[v] = true
+
+			choice, ok := findChoice(option, v)
+			if !ok {
+				return nil, &ValidationError{Field: "chosenOptions." + key, Message: fmt.Sprintf("unknown choice %q", v)}
+			}
+			config.UnitPriceUSD += choice.ExtraPriceUSD
+		}
+
+		sort.Strings(values)
+		config.ChosenOptions[key] = strings.Join(values, ",")
+	}
+
+	ingredients := make(map[string]bool, len(product.Ingredients))
+	for _, ingredient := range product.Ingredients {
+		ingredients[ingredient.Key] = true
+	}
+	seen := map[string]bool{}
+	for _, key := range chosenIngredients {
+		if !ingredients[key] {
+			return nil, &ValidationError{Field: "chosenIngredients", Message: fmt.Sprintf("unknown ingredient %q", key)}
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		config.ChosenIngredients = append(config.ChosenIngredients, key)
+	}
+	sort.Strings(config.ChosenIngredients)
+
+	config.UnitPriceUSD = Round(config.UnitPriceUSD)
+	return config, nil
+}
+
+// LineID derives the stable cart line ID of a product configuration. The
+// options and ingredients must be normalized as returned by Configure.
+func LineID(productID primitive.ObjectID, chosenOptions map[string]string, chosenIngredients []string) string {
+	keys := make([]string, 0, len(chosenOptions))
+	for key := range chosenOptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(productID.Hex())
+	b.WriteString("|")
+	for _, key := range keys {
+		b.WriteString(key + "=" + chosenOptions[key] + ";")
+	}
+	b.WriteString("|")
+	ingredients := append([]string(nil), chosenIngredients...)
+	sort.Strings(ingredients)
+	b.WriteString(strings.Join(ingredients, ","))
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+// EnsureLineIDs assigns line IDs to cart lines stored before lines had them
+func EnsureLineIDs(cart *models.Cart) {
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		if item.LineID == "" {
+			item.LineID = LineID(item.ProductID, item.ChosenOptions, item.ChosenIngredients)
+		}
+	}
+}
+
+// PriceCart recomputes every line total, applies the best eligible promotion
+// and computes the cart subtotal, discount and total.
+// It is the only place cart totals are computed.
+func PriceCart(cart *models.Cart, promotions []*models.Promotion, now time.Time) {
+	cart.SubtotalUSD = 0
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		item.TotalUSD = Round(item.UnitPriceUSD * float64(item.Qty))
+		item.DiscountUSD = 0
+		cart.SubtotalUSD += item.TotalUSD
+	}
+	cart.SubtotalUSD = Round(cart.SubtotalUSD)
+	cart.DiscountUSD = 0
+	cart.AppliedPromotion = nil
+
+	if best := bestDiscount(cart, promotions, now); best != nil {
+		for i := range cart.Items {
+			cart.Items[i].DiscountUSD = best.lines[i]
+		}
+		cart.DiscountUSD = best.total
+		cart.AppliedPromotion = &models.AppliedPromotion{
+			PromotionID: best.promotion.ID,
+			Title:       best.promotion.Title,
+			Type:        best.promotion.Discount.Type,
+			DiscountUSD: best.total,
+		}
+	}
+
+	cart.TotalUSD = Round(cart.SubtotalUSD - cart.DiscountUSD)
+}
+
+// OrderTotal returns what the customer pays for an order of the priced cart:
+// the cart total plus the fee lines and the tip
+func OrderTotal(cart *models.Cart, fees []models.FeeLine, tipUSD float64) float64 {
+	total := cart.TotalUSD + Round(tipUSD)
+	for _, fee := range fees {
+		total += fee.AmountUSD
+	}
+	return Round(total)
+}
+
+// OrderItems converts priced cart lines into order lines
+func OrderItems(cart *models.Cart) []models.OrderItem {
+	items := make([]models.OrderItem, len(cart.Items))
+	for i, item := range cart.Items {
+		items[i] = models.OrderItem(item)
+	}
+	return items
+}
+
+// Round rounds an amount to whole cents
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func findChoice(option models.ProductOption, value string) (models.OptionChoice, bool) {
+	for _, choice := range option.Choices {
+		if choice.Value == value {
+			return choice, true
+		}
+	}
+	return models.OptionChoice{}, false
+}
+
+func splitValues(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
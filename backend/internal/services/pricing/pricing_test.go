@@ -0,0 +1,285 @@
+package pricing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testPizza() *models.Product {
+	return &models.Product{
+		ID:       primitive.NewObjectID(),
+		Name:     "Margherita",
+		Slug:     "pizza",
+		PriceUSD: 10,
+		IsActive: true,
+		Options: []models.ProductOption{
+			{Key: "size", Type: "single", Choices: []models.OptionChoice{
+				{Value: "s"},
+				{Value: "l", ExtraPriceUSD: 2.5},
+			}},
+			{Key: "toppings", Type: "multiple", Choices: []models.OptionChoice{
+				{Value: "olives", ExtraPriceUSD: 0.75},
+				{Value: "ham", ExtraPriceUSD: 1.5},
+			}},
+		},
+		Ingredients: []models.Ingredient{{Key: "cheese"}, {Key: "basil"}},
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     map[string]string
+		ingredients []string
+		wantPrice   float64
+		wantOptions map[string]string
+		wantIngr    []string
+		wantField   string // field of the expected ValidationError
+	}{
+		{name: "base price", wantPrice: 10, wantOptions: map[string]string{}, wantIngr: []string{}},
+		{name: "single surcharge", options: map[string]string{"size": "l"}, wantPrice: 12.5, wantOptions: map[string]string{"size": "l"}, wantIngr: []string{}},
+		{name: "multiple choices sorted", options: map[string]string{"toppings": "olives, ham"}, wantPrice: 12.25, wantOptions: map[string]string{"toppings": "ham,olives"}, wantIngr: []string{}},
+		{name: "empty multiple skipped", options: map[string]string{"toppings": ""}, wantPrice: 10, wantOptions: map[string]string{}, wantIngr: []string{}},
+		{name: "ingredients sorted and deduplicated", ingredients: []string{"cheese", "basil", "cheese"}, wantPrice: 10, wantOptions: map[string]string{}, wantIngr: []string{"basil", "cheese"}},
+		{name: "unknown option", options: map[string]string{"crust": "thin"}, wantField: "chosenOptions.crust"},
+		{name: "unknown choice", options: map[string]string{"size": "xl"}, wantField: "chosenOptions.size"},
+		{name: "two choices of a single option", options: map[string]string{"size": "s,l"}, wantField: "chosenOptions.size"},
+		{name: "empty single", options: map[string]string{"size": ""}, wantField: "chosenOptions.size"},
+		{name: "choice picked twice", options: map[string]string{"toppings": "ham,ham"}, wantField: "chosenOptions.toppings"},
+		{name: "unknown ingredient", ingredients: []string{"pineapple"}, wantField: "chosenIngredients"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Configure(testPizza(), tt.options, tt.ingredients)
+			if tt.wantField != "" {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) || validationErr.Field != tt.wantField {
+					t.Fatalf("error = %v, want a ValidationError on %s", err, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Configure: %v", err)
+			}
+			if config.UnitPriceUSD != tt.wantPrice {
+				t.Errorf("unit price = %v, want %v", config.UnitPriceUSD, tt.wantPrice)
+			}
+			if !reflect.DeepEqual(config.ChosenOptions, tt.wantOptions) {
+				t.Errorf("options = %v, want %v", config.ChosenOptions, tt.wantOptions)
+			}
+			if !reflect.DeepEqual(config.ChosenIngredients, tt.wantIngr) {
+				t.Errorf("ingredients = %v, want %v", config.ChosenIngredients, tt.wantIngr)
+			}
+		})
+	}
+}
+
+func TestLineID(t *testing.T) {
+	id := primitive.NewObjectID()
+	base := LineID(id, map[string]string{"size": "l", "toppings": "ham,olives"}, []string{"basil", "cheese"})
+
+	if got := LineID(id, map[string]string{"toppings": "ham,olives", "size": "l"}, []string{"cheese", "basil"}); got != base {
+		t.Errorf("same configuration in another order = %s, want %s", got, base)
+	}
+	if got := LineID(id, map[string]string{"size": "s", "toppings": "ham,olives"}, []string{"basil", "cheese"}); got == base {
+		t.Errorf("another option kept line ID %s", got)
+	}
+	if got := LineID(id, map[string]string{"size": "l", "toppings": "ham,olives"}, []string{"basil"}); got == base {
+		t.Errorf("another ingredient kept line ID %s", got)
+	}
+	if got := LineID(primitive.NewObjectID(), map[string]string{"size": "l", "toppings": "ham,olives"}, []string{"basil", "cheese"}); got == base {
+		t.Errorf("another product kept line ID %s", got)
+	}
+}
+
+func TestPriceCart(t *testing.T) {
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+	promotion := func(title string, rule models.DiscountRule, appliesTo ...string) *models.Promotion {
+		return &models.Promotion{
+			ID:        primitive.NewObjectID(),
+			Title:     title,
+			IsActive:  true,
+			StartsAt:  now.Add(-time.Hour),
+			AppliesTo: appliesTo,
+			Discount:  &rule,
+		}
+	}
+	tenPercentPizza := promotion("10% off pizza", models.DiscountRule{Type: models.DiscountPercent, Percent: 10}, "pizza")
+	threeForTwo := promotion("3 for 2", models.DiscountRule{Type: models.DiscountBuyXGetY, BuyQty: 2, GetQty: 1}, "pizza")
+	inactive := promotion("switched off", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 5})
+	inactive.IsActive = false
+	ended := promotion("ended", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 5})
+	ended.EndsAt = now
+	upcoming := promotion("upcoming", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 5})
+	upcoming.StartsAt = now.Add(time.Minute)
+
+	tests := []struct {
+		name         string
+		promotions   []*models.Promotion
+		wantApplied  string
+		wantDiscount float64
+		wantLines    []float64
+	}{
+		{name: "no promotion", wantLines: []float64{0, 0}},
+		{name: "percent on matching lines", promotions: []*models.Promotion{tenPercentPizza}, wantApplied: "10% off pizza", wantDiscount: 3.75, wantLines: []float64{3.75, 0}},
+		{name: "fixed off the order", promotions: []*models.Promotion{promotion("5 off", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 5}, "all")}, wantApplied: "5 off", wantDiscount: 5, wantLines: []float64{0, 0}},
+		{name: "fixed capped at the eligible lines", promotions: []*models.Promotion{promotion("50 off soda", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 50}, "soda")}, wantApplied: "50 off soda", wantDiscount: 3.98, wantLines: []float64{0, 0}},
+		{name: "buy x get y", promotions: []*models.Promotion{threeForTwo}, wantApplied: "3 for 2", wantDiscount: 12.5, wantLines: []float64{12.5, 0}},
+		{name: "best promotion wins", promotions: []*models.Promotion{tenPercentPizza, threeForTwo}, wantApplied: "3 for 2", wantDiscount: 12.5, wantLines: []float64{12.5, 0}},
+		{name: "minimum subtotal not reached", promotions: []*models.Promotion{promotion("big order", models.DiscountRule{Type: models.DiscountPercent, Percent: 20, MinSubtotalUSD: 50})}, wantLines: []float64{0, 0}},
+		{name: "display only", promotions: []*models.Promotion{{Title: "banner", IsActive: true, StartsAt: now.Add(-time.Hour)}}, wantLines: []float64{0, 0}},
+		{name: "not running", promotions: []*models.Promotion{inactive, ended, upcoming}, wantLines: []float64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cart := &models.Cart{Items: []models.CartItem{
+				{Slug: "pizza", Qty: 3, UnitPriceUSD: 12.5},
+				{Slug: "soda", Qty: 2, UnitPriceUSD: 1.99},
+			}}
+			PriceCart(cart, tt.promotions, now)
+
+			if cart.SubtotalUSD != 41.48 {
+				t.Errorf("subtotal = %v, want 41.48", cart.SubtotalUSD)
+			}
+			if cart.DiscountUSD != tt.wantDiscount {
+				t.Errorf("discount = %v, want %v", cart.DiscountUSD, tt.wantDiscount)
+			}
+			if want := Round(41.48 - tt.wantDiscount); cart.TotalUSD != want {
+				t.Errorf("total = %v, want %v", cart.TotalUSD, want)
+			}
+			for i, want := range tt.wantLines {
+				if got := cart.Items[i].DiscountUSD; got != want {
+					t.Errorf("line %d discount = %v, want %v", i, got, want)
+				}
+			}
+			switch {
+			case tt.wantApplied == "" && cart.AppliedPromotion != nil:
+				t.Errorf("applied %q, want no promotion", cart.AppliedPromotion.Title)
+			case tt.wantApplied != "" && (cart.AppliedPromotion == nil || cart.AppliedPromotion.Title != tt.wantApplied):
+				t.Errorf("applied %+v, want %q", cart.AppliedPromotion, tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestOrderTotal(t *testing.T) {
+	cart := &models.Cart{TotalUSD: 36.48}
+	fees := []models.FeeLine{{Type: "delivery", AmountUSD: 2.99}, {Type: "service", AmountUSD: 1.01}}
+
+	tests := []struct {
+		name string
+		fees []models.FeeLine
+		tip  float64
+		want float64
+	}{
+		{"cart only", nil, 0, 36.48},
+		{"fees", fees, 0, 40.48},
+		{"fees and tip", fees, 3, 43.48},
+		{"tip rounded to cents", nil, 3.333, 39.81},
+	}
+	for _, tt := range tests {
+		if got := OrderTotal(cart, tt.fees, tt.tip); got != tt.want {
+			t.Errorf("%s: OrderTotal = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		amount, want float64
+	}{
+		{0.125, 0.13},
+		{10.994, 10.99},
+		{0.1 + 0.2, 0.3},
+		{-2.5, -2.5},
+	}
+	for _, tt := range tests {
+		if got := Round(tt.amount); got != tt.want {
+			t.Errorf("Round(%v) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDiscount(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      models.DiscountRule
+		wantField string
+	}{
+		{"percent", models.DiscountRule{Type: models.DiscountPercent, Percent: 15}, ""},
+		{"percent over 100", models.DiscountRule{Type: models.DiscountPercent, Percent: 120}, "discount.percent"},
+		{"percent zero", models.DiscountRule{Type: models.DiscountPercent}, "discount.percent"},
+		{"fixed", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 5}, ""},
+		{"fixed negative", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: -5}, "discount.amountUSD"},
+		{"buy x get y", models.DiscountRule{Type: models.DiscountBuyXGetY, BuyQty: 2, GetQty: 1}, ""},
+		{"buy x get nothing", models.DiscountRule{Type: models.DiscountBuyXGetY, BuyQty: 2}, "discount"},
+		{"unknown type", models.DiscountRule{Type: "bogo"}, "discount.type"},
+		{"negative minimum", models.DiscountRule{Type: models.DiscountFixed, AmountUSD: 5, MinSubtotalUSD: -1}, "discount.minSubtotalUSD"},
+	}
+	for _, tt := range tests {
+		err := ValidateDiscount(&tt.rule)
+		var validationErr *ValidationError
+		switch {
+		case tt.wantField == "" && err != nil:
+			t.Errorf("%s: ValidateDiscount = %v, want nil", tt.name, err)
+		case tt.wantField != "" && (!errors.As(err, &validationErr) || validationErr.Field != tt.wantField):
+			t.Errorf("%s: ValidateDiscount = %v, want a ValidationError on %s", tt.name, err, tt.wantField)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	pizza := testPizza()
+	pizza.PriceUSD = 11
+	pizza.Name = "Margherita Deluxe"
+	inactive := testPizza()
+	inactive.IsActive = false
+	removed := primitive.NewObjectID()
+	products := map[primitive.ObjectID]*models.Product{pizza.ID: pizza, inactive.ID: inactive}
+
+	cart := &models.Cart{Items: []models.CartItem{
+		{LineID: "repriced", ProductID: pizza.ID, Name: "Margherita", Qty: 1, UnitPriceUSD: 12.5, ChosenOptions: map[string]string{"size": "l"}},
+		{LineID: "unchanged", ProductID: pizza.ID, Name: "Margherita", Qty: 1, UnitPriceUSD: 11},
+		{LineID: "option-gone", ProductID: pizza.ID, Qty: 1, UnitPriceUSD: 10, ChosenOptions: map[string]string{"crust": "thin"}},
+		{LineID: "inactive", ProductID: inactive.ID, Qty: 1, UnitPriceUSD: 10},
+		{LineID: "removed", ProductID: removed, Qty: 1, UnitPriceUSD: 10},
+	}}
+	changes := Reconcile(cart, products)
+
+	type change struct {
+		LineID, Reason string
+		Old, New       float64
+	}
+	var got []change
+	for _, c := range changes {
+		got = append(got, change{c.LineID, c.Reason, c.OldUnitPriceUSD, c.NewUnitPriceUSD})
+	}
+	want := []change{
+		{"repriced", ChangePrice, 12.5, 13.5},
+		{"option-gone", ChangeInvalidConfiguration, 0, 0},
+		{"inactive", ChangeUnavailable, 0, 0},
+		{"removed", ChangeUnavailable, 0, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+
+	if len(cart.Items) != 2 || cart.Items[0].LineID != "repriced" || cart.Items[1].LineID != "unchanged" {
+		t.Fatalf("kept lines = %+v, want repriced and unchanged", cart.Items)
+	}
+	if cart.Items[0].UnitPriceUSD != 13.5 {
+		t.Errorf("repriced unit price = %v, want 13.5", cart.Items[0].UnitPriceUSD)
+	}
+	for _, item := range cart.Items {
+		if item.Name != "Margherita Deluxe" || item.Slug != "pizza" {
+			t.Errorf("line %s name %q slug %q, want refreshed from the product", item.LineID, item.Name, item.Slug)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package pricing
+
+import (
+	"math"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+// discount is what one promotion takes off a cart
+type discount struct {
+	promotion *models.Promotion
+	lines     []float64 // per cart line, parallel to cart.Items
+	total     float64   // line discounts plus the order-level discount
+}
+
+// IsPromotionActive reports whether the promotion is switched on and running at now.
+// A zero EndsAt means the promotion does not end.
+func IsPromotionActive(promotion *models.Promotion, now time.Time) bool {
+	if !promotion.IsActive || now.Before(promotion.StartsAt) {
+		return false
+	}
+	return promotion.EndsAt.IsZero() || now.Before(promotion.EndsAt)
+}
+
+// ValidateDiscount checks that a discount rule is complete and sensible
+func ValidateDiscount(rule *models.DiscountRule) error {
+	switch rule.Type {
+	case models.DiscountPercent:
+		if rule.Percent <= 0 || rule.Percent > 100 {
+			return &ValidationError{Field: "discount.percent", Message: "must be between 0 and 100"}
+		}
+	case models.DiscountFixed:
+		if rule.AmountUSD <= 0 {
+			return &ValidationError{Field: "discount.amountUSD", Message: "must be positive"}
+		}
+	case models.DiscountBuyXGetY:
+		if rule.BuyQty < 1 || rule.GetQty < 1 {
+			return &ValidationError{Field: "discount", Message: "buyQty and getQty must be at least 1"}
+		}
+	default:
+		return &ValidationError{Field: "discount.type", Message: "must be percent, fixed or buy_x_get_y"}
+	}
+	if rule.MinSubtotalUSD < 0 {
+		return &ValidationError{Field: "discount.minSubtotalUSD", Message: "must not be negative"}
+	}
+	return nil
+}
+
+// bestDiscount returns the largest discount any eligible promotion gives the
+// cart, or nil when none applies. Ties go to the earlier promotion.
+func bestDiscount(cart *models.Cart, promotions []*models.Promotion, now time.Time) *discount {
+	var best *discount
+	for _, promotion := range promotions {
+		d := evaluate(cart, promotion, now)
+		if d != nil && (best == nil || d.total > best.total) {
+			best = d
+		}
+	}
+	return best
+}
+
+// evaluate computes the discount of one promotion on a priced cart
+func evaluate(cart *models.Cart, promotion *models.Promotion, now time.Time) *discount {
+	rule := promotion.Discount
+	if rule == nil || !IsPromotionActive(promotion, now) || cart.SubtotalUSD < rule.MinSubtotalUSD {
+		return nil
+	}
+
+	d := &discount{promotion: promotion, lines: make([]float64, len(cart.Items))}
+	var eligibleSubtotal float64
+	for i, item := range cart.Items {
+		if !appliesTo(promotion, item.Slug) {
+			continue
+		}
+		eligibleSubtotal += item.TotalUSD
+
+		switch rule.Type {
+		case models.DiscountPercent:
+			d.lines[i] = Round(item.TotalUSD * math.Min(rule.Percent, 100) / 100)
+		case models.DiscountBuyXGetY:
+			if rule.BuyQty > 0 && rule.GetQty > 0 {
+				free := item.Qty / (rule.BuyQty + rule.GetQty) * rule.GetQty
+				d.lines[i] = Round(float64(free) * item.UnitPriceUSD)
+			}
+		}
+		d.total += d.lines[i]
+	}
+
+	if rule.Type == models.DiscountFixed {
+		// Order-level, never more than the eligible lines are worth
+		d.total = math.Min(rule.AmountUSD, eligibleSubtotal)
+	}
+
+	d.total = Round(d.total)
+	if d.total <= 0 {
+		return nil
+	}
+	return d
+}
+
+// appliesTo reports whether the promotion covers the product with the given slug
+func appliesTo(promotion *models.Promotion, slug string) bool {
+	if len(promotion.AppliesTo) == 0 {
+		return true
+	}
+	for _, s := range promotion.AppliesTo {
+		if s == "all" || (slug != "" && s == slug) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package pricing
+
+import (
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons a cart line changed when reconciled with the catalog
+const (
+	ChangeUnavailable          = "product_unavailable"
+	ChangeInvalidConfiguration = "configuration_invalid"
+	ChangePrice                = "price_changed"
+)
+
+// CartChange describes how one cart line differs from the live catalog
+type CartChange struct {
+	LineID          string             `json:"lineId"`
+	ProductID       primitive.ObjectID `json:"productId"`
+	Name            string             `json:"name"`
+	Reason          string             `json:"reason"`
+	Message         string             `json:"message,omitempty"`
+	OldUnitPriceUSD float64            `json:"oldUnitPriceUSD,omitempty"`
+	NewUnitPriceUSD float64            `json:"newUnitPriceUSD,omitempty"`
+}
+
+// Reconcile brings the cart lines in line with the current products, keyed by
+// product ID. Lines of missing or inactive products and lines whose options or
+// ingredients the product no longer offers are removed, unit prices are
+// recomputed. Name, image and slug are refreshed silently. It returns the
+// changes the customer has to see before checking out.
+func Reconcile(cart *models.Cart, products map[primitive.ObjectID]*models.Product) []CartChange {
+	changes := []CartChange{}
+	items := cart.Items[:0]
+
+	for _, item := range cart.Items {
+		change := CartChange{LineID: item.LineID, ProductID: item.ProductID, Name: item.Name}
+
+		product, ok := products[item.ProductID]
+		if !ok || !product.IsActive {
+			change.Reason = ChangeUnavailable
+			change.Message = "Product is no longer available"
+			changes = append(changes, change)
+			continue
+		}
+
+		config, err := Configure(product, item.ChosenOptions, item.ChosenIngredients)
+		if err != nil {
+			change.Reason = ChangeInvalidConfiguration
+			change.Message = err.Error()
+			changes = append(changes, change)
+			continue
+		}
+
+		if config.UnitPriceUSD != item.UnitPriceUSD {
+			change.Reason = ChangePrice
+			change.OldUnitPriceUSD = item.UnitPriceUSD
+			change.NewUnitPriceUSD = config.UnitPriceUSD
+			changes = append(changes, change)
+			item.UnitPriceUSD = config.UnitPriceUSD
+		}
+
+		item.Name = product.Name
+		item.Image = product.Image
+		item.Slug = product.Slug
+		items = append(items, item)
+	}
+
+	cart.Items = items
+	return changes
+}
@@ -0,0 +1,217 @@
+package scheduling
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Reasons the store is not accepting orders
+const (
+	ReasonOutsideHours = "outside_hours"
+	ReasonHoliday      = "holiday"
+	ReasonPaused       = "paused"
+)
+
+// SettingsStore loads and saves the store settings document
+type SettingsStore interface {
+	Get(ctx context.Context) (*models.StoreSettings, error)
+	// SaveIfVersion saves the settings only if the stored ones are still at version
+	SaveIfVersion(ctx context.Context, settings *models.StoreSettings, version int) (bool, error)
+}
+
+// saveAttempts bounds how often an update is retried when the settings keep
+// changing concurrently, e.g. from another API instance
+const saveAttempts = 3
+
+// ErrSettingsChanged is returned when the settings kept changing while updating them
+var ErrSettingsChanged = errors.New("store settings changed concurrently")
+
+// Status tells whether the store is accepting orders right now
+type Status struct {
+	AcceptingOrders bool       `json:"acceptingOrders"`
+	Reason          string     `json:"reason,omitempty"` // outside_hours, holiday, paused
+	Message         string     `json:"message,omitempty"`
+	NextOpeningAt   *time.Time `json:"nextOpeningAt,omitempty"`
+	Timezone        string     `json:"timezone"`
+}
+
+// Calendar provides the store's opening hours, holidays and ordering pause.
+// The settings are cached briefly as every order and health check reads them.
+type Calendar struct {
+	settings SettingsStore
+	defaults models.StoreSettings
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	current   *models.StoreSettings
+	hours     Hours
+	expiresAt time.Time
+}
+
+// NewCalendar creates a calendar. The defaults apply until an admin saves
+// settings.
+func NewCalendar(settings SettingsStore, defaults models.StoreSettings, ttl time.Duration) (*Calendar, error) {
+	if _, err := Compile(defaults); err != nil {
+		return nil, err
+	}
+	return &Calendar{settings: settings, defaults: defaults, ttl: ttl}, nil
+}
+
+// Settings returns the current store settings and their compiled hours
+func (c *Calendar) Settings(ctx context.Context) (*models.StoreSettings, Hours, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current != nil && time.Now().Before(c.expiresAt) {
+		return c.current, c.hours, nil
+	}
+	return c.load(ctx)
+}
+
+// load reads the stored settings into the cache. Must be called with the
+// lock held.
+func (c *Calendar) load(ctx context.Context) (*models.StoreSettings, Hours, error) {
+	settings, err := c.settings.Get(ctx)
+	if err == mongo.ErrNoDocuments {
+		defaults := c.defaults
+		settings, err = &defaults, nil
+	}
+	if err != nil {
+		return nil, Hours{}, err
+	}
+
+	hours, err := Compile(*settings)
+	if err != nil {
+		return nil, Hours{}, err
+	}
+
+	c.current, c.hours, c.expiresAt = settings, hours, time.Now().Add(c.ttl)
+	return settings, hours, nil
+}
+
+// Hours returns the compiled opening hours
+func (c *Calendar) Hours(ctx context.Context) (Hours, error) {
+	_, hours, err := c.Settings(ctx)
+	return hours, err
+}
+
+// Status reports whether the store accepts orders at now and, if not, why
+// and when it opens again
+func (c *Calendar) Status(ctx context.Context, now time.Time) (Status, error) {
+	settings, hours, err := c.Settings(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{AcceptingOrders: true, Timezone: settings.Timezone}
+
+	// Once a timed pause ends the store opens with its regular hours
+	reopensFrom := now
+	if pause := settings.Pause; pause != nil && (pause.Until == nil || now.Before(*pause.Until)) {
+		status.AcceptingOrders = false
+		status.Reason = ReasonPaused
+		status.Message = "Ordering is paused"
+		if pause.Reason != "" {
+			status.Message += ": " + pause.Reason
+		}
+		if pause.Until == nil {
+			return status, nil
+		}
+		reopensFrom = *pause.Until
+	} else if !hours.IsOpen(now) {
+		status.AcceptingOrders = false
+		status.Reason = ReasonOutsideHours
+		status.Message = "The store is closed"
+		if name, ok := hours.Holiday(now); ok {
+			status.Reason = ReasonHoliday
+			status.Message = "The store is closed for " + name
+		}
+	}
+
+	if !status.AcceptingOrders {
+		if next, ok := hours.NextOpening(reopensFrom); ok {
+			status.NextOpeningAt = &next
+		}
+	}
+	return status, nil
+}
+
+// UpdateHours replaces the weekly hours and holidays, keeping any pause
+func (c *Calendar) UpdateHours(ctx context.Context, timezone string, weekly map[string][]models.TimeWindow, holidays []models.HolidayException) (*models.StoreSettings, error) {
+	return c.update(ctx, func(settings *models.StoreSettings) {
+		settings.Timezone = timezone
+		settings.WeeklyHours = weekly
+		settings.Holidays = holidays
+	})
+}
+
+// Pause stops the store from taking orders until the given time, or until
+// resumed when until is nil
+func (c *Calendar) Pause(ctx context.Context, pause models.OrderingPause) (*models.StoreSettings, error) {
+	return c.update(ctx, func(settings *models.StoreSettings) {
+		settings.Pause = &pause
+	})
+}
+
+// Resume lifts an ordering pause
+func (c *Calendar) Resume(ctx context.Context) (*models.StoreSettings, error) {
+	return c.update(ctx, func(settings *models.StoreSettings) {
+		settings.Pause = nil
+	})
+}
+
+// update applies change to a copy of the stored settings, validates and
+// saves it. The lock is held throughout so updates through this calendar run
+// one at a time; the settings are only saved at the version read, so updates
+// from other instances are re-read and the change applied again.
+func (c *Calendar) update(ctx context.Context, change func(settings *models.StoreSettings)) (*models.StoreSettings, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 1; attempt <= saveAttempts; attempt++ {
+		// Read the stored settings, not the cache
+		current, _, err := c.load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := *current
+		change(&updated)
+		updated.ID = models.StoreSettingsID
+		if updated.Holidays == nil {
+			updated.Holidays = []models.HolidayException{}
+		}
+		updated.UpdatedAt = time.Now()
+
+		hours, err := Compile(updated)
+		if err != nil {
+			return nil, &InvalidSettingsError{Err: err}
+		}
+		saved, err := c.settings.SaveIfVersion(ctx, &updated, current.Version)
+		if err != nil {
+			return nil, err
+		}
+		if saved {
+			c.current, c.hours, c.expiresAt = &updated, hours, time.Now().Add(c.ttl)
+			return &updated, nil
+		}
+	}
+	return nil, ErrSettingsChanged
+}
+
+// InvalidSettingsError is returned when updated settings do not validate
+type InvalidSettingsError struct {
+	Err error
+}
+
+func (e *InvalidSettingsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidSettingsError) Unwrap() error {
+	return e.Err
+}
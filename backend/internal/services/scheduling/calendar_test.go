@@ -0,0 +1,124 @@
+package scheduling
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memorySettings is a SettingsStore in memory. interfere, when set, runs
+// before every save, as another instance updating the settings would.
+type memorySettings struct {
+	mu        sync.Mutex
+	stored    *models.StoreSettings
+	interfere func(stored *models.StoreSettings)
+}
+
+func (s *memorySettings) Get(ctx context.Context) (*models.StoreSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stored == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *s.stored
+	return &copied, nil
+}
+
+func (s *memorySettings) SaveIfVersion(ctx context.Context, settings *models.StoreSettings, version int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.interfere != nil && s.stored != nil {
+		s.interfere(s.stored)
+	}
+	stored := 0
+	if s.stored != nil {
+		stored = s.stored.Version
+	}
+	if stored != version {
+		return false, nil
+	}
+	settings.Version = version + 1
+	copied := *settings
+	s.stored = &copied
+	return true, nil
+}
+
+func testCalendar(t *testing.T, store SettingsStore) *Calendar {
+	t.Helper()
+	weekly, err := ParseWeeklyHours("mon-sun 09:00-17:00")
+	if err != nil {
+		t.Fatalf("ParseWeeklyHours: %v", err)
+	}
+	calendar, err := NewCalendar(store, models.StoreSettings{ID: models.StoreSettingsID, Timezone: "UTC", WeeklyHours: weekly}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewCalendar: %v", err)
+	}
+	return calendar
+}
+
+func TestCalendarConcurrentUpdates(t *testing.T) {
+	store := &memorySettings{}
+	calendar := testCalendar(t, store)
+	ctx := context.Background()
+	weekly := map[string][]models.TimeWindow{"mon": {{Open: "10:00", Close: "14:00"}}}
+
+	// Neither update may undo the other
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := calendar.Pause(ctx, models.OrderingPause{Reason: "kitchen full", PausedAt: time.Now()})
+		errs <- err
+	}()
+	go func() {
+		defer wg.Done()
+		_, err := calendar.UpdateHours(ctx, "Europe/London", weekly, nil)
+		errs <- err
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+
+	stored, _ := store.Get(ctx)
+	if stored.Pause == nil || stored.Timezone != "Europe/London" || stored.Version != 2 {
+		t.Errorf("stored settings = %+v, want paused with the new hours at version 2", stored)
+	}
+}
+
+func TestCalendarUpdateRetriesOtherInstance(t *testing.T) {
+	store := &memorySettings{}
+	calendar := testCalendar(t, store)
+	ctx := context.Background()
+	if _, err := calendar.Resume(ctx); err != nil {
+		t.Fatalf("first save: %v", err)
+	}
+
+	// Another instance pauses ordering just before the hours are saved
+	store.interfere = func(stored *models.StoreSettings) {
+		stored.Pause = &models.OrderingPause{Reason: "other instance"}
+		stored.Version++
+		store.interfere = nil
+	}
+	updated, err := calendar.UpdateHours(ctx, "Europe/London", map[string][]models.TimeWindow{}, nil)
+	if err != nil {
+		t.Fatalf("UpdateHours: %v", err)
+	}
+	if updated.Pause == nil || updated.Pause.Reason != "other instance" || updated.Timezone != "Europe/London" {
+		t.Errorf("updated = %+v, want the new hours on top of the other instance's pause", updated)
+	}
+
+	// Settings that never stop changing are given up on
+	store.interfere = func(stored *models.StoreSettings) { stored.Version++ }
+	if _, err := calendar.Resume(ctx); err != ErrSettingsChanged {
+		t.Errorf("Resume = %v, want ErrSettingsChanged", err)
+	}
+}
@@ -0,0 +1,239 @@
+package scheduling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+// Window is an opening window within a day, in minutes since midnight.
+// Close may be 24:00; windows past midnight are split over two days.
+type Window struct {
+	Open  int
+	Close int
+}
+
+// Hours is the opening schedule of the store in its time zone
+type Hours struct {
+	Location   *time.Location
+	Days       [7][]Window         // indexed by time.Weekday
+	Exceptions map[string][]Window // holiday hours by YYYY-MM-DD, empty when closed
+	Holidays   map[string]string   // holiday names by YYYY-MM-DD
+}
+
+// dateLayout is the layout of holiday dates
+const dateLayout = "2006-01-02"
+
+// lookAhead bounds the search for the next opening time
+const lookAhead = 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseWeeklyHours parses opening hours such as
+// "mon-fri 11:00-15:00 17:00-22:00; sat-sun 12:00-23:00" into weekly hours.
+// Days that are not listed are closed.
+func ParseWeeklyHours(spec string) (map[string][]models.TimeWindow, error) {
+	weekly := make(map[string][]models.TimeWindow)
+
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("opening hours %q: missing time window", strings.TrimSpace(entry))
+		}
+
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields[1:] {
+			open, close, ok := strings.Cut(field, "-")
+			if !ok {
+				return nil, fmt.Errorf("opening hours: invalid window %q", field)
+			}
+			window := models.TimeWindow{Open: open, Close: close}
+			if _, err := compileWindow(window); err != nil {
+				return nil, err
+			}
+			for _, day := range days {
+				weekly[day] = append(weekly[day], window)
+			}
+		}
+	}
+	return weekly, nil
+}
+
+// Compile validates store settings and turns them into Hours
+func Compile(settings models.StoreSettings) (Hours, error) {
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		return Hours{}, fmt.Errorf("unknown time zone %q", settings.Timezone)
+	}
+	hours := Hours{
+		Location:   loc,
+		Exceptions: make(map[string][]Window),
+		Holidays:   make(map[string]string),
+	}
+
+	for day, windows := range settings.WeeklyHours {
+		weekday, ok := weekdays[day]
+		if !ok {
+			return Hours{}, fmt.Errorf("opening hours: unknown day %q", day)
+		}
+		for _, tw := range windows {
+			window, err := compileWindow(tw)
+			if err != nil {
+				return Hours{}, err
+			}
+			hours.Days[weekday] = append(hours.Days[weekday], window)
+		}
+	}
+
+	for _, holiday := range settings.Holidays {
+		if _, err := time.Parse(dateLayout, holiday.Date); err != nil {
+			return Hours{}, fmt.Errorf("holiday %q: date must be YYYY-MM-DD", holiday.Date)
+		}
+		if _, dup := hours.Exceptions[holiday.Date]; dup {
+			return Hours{}, fmt.Errorf("holiday %q is listed twice", holiday.Date)
+		}
+		windows := []Window{}
+		for _, tw := range holiday.Hours {
+			window, err := compileWindow(tw)
+			if err != nil {
+				return Hours{}, err
+			}
+			windows = append(windows, window)
+		}
+		hours.Exceptions[holiday.Date] = windows
+		hours.Holidays[holiday.Date] = holiday.Name
+	}
+	return hours, nil
+}
+
+// parseDays parses a day ("mon") or a range of days ("mon-fri", "fri-mon")
+func parseDays(s string) ([]string, error) {
+	from, to, isRange := strings.Cut(strings.ToLower(s), "-")
+	if !isRange {
+		to = from
+	}
+
+	first, ok := weekdays[from]
+	if !ok {
+		return nil, fmt.Errorf("opening hours: unknown day %q", from)
+	}
+	last, ok := weekdays[to]
+	if !ok {
+		return nil, fmt.Errorf("opening hours: unknown day %q", to)
+	}
+
+	days := []string{dayName(first)}
+	for day := first; day != last; {
+		day = (day + 1) % 7
+		days = append(days, dayName(day))
+	}
+	return days, nil
+}
+
+// dayName returns the three letter name weekly hours are keyed by
+func dayName(day time.Weekday) string {
+	return strings.ToLower(day.String()[:3])
+}
+
+// compileWindow validates a window such as 11:00 to 22:00
+func compileWindow(tw models.TimeWindow) (Window, error) {
+	open, err := parseClock(tw.Open)
+	if err != nil {
+		return Window{}, err
+	}
+	close, err := parseClock(tw.Close)
+	if err != nil {
+		return Window{}, err
+	}
+	if close <= open {
+		return Window{}, fmt.Errorf("opening hours: window %s-%s closes before it opens", tw.Open, tw.Close)
+	}
+	return Window{Open: open, Close: close}, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight, 24:00 included
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, hourErr := strconv.Atoi(h)
+	minute, minuteErr := strconv.Atoi(m)
+	if !ok || hourErr != nil || minuteErr != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("opening hours: invalid time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// windowsOn returns the opening windows of a local date, holidays first
+func (h Hours) windowsOn(local time.Time) []Window {
+	if windows, ok := h.Exceptions[local.Format(dateLayout)]; ok {
+		return windows
+	}
+	return h.Days[local.Weekday()]
+}
+
+// IsOpen reports whether the store is open at t
+func (h Hours) IsOpen(t time.Time) bool {
+	local := t.In(h.location())
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, window := range h.windowsOn(local) {
+		if minute >= window.Open && minute < window.Close {
+			return true
+		}
+	}
+	return false
+}
+
+// Holiday returns the name of the holiday on the local date of t, if any
+func (h Hours) Holiday(t time.Time) (string, bool) {
+	name, ok := h.Holidays[t.In(h.location()).Format(dateLayout)]
+	return name, ok
+}
+
+// NextOpening returns the first time at or after t when the store is open.
+// It reports false when the store does not open within the next 60 days.
+func (h Hours) NextOpening(t time.Time) (time.Time, bool) {
+	if h.IsOpen(t) {
+		return t, true
+	}
+
+	local := t.In(h.location())
+	for i := 0; i <= lookAhead; i++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+i, 0, 0, 0, 0, local.Location())
+
+		var next time.Time
+		for _, window := range h.windowsOn(day) {
+			open := time.Date(day.Year(), day.Month(), day.Day(), window.Open/60, window.Open%60, 0, 0, day.Location())
+			if open.After(t) && (next.IsZero() || open.Before(next)) {
+				next = open
+			}
+		}
+		if !next.IsZero() {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (h Hours) location() *time.Location {
+	if h.Location == nil {
+		return time.UTC
+	}
+	return h.Location
+}
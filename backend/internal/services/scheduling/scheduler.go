@@ -0,0 +1,139 @@
+package scheduling
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Lead times from sending an order to the kitchen until it is ready for
+// pickup or at the customer's door
+const (
+	PickupLeadTime   = 18 * time.Minute
+	DeliveryLeadTime = 40 * time.Minute
+)
+
+// MaxAdvance is how far ahead an order can be scheduled
+const MaxAdvance = 7 * 24 * time.Hour
+
+// Errors returned by Schedule
+var (
+	ErrTooSoon       = errors.New("requested time is sooner than the order can be ready")
+	ErrTooFarAhead   = errors.New("orders can be scheduled at most 7 days ahead")
+	ErrOutsideHours  = errors.New("the store is closed at the requested time")
+	ErrKitchenClosed = errors.New("the kitchen is closed when the order would have to be started")
+)
+
+// LeadTime returns the lead time of a delivery type
+func LeadTime(deliveryType string) time.Duration {
+	if deliveryType == "delivery" {
+		return DeliveryLeadTime
+	}
+	return PickupLeadTime
+}
+
+// Schedule validates a requested fulfillment time and returns when the order
+// has to go to the kitchen to be ready at that time. The store must be open
+// both when the kitchen starts the order and at the requested time.
+func Schedule(hours Hours, deliveryType string, requested, now time.Time) (time.Time, error) {
+	releaseAt := requested.Add(-LeadTime(deliveryType))
+
+	switch {
+	case releaseAt.Before(now):
+		return time.Time{}, ErrTooSoon
+	case requested.After(now.Add(MaxAdvance)):
+		return time.Time{}, ErrTooFarAhead
+	case !hours.IsOpen(requested):
+		return time.Time{}, ErrOutsideHours
+	case !hours.IsOpen(releaseAt):
+		return time.Time{}, ErrKitchenClosed
+	}
+	return releaseAt, nil
+}
+
+// OrderStore is the part of the order repository the scheduler uses
+type OrderStore interface {
+	FindDueScheduled(ctx context.Context, now time.Time, limit int64) ([]models.Order, error)
+	TransitionStatus(ctx context.Context, id primitive.ObjectID, from []string, to string, event models.TrackingEvent) (*models.Order, error)
+	UpdateETA(ctx context.Context, id primitive.ObjectID, eta time.Time, breakdown *models.ETABreakdown) error
+}
+
+// ETAEstimator recomputes the ETA of an order after a status change
+type ETAEstimator interface {
+	Estimate(ctx context.Context, order *models.Order, now time.Time) error
+}
+
+// Scheduler moves scheduled orders into the kitchen queue once their release
+// time has come
+type Scheduler struct {
+	orders    OrderStore
+	estimator ETAEstimator
+	interval  time.Duration
+}
+
+// NewScheduler creates a scheduler checking for due orders every interval
+func NewScheduler(orders OrderStore, estimator ETAEstimator, interval time.Duration) *Scheduler {
+	return &Scheduler{orders: orders, estimator: estimator, interval: interval}
+}
+
+// Run releases due orders until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ReleaseDue(ctx, time.Now()); err != nil {
+			log.Printf("Failed to release scheduled orders: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseDue moves every scheduled order due at now to new and returns how
+// many it released. Orders cancelled in the meantime are skipped.
+func (s *Scheduler) ReleaseDue(ctx context.Context, now time.Time) (int, error) {
+	count := 0
+	for {
+		orders, err := s.orders.FindDueScheduled(ctx, now, 100)
+		if err != nil {
+			return count, err
+		}
+		if len(orders) == 0 {
+			return count, nil
+		}
+
+		for _, order := range orders {
+			released, err := s.orders.TransitionStatus(ctx, order.ID, []string{models.OrderStatusScheduled}, models.OrderStatusNew, models.TrackingEvent{
+				Timestamp: now,
+				Status:    models.OrderStatusNew,
+				Note:      "Scheduled order sent to the kitchen",
+			})
+			if err == mongo.ErrNoDocuments {
+				continue // cancelled in the meantime
+			}
+			if err != nil {
+				return count, err
+			}
+			count++
+
+			// The kitchen queue now decides when it is ready
+			if err := s.estimator.Estimate(ctx, released, now); err != nil {
+				log.Printf("Failed to estimate ETA of order %s: %v", released.OrderNumber, err)
+				continue
+			}
+			if err := s.orders.UpdateETA(ctx, released.ID, released.Delivery.ETA, released.Delivery.ETABreakdown); err != nil {
+				log.Printf("Failed to update ETA of order %s: %v", released.OrderNumber, err)
+			}
+		}
+	}
+}
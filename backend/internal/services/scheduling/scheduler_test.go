@@ -0,0 +1,56 @@
+package scheduling
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+func TestSchedule(t *testing.T) {
+	weekly, err := ParseWeeklyHours("mon-sun 11:00-22:00")
+	if err != nil {
+		t.Fatalf("ParseWeeklyHours: %v", err)
+	}
+	hours, err := Compile(models.StoreSettings{
+		Timezone:    "UTC",
+		WeeklyHours: weekly,
+		Holidays:    []models.HolidayException{{Date: "2026-03-20", Name: "Closed for renovation"}},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	now := time.Date(2026, 3, 16, 10, 0, 0, 0, time.UTC) // Monday, before opening
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name         string
+		deliveryType string
+		requested    time.Time
+		wantRelease  time.Time
+		wantErr      error
+	}{
+		{"pickup", "pickup", at(16, 12, 0), at(16, 11, 42), nil},
+		{"delivery", "delivery", at(16, 12, 0), at(16, 11, 20), nil},
+		{"last minute before closing", "pickup", at(16, 21, 59), at(16, 21, 41), nil},
+		{"sooner than the lead time", "pickup", at(16, 10, 10), time.Time{}, ErrTooSoon},
+		{"kitchen not open yet", "pickup", at(16, 11, 10), time.Time{}, ErrKitchenClosed},
+		{"at closing time", "pickup", at(16, 22, 0), time.Time{}, ErrOutsideHours},
+		{"holiday", "delivery", at(20, 12, 0), time.Time{}, ErrOutsideHours},
+		{"next week", "delivery", at(22, 21, 0), at(22, 20, 20), nil},
+		{"more than 7 days ahead", "pickup", at(24, 12, 0), time.Time{}, ErrTooFarAhead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release, err := Schedule(hours, tt.deliveryType, tt.requested, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Schedule error = %v, want %v", err, tt.wantErr)
+			}
+			if !release.Equal(tt.wantRelease) {
+				t.Errorf("release = %v, want %v", release, tt.wantRelease)
+			}
+		})
+	}
+}
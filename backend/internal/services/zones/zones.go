@@ -0,0 +1,153 @@
+package zones
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+// NormalizePostcode upper-cases a postcode and drops spaces, so "sw1a 1aa"
+// matches "SW1A1AA"
+func NormalizePostcode(postcode string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(postcode), ""))
+}
+
+// Validate checks a zone before it is saved and normalizes its postcodes
+func Validate(zone *models.DeliveryZone) error {
+	if strings.TrimSpace(zone.Name) == "" {
+		return errors.New("name is required")
+	}
+	if zone.FeeUSD < 0 || zone.MinOrderUSD < 0 {
+		return errors.New("fee and minimum order must not be negative")
+	}
+	if zone.ETAOffsetMinutes < 0 {
+		return errors.New("ETA offset must not be negative")
+	}
+
+	postcodes := make([]string, 0, len(zone.Postcodes))
+	for _, postcode := range zone.Postcodes {
+		if normalized := NormalizePostcode(postcode); normalized != "" {
+			postcodes = append(postcodes, normalized)
+		}
+	}
+	zone.Postcodes = postcodes
+
+	if zone.Area != nil {
+		if err := validatePolygon(zone.Area); err != nil {
+			return err
+		}
+	}
+	if len(zone.Postcodes) == 0 && zone.Area == nil {
+		return errors.New("a zone needs postcodes or an area")
+	}
+	return nil
+}
+
+// validatePolygon checks the GeoJSON polygon has closed rings of valid positions
+func validatePolygon(polygon *models.GeoPolygon) error {
+	if polygon.Type != "Polygon" {
+		return fmt.Errorf("area must be a GeoJSON Polygon, got %q", polygon.Type)
+	}
+	if len(polygon.Coordinates) == 0 {
+		return errors.New("area has no coordinates")
+	}
+
+	for _, ring := range polygon.Coordinates {
+		if len(ring) < 4 {
+			return errors.New("area rings need at least 4 positions")
+		}
+		for _, position := range ring {
+			if len(position) < 2 || position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+				return errors.New("area positions must be [longitude, latitude]")
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return errors.New("area rings must end where they start")
+		}
+	}
+	return nil
+}
+
+// How an address was matched to its zone
+const (
+	MatchPostcode = "postcode"
+	MatchLocation = "location"
+)
+
+// Resolve returns the active zone delivering to the address and how it was
+// matched, or nil. Postcode matches win over area matches; among several
+// matches the lowest fee wins.
+//
+// Addresses are not geocoded, area matches trust the location the client
+// sent. They are advisory: orders record MatchLocation so staff can check the
+// address before dispatching.
+func Resolve(zones []*models.DeliveryZone, address models.DeliveryAddress) (*models.DeliveryZone, string) {
+	postcode := NormalizePostcode(address.ZipCode)
+
+	var byPostcode, byArea *models.DeliveryZone
+	for _, zone := range zones {
+		if !zone.IsActive {
+			continue
+		}
+		if postcode != "" && hasPostcode(zone, postcode) {
+			byPostcode = cheaper(byPostcode, zone)
+		} else if zone.Area != nil && address.Location != nil && Contains(zone.Area, *address.Location) {
+			byArea = cheaper(byArea, zone)
+		}
+	}
+
+	if byPostcode != nil {
+		return byPostcode, MatchPostcode
+	}
+	if byArea != nil {
+		return byArea, MatchLocation
+	}
+	return nil, ""
+}
+
+func hasPostcode(zone *models.DeliveryZone, postcode string) bool {
+	for _, p := range zone.Postcodes {
+		if p == postcode {
+			return true
+		}
+	}
+	return false
+}
+
+func cheaper(current, candidate *models.DeliveryZone) *models.DeliveryZone {
+	if current == nil || candidate.FeeUSD < current.FeeUSD {
+		return candidate
+	}
+	return current
+}
+
+// Contains reports whether the point lies inside the polygon: inside its
+// outer ring and outside all of its holes
+func Contains(polygon *models.GeoPolygon, point models.GeoPoint) bool {
+	if len(polygon.Coordinates) == 0 || !inRing(polygon.Coordinates[0], point) {
+		return false
+	}
+	for _, hole := range polygon.Coordinates[1:] {
+		if inRing(hole, point) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRing casts a ray from the point and counts the ring edges it crosses.
+// Zones are small enough to treat coordinates as planar.
+func inRing(ring [][]float64, point models.GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > point.Lat) != (yj > point.Lat) && point.Lng < (xj-xi)*(point.Lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,78 @@
+package zones
+
+import (
+	"testing"
+
+	"github.com/fastspot/backend/internal/models"
+)
+
+// square returns the closed ring of the square between min and max on both axes
+func square(min, max float64) [][]float64 {
+	return [][]float64{{min, min}, {max, min}, {max, max}, {min, max}, {min, min}}
+}
+
+func TestContains(t *testing.T) {
+	withHole := &models.GeoPolygon{Type: "Polygon", Coordinates: [][][]float64{square(0, 10), square(4, 6)}}
+	triangle := &models.GeoPolygon{Type: "Polygon", Coordinates: [][][]float64{{{0, 0}, {10, 0}, {0, 10}, {0, 0}}}}
+
+	tests := []struct {
+		name    string
+		polygon *models.GeoPolygon
+		point   models.GeoPoint
+		want    bool
+	}{
+		{"inside", withHole, models.GeoPoint{Lng: 2, Lat: 2}, true},
+		{"between hole and edge", withHole, models.GeoPoint{Lng: 5, Lat: 8}, true},
+		{"in the hole", withHole, models.GeoPoint{Lng: 5, Lat: 5}, false},
+		{"outside", withHole, models.GeoPoint{Lng: 12, Lat: 5}, false},
+		{"lat and lng not swapped", &models.GeoPolygon{Coordinates: [][][]float64{{{0, 0}, {20, 0}, {20, 1}, {0, 1}, {0, 0}}}}, models.GeoPoint{Lng: 15, Lat: 0.5}, true},
+		{"inside the triangle", triangle, models.GeoPoint{Lng: 2, Lat: 2}, true},
+		{"past the hypotenuse", triangle, models.GeoPoint{Lng: 6, Lat: 6}, false},
+		{"no coordinates", &models.GeoPolygon{Type: "Polygon"}, models.GeoPoint{Lng: 2, Lat: 2}, false},
+	}
+	for _, tt := range tests {
+		if got := Contains(tt.polygon, tt.point); got != tt.want {
+			t.Errorf("%s: Contains(%+v) = %v, want %v", tt.name, tt.point, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	central := &models.DeliveryZone{Name: "central", Postcodes: []string{"SW1A1AA"}, FeeUSD: 4, IsActive: true}
+	centralCheap := &models.DeliveryZone{Name: "central cheap", Postcodes: []string{"SW1A1AA"}, FeeUSD: 2, IsActive: true}
+	area := &models.DeliveryZone{Name: "area", Area: &models.GeoPolygon{Type: "Polygon", Coordinates: [][][]float64{square(0, 10), square(4, 6)}}, FeeUSD: 1, IsActive: true}
+	wideArea := &models.DeliveryZone{Name: "wide area", Area: &models.GeoPolygon{Type: "Polygon", Coordinates: [][][]float64{square(-20, 20)}}, FeeUSD: 3, IsActive: true}
+	closed := &models.DeliveryZone{Name: "closed", Postcodes: []string{"E11AA"}, FeeUSD: 0, IsActive: false}
+
+	at := func(lng, lat float64) *models.GeoPoint { return &models.GeoPoint{Lng: lng, Lat: lat} }
+	tests := []struct {
+		name      string
+		zones     []*models.DeliveryZone
+		address   models.DeliveryAddress
+		wantZone  string
+		wantMatch string
+	}{
+		{"postcode normalized", []*models.DeliveryZone{central}, models.DeliveryAddress{ZipCode: " sw1a 1aa "}, "central", MatchPostcode},
+		{"postcode wins over a cheaper area", []*models.DeliveryZone{area, central}, models.DeliveryAddress{ZipCode: "SW1A 1AA", Location: at(2, 2)}, "central", MatchPostcode},
+		{"cheapest postcode zone", []*models.DeliveryZone{central, centralCheap}, models.DeliveryAddress{ZipCode: "SW1A1AA"}, "central cheap", MatchPostcode},
+		{"area by location", []*models.DeliveryZone{central, area}, models.DeliveryAddress{ZipCode: "N11AA", Location: at(2, 2)}, "area", MatchLocation},
+		{"cheapest area", []*models.DeliveryZone{wideArea, area}, models.DeliveryAddress{Location: at(2, 2)}, "area", MatchLocation},
+		{"hole falls through to the next area", []*models.DeliveryZone{area, wideArea}, models.DeliveryAddress{Location: at(5, 5)}, "wide area", MatchLocation},
+		{"area without location", []*models.DeliveryZone{area}, models.DeliveryAddress{ZipCode: "N11AA"}, "", ""},
+		{"inactive zone", []*models.DeliveryZone{closed}, models.DeliveryAddress{ZipCode: "E1 1AA"}, "", ""},
+		{"nothing matches", []*models.DeliveryZone{central, area}, models.DeliveryAddress{ZipCode: "N11AA", Location: at(30, 30)}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zone, match := Resolve(tt.zones, tt.address)
+			name := ""
+			if zone != nil {
+				name = zone.Name
+			}
+			if name != tt.wantZone || match != tt.wantMatch {
+				t.Errorf("Resolve = %q by %q, want %q by %q", name, match, tt.wantZone, tt.wantMatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Package testutil holds helpers shared by the tests of several packages
+package testutil
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoURIEnv names the environment variable with the MongoDB the
+// integration tests run against, e.g. mongodb://localhost:27017
+const MongoURIEnv = "MONGODB_TEST_URI"
+
+// Database returns an empty database on the test MongoDB, dropped when the
+// test ends. The test is skipped when MONGODB_TEST_URI is not set.
+func Database(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv(MongoURIEnv)
+	if uri == "" {
+		t.Skipf("%s not set, skipping test that needs MongoDB", MongoURIEnv)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("ping MongoDB: %v", err)
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("database name: %v", err)
+	}
+	db := client.Database("fastspot_test_" + hex.EncodeToString(suffix))
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.Drop(ctx); err != nil {
+			t.Logf("drop test database %s: %v", db.Name(), err)
+		}
+		client.Disconnect(ctx)
+	})
+	return db
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "github.com/gin-gonic/gin"
+
+// RespondError writes the standard error envelope with a machine-readable code
+func RespondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, ErrorBody(code, message, nil))
+}
+
+// ErrorBody builds the standard error envelope. fields add context to the
+// error, such as the statuses an order may move to instead.
+func ErrorBody(code, message string, fields gin.H) gin.H {
+	body := gin.H{
+		"code":    code,
+		"message": message,
+	}
+	for key, value := range fields {
+		body[key] = value
+	}
+	return gin.H{
+		"success": false,
+		"error":   body,
+	}
+}
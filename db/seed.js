@@ -45,6 +45,14 @@ async function seed() {
         phone: '+1234567890',
         passwordHash: adminPassword,
         createdAt: new Date()
+      },
+      {
+        role: 'courier',
+        name: 'Courier',
+        email: 'courier@local',
+        phone: '+1234567891',
+        passwordHash: await bcrypt.hash('Courier123!', 10),
+        createdAt: new Date()
       }
     ]);
     console.log(`✅ Users created: ${usersResult.insertedCount}`);
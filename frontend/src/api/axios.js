@@ -11,26 +11,12 @@ const apiClient = axios.create({
   timeout: 60000 // 60 seconds for AI recommendations (Gemini thinking mode can take 30+ seconds)
 })
 
-// Generate or get session ID for guest users
-function getSessionID() {
-  let sessionId = localStorage.getItem('session_id')
-  if (!sessionId) {
-    sessionId = 'guest_' + Math.random().toString(36).substring(2) + Date.now()
-    localStorage.setItem('session_id', sessionId)
-  }
-  return sessionId
-}
-
-// Request interceptor - add JWT token or session ID
+// Request interceptor - add JWT token (user token or server-issued guest token)
 apiClient.interceptors.request.use(
   (config) => {
     const token = localStorage.getItem('auth_token')
     if (token) {
       config.headers.Authorization = `Bearer ${token}`
-    } else {
-      // For guest users, send session ID
-      const sessionId = getSessionID()
-      config.headers['X-Session-ID'] = sessionId
     }
     return config
   },
@@ -39,12 +25,50 @@ apiClient.interceptors.request.use(
   }
 )
 
+// Exchange the stored refresh token for a new token pair (one request at a time)
+let refreshPromise = null
+function refreshTokens() {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem('refresh_token')
+    refreshPromise = axios
+      .post(`${apiClient.defaults.baseURL}/auth/refresh`, { refreshToken })
+      .then(({ data }) => {
+        localStorage.setItem('auth_token', data.token)
+        localStorage.setItem('refresh_token', data.refreshToken)
+        return data.token
+      })
+      .finally(() => {
+        refreshPromise = null
+      })
+  }
+  return refreshPromise
+}
+
 // Response interceptor - handle errors
 apiClient.interceptors.response.use(
   (response) => {
     return response
   },
-  (error) => {
+  async (error) => {
+    // Access tokens are short-lived - refresh once and retry the request
+    const original = error.config
+    if (
+      error.response?.status === 401 &&
+      original &&
+      !original._retried &&
+      !original.url?.startsWith('/auth/') &&
+      localStorage.getItem('refresh_token')
+    ) {
+      original._retried = true
+      try {
+        const token = await refreshTokens()
+        original.headers.Authorization = `Bearer ${token}`
+        return apiClient(original)
+      } catch (refreshError) {
+        localStorage.removeItem('refresh_token')
+      }
+    }
+
     if (error.response) {
       // Server responded with error status
       switch (error.response.status) {
@@ -56,6 +80,7 @@ apiClient.interceptors.response.use(
           
           if (!isLoginPage && isAdminRoute) {
             localStorage.removeItem('auth_token')
+            localStorage.removeItem('refresh_token')
             localStorage.removeItem('user')
             window.location.href = '/admin/login'
           }
@@ -22,7 +22,7 @@ export const authAPI = {
   
   // Get current user
   me() {
-    return apiClient.get('/users/me')
+    return apiClient.get('/auth/me')
   }
 }
 
@@ -136,8 +136,9 @@ export const ordersAPI = {
     return apiClient.get(`/orders/${id}`)
   },
   
-  create(data) {
-    return apiClient.post('/orders', data)
+  create(data, idempotencyKey) {
+    const headers = idempotencyKey ? { 'Idempotency-Key': idempotencyKey } : {}
+    return apiClient.post('/orders', data, { headers })
   },
   
   // Admin methods
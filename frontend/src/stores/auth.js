@@ -28,8 +28,9 @@ export const useAuthStore = defineStore('auth', () => {
     if (savedToken && savedUser) {
       token.value = savedToken
       user.value = JSON.parse(savedUser)
-    } else if (savedSessionId) {
+    } else if (savedSessionId && savedToken) {
       sessionId.value = savedSessionId
+      token.value = savedToken
     } else {
       // Create guest session
       await createGuestSession()
@@ -71,6 +72,7 @@ export const useAuthStore = defineStore('auth', () => {
       
       localStorage.setItem('user', JSON.stringify(user.value))
       localStorage.setItem('auth_token', token.value)
+      localStorage.setItem('refresh_token', data.refreshToken)
       localStorage.removeItem('session_id')
       
       return true
@@ -95,6 +97,7 @@ export const useAuthStore = defineStore('auth', () => {
       
       localStorage.removeItem('user')
       localStorage.removeItem('auth_token')
+      localStorage.removeItem('refresh_token')
       localStorage.removeItem('session_id')
       
       // Create new guest session
@@ -11,6 +11,8 @@ export const useOrdersStore = defineStore('orders', () => {
   const order = ref(null)
   const loading = ref(false)
   const error = ref(null)
+  // Reused until the order succeeds, so a double submit or retry cannot order twice
+  let checkoutKey = null
 
   // Actions
   async function fetchOrders(params = {}) {
@@ -47,8 +49,10 @@ export const useOrdersStore = defineStore('orders', () => {
     try {
       loading.value = true
       error.value = null
-      const { data } = await ordersAPI.create(orderData)
+      checkoutKey = checkoutKey || crypto.randomUUID()
+      const { data } = await ordersAPI.create(orderData, checkoutKey)
       order.value = data.data
+      checkoutKey = null
       return order.value
     } catch (err) {
       error.value = err.response?.data?.error?.message || 'Failed to create order'
@@ -74,11 +78,11 @@ export const useOrdersStore = defineStore('orders', () => {
     }
   }
 
-  async function updateOrderStatus(id, status, note = '') {
+  async function updateOrderStatus(id, status, version, note = '') {
     try {
       loading.value = true
       error.value = null
-      const { data } = await ordersAPI.updateStatus(id, { status, note })
+      const { data } = await ordersAPI.updateStatus(id, { status, version, note })
       
       // Обновляем в списке
       const index = orders.value.findIndex(o => o.id === id)